@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/ab0utbla-k/rvt-hello-app/internal/data"
+	"github.com/ab0utbla-k/rvt-hello-app/internal/validator"
+)
+
+const apiKeyUsage = "usage: apikey create NAME SCOPE... | list | revoke ID"
+
+// runAPIKeyCommand connects to the configured database and runs the apikey
+// subcommand given by args. It is how the first admin key is created.
+func runAPIKeyCommand(cfg config, logger *slog.Logger, args []string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	db, err := connectDB(ctx, cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	models := data.NewModels(db)
+	if cfg.db.driver == "sqlite" {
+		models = data.NewSQLiteModels(db)
+	}
+
+	return apiKeyCommand(models.APIKeys, args, os.Stdout)
+}
+
+func apiKeyCommand(keys data.APIKeyStore, args []string, w io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(apiKeyUsage)
+	}
+
+	switch args[0] {
+	case "create":
+		if len(args) < 3 {
+			return errors.New("create: expected a name and at least one scope")
+		}
+		return createAPIKey(keys, args[1], args[2:], w)
+
+	case "list":
+		return listAPIKeys(keys, w)
+
+	case "revoke":
+		if len(args) != 2 {
+			return errors.New("revoke: expected exactly one key ID")
+		}
+
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("revoke: invalid key ID %q", args[1])
+		}
+
+		err = keys.Revoke(id)
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return fmt.Errorf("revoke: no active key with ID %d", id)
+		}
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(w, "revoked key %d\n", id)
+		return nil
+
+	default:
+		return errors.New(apiKeyUsage)
+	}
+}
+
+func createAPIKey(keys data.APIKeyStore, name string, scopes []string, w io.Writer) error {
+	key, err := data.GenerateAPIKey(name, scopes)
+	if err != nil {
+		return err
+	}
+
+	v := validator.New()
+	data.ValidateAPIKey(v, key)
+
+	if !v.Valid() {
+		field := slices.Min(slices.Collect(maps.Keys(v.Errors)))
+		return fmt.Errorf("create: %s %s", field, v.Errors[field].Message)
+	}
+
+	err = keys.Insert(key)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "id: %d\n", key.ID)
+	fmt.Fprintf(w, "key: %s\n", key.Plaintext)
+	fmt.Fprintln(w, "store the key now, it cannot be shown again")
+
+	return nil
+}
+
+func listAPIKeys(keys data.APIKeyStore, w io.Writer) error {
+	list, err := keys.List()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tSCOPES\tCREATED\tSTATUS")
+
+	for _, key := range list {
+		status := "active"
+		if key.RevokedAt != nil {
+			status = "revoked"
+		}
+
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n",
+			key.ID, key.Name, strings.Join(key.Scopes, ","), key.CreatedAt.UTC().Format("2006-01-02 15:04:05"), status)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+
+	"github.com/ab0utbla-k/rvt-hello-app/internal/data"
+	"github.com/ab0utbla-k/rvt-hello-app/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIKeyCommand(t *testing.T) {
+	t.Parallel()
+
+	keys := data.NewSQLiteModels(testutils.SetupSQLiteTestDB(t)).APIKeys
+
+	run := func(args ...string) (string, error) {
+		t.Helper()
+
+		var out bytes.Buffer
+		err := apiKeyCommand(keys, args, &out)
+		return out.String(), err
+	}
+
+	out, err := run("create", "ops", "admin")
+	require.NoError(t, err)
+	assert.Regexp(t, `^id: 1\nkey: hk_[a-z2-7]{26}\n`, out)
+
+	plaintext := regexp.MustCompile(`hk_\w+`).FindString(out)
+	key, err := keys.GetByPlaintext(plaintext)
+	require.NoError(t, err)
+	assert.Equal(t, []string{data.ScopeAdmin}, key.Scopes)
+
+	out, err = run("list")
+	require.NoError(t, err)
+	assert.Regexp(t, `1\s+ops\s+admin\s+\S+ \S+\s+active`, out)
+	assert.NotContains(t, out, plaintext)
+
+	out, err = run("revoke", "1")
+	require.NoError(t, err)
+	assert.Equal(t, "revoked key 1\n", out)
+
+	out, err = run("list")
+	require.NoError(t, err)
+	assert.Regexp(t, `1\s+ops\s+admin\s+\S+ \S+\s+revoked`, out)
+
+	_, err = keys.GetByPlaintext(plaintext)
+	assert.ErrorIs(t, err, data.ErrRecordNotFound)
+}
+
+func TestAPIKeyCommand_InvalidArgs(t *testing.T) {
+	t.Parallel()
+
+	keys := data.NewSQLiteModels(testutils.SetupSQLiteTestDB(t)).APIKeys
+
+	tests := []struct {
+		name        string
+		args        []string
+		expectError string
+	}{
+		{name: "no subcommand", args: nil, expectError: apiKeyUsage},
+		{name: "unknown subcommand", args: []string{"rotate"}, expectError: apiKeyUsage},
+		{name: "create without scopes", args: []string{"create", "ops"}, expectError: "create: expected a name and at least one scope"},
+		{name: "create with unknown scope", args: []string{"create", "ops", "delete"}, expectError: "create: scopes must only contain read, write, admin"},
+		{name: "revoke without id", args: []string{"revoke"}, expectError: "revoke: expected exactly one key ID"},
+		{name: "revoke with invalid id", args: []string{"revoke", "one"}, expectError: `revoke: invalid key ID "one"`},
+		{name: "revoke unknown key", args: []string{"revoke", "42"}, expectError: "revoke: no active key with ID 42"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := apiKeyCommand(keys, tt.args, &out)
+			assert.EqualError(t, err, tt.expectError)
+		})
+	}
+}
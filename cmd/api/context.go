@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/ab0utbla-k/rvt-hello-app/internal/data"
+)
+
+type contextKey string
+
+const callerContextKey = contextKey("caller")
+
+// caller is who a request acts for: a service holding an API key, an end
+// user signed in with a JWT, or anonymousCaller.
+type caller struct {
+	// apiKeyID is set for API keys, and username, the JWT subject, for end
+	// users.
+	apiKeyID int64
+	username string
+	scopes   []string
+}
+
+// anonymousCaller is the caller of requests without an Authorization header.
+var anonymousCaller = &caller{}
+
+func (c *caller) isAnonymous() bool {
+	return c == anonymousCaller
+}
+
+func (c *caller) hasScope(scope string) bool {
+	return data.GrantsScope(c.scopes, scope)
+}
+
+// canActFor reports whether the caller may change username's record. API
+// keys and admins may change any; end users only their own.
+func (c *caller) canActFor(username string) bool {
+	return c.apiKeyID != 0 || (c.username != "" && c.username == username) || c.hasScope(data.ScopeAdmin)
+}
+
+// credential identifies the caller's API key or end user, for keying rate
+// limits and idempotency keys. It is empty for anonymous callers.
+func (c *caller) credential() string {
+	switch {
+	case c.apiKeyID != 0:
+		return "key:" + strconv.FormatInt(c.apiKeyID, 10)
+	case c.username != "":
+		return "user:" + c.username
+	default:
+		return ""
+	}
+}
+
+func (app *application) contextSetCaller(r *http.Request, c *caller) *http.Request {
+	ctx := context.WithValue(r.Context(), callerContextKey, c)
+	return r.WithContext(ctx)
+}
+
+// contextGetCaller returns the caller authenticate added. Only call it from
+// handlers behind authenticate.
+func (app *application) contextGetCaller(r *http.Request) *caller {
+	c, ok := r.Context().Value(callerContextKey).(*caller)
+	if !ok {
+		panic("missing caller value in request context")
+	}
+
+	return c
+}
@@ -1,10 +1,45 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"mime"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ab0utbla-k/rvt-hello-app/internal/i18n"
+	"github.com/ab0utbla-k/rvt-hello-app/internal/validator"
+)
+
+const (
+	errorFormatLegacy  = "legacy"
+	errorFormatProblem = "problem"
 )
 
+// problem is an RFC 9457 problem details body. Errors carries one entry per
+// invalid field when the request failed validation.
+type problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Errors   []fieldError `json:"errors,omitempty"`
+}
+
+// fieldError is the wire form of a validator.FieldError, sent in the
+// "errors" array of both error formats.
+type fieldError struct {
+	Field   string         `json:"field"`
+	Code    string         `json:"code"`
+	Message string         `json:"message"`
+	Params  map[string]any `json:"params,omitempty"`
+}
+
 func (app *application) logError(r *http.Request, err error) {
 	var (
 		method = r.Method
@@ -15,7 +50,26 @@ func (app *application) logError(r *http.Request, err error) {
 }
 
 func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message any) {
+	app.writeError(w, r, status, message, nil)
+}
+
+// writeError sends message either in the legacy {"error": message} envelope
+// or as application/problem+json, depending on wantsProblem. fieldErrors,
+// when present, go in the "errors" member of either format.
+func (app *application) writeError(w http.ResponseWriter, r *http.Request, status int, message any, fieldErrors []fieldError) {
+	w.Header().Add("Vary", "Accept")
+	w.Header().Add("Vary", "Accept-Language")
+	w.Header().Set("Content-Language", app.locale(r))
+
+	if app.wantsProblem(r) {
+		app.problemResponse(w, r, status, message, fieldErrors)
+		return
+	}
+
 	env := envelope{"error": message}
+	if fieldErrors != nil {
+		env["errors"] = fieldErrors
+	}
 
 	err := app.writeJSON(w, status, env, nil)
 	if err != nil {
@@ -24,27 +78,195 @@ func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, st
 	}
 }
 
+func (app *application) problemResponse(w http.ResponseWriter, r *http.Request, status int, message any, fieldErrors []fieldError) {
+	p := problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Instance: r.URL.RequestURI(),
+		Errors:   fieldErrors,
+	}
+
+	switch message := message.(type) {
+	case string:
+		p.Detail = message
+	case map[string]string:
+		p.Detail = i18n.T(app.locale(r), "error.invalid_fields", nil)
+	default:
+		p.Detail = fmt.Sprint(message)
+	}
+
+	js, err := json.Marshal(p)
+	if err != nil {
+		app.logError(r, err)
+		w.WriteHeader(500)
+		return
+	}
+
+	js = append(js, '\n')
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	w.Write(js)
+}
+
+// wantsProblem reports whether the client should get problem details. An
+// Accept header that ranks application/problem+json at least as high as
+// application/json picks problem details, one that only names
+// application/json or refuses problem details with q=0 picks the legacy
+// format, and anything else falls back to the configured default.
+func (app *application) wantsProblem(r *http.Request) bool {
+	var problemQ, jsonQ float64 = -1, -1
+
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(accepted)
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+		}
+
+		switch mediaType {
+		case "application/problem+json":
+			problemQ = max(problemQ, q)
+		case "application/json":
+			jsonQ = max(jsonQ, q)
+		}
+	}
+
+	switch {
+	case problemQ > 0 && problemQ >= jsonQ:
+		return true
+	case jsonQ > 0 || problemQ == 0:
+		return false
+	default:
+		return app.config.errorFormat == errorFormatProblem
+	}
+}
+
 func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
 	app.logError(r, err)
 
-	message := "the server encountered a problem and could not process your request"
+	message := i18n.T(app.locale(r), "error.server", nil)
 	app.errorResponse(w, r, http.StatusInternalServerError, message)
 }
 
 func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request) {
-	message := "the requested resource could not be found"
+	message := i18n.T(app.locale(r), "error.not_found", nil)
 	app.errorResponse(w, r, http.StatusNotFound, message)
 }
 
 func (app *application) methodNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
-	message := fmt.Sprintf("the %s method is not supported for this resource", r.Method)
+	message := i18n.T(app.locale(r), "error.method_not_allowed", map[string]any{"method": r.Method})
 	app.errorResponse(w, r, http.StatusMethodNotAllowed, message)
 }
 
+// badRequestResponse localizes err when it comes from the catalog and sends
+// it as is otherwise.
 func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
-	app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+	message := err.Error()
+
+	var localized *i18n.Error
+	if errors.As(err, &localized) {
+		message = localized.Localize(app.locale(r))
+	}
+
+	app.errorResponse(w, r, http.StatusBadRequest, message)
+}
+
+// failedValidationResponse keeps the legacy field-to-message map in "error"
+// and lists each failure's code and params in "errors", ordered by field.
+// Messages are localized by code when the catalog knows it.
+func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]validator.FieldError) {
+	locale := app.locale(r)
+
+	messages := make(map[string]string, len(errors))
+	fieldErrors := make([]fieldError, 0, len(errors))
+
+	for field, err := range errors {
+		message := err.Message
+		if i18n.Has(err.Code) {
+			message = i18n.T(locale, err.Code, err.Params)
+		}
+
+		messages[field] = message
+		fieldErrors = append(fieldErrors, fieldError{
+			Field:   field,
+			Code:    err.Code,
+			Message: message,
+			Params:  err.Params,
+		})
+	}
+
+	sort.Slice(fieldErrors, func(i, j int) bool {
+		return fieldErrors[i].Field < fieldErrors[j].Field
+	})
+
+	app.writeError(w, r, http.StatusUnprocessableEntity, messages, fieldErrors)
+}
+
+func (app *application) serviceUnavailableResponse(w http.ResponseWriter, r *http.Request) {
+	message := i18n.T(app.locale(r), "error.service_unavailable", nil)
+	app.errorResponse(w, r, http.StatusServiceUnavailable, message)
+}
+
+// authenticationRequiredResponse is sent when a route needs an API key and
+// the request did not present one.
+func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+
+	message := i18n.T(app.locale(r), "error.authentication_required", nil)
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+// invalidCredentialsResponse is sent for a bearer token that is not an
+// active API key or a valid JWT.
+func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+
+	message := i18n.T(app.locale(r), "error.invalid_credentials", nil)
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+// notPermittedResponse is sent when the request's credentials lack scope.
+func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request, scope string) {
+	message := i18n.T(app.locale(r), "error.not_permitted", map[string]any{"scope": scope})
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// notOwnerResponse is sent when an end user tries to change someone else's
+// record.
+func (app *application) notOwnerResponse(w http.ResponseWriter, r *http.Request) {
+	message := i18n.T(app.locale(r), "error.not_owner", nil)
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// rateLimitExceededResponse tells the client to wait retryAfter, rounded up
+// to whole seconds, before trying again.
+func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(retryAfter)))
+
+	message := i18n.T(app.locale(r), "error.rate_limit_exceeded", nil)
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
+// idempotencyKeyInUseResponse is sent for a retry that arrives while the
+// first request with its Idempotency-Key is still being handled.
+func (app *application) idempotencyKeyInUseResponse(w http.ResponseWriter, r *http.Request) {
+	message := i18n.T(app.locale(r), "error.idempotency_key_in_use", nil)
+	app.errorResponse(w, r, http.StatusConflict, message)
 }
 
-func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
-	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
+// idempotencyKeyReusedResponse is sent when an Idempotency-Key comes back
+// with a different method, path or body than the request it was first
+// used for.
+func (app *application) idempotencyKeyReusedResponse(w http.ResponseWriter, r *http.Request) {
+	message := i18n.T(app.locale(r), "error.idempotency_key_reused", nil)
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, message)
 }
@@ -9,6 +9,8 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/ab0utbla-k/rvt-hello-app/internal/i18n"
+	"github.com/ab0utbla-k/rvt-hello-app/internal/validator"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -110,24 +112,27 @@ func Test_FailedValidationResponse_Returns422(t *testing.T) {
 
 	tests := []struct {
 		name             string
-		validationErrors map[string]string
+		validationErrors map[string]validator.FieldError
 		expectedFields   map[string]string
+		expectedCodes    []string
 	}{
 		{
 			name: "WithValidationErrors",
-			validationErrors: map[string]string{
-				"username":    "must contain only letters",
-				"dateOfBirth": "must be in the past",
+			validationErrors: map[string]validator.FieldError{
+				"username":    {Code: "username.invalid_chars", Message: "must contain only letters"},
+				"dateOfBirth": {Code: "dateOfBirth.in_future", Message: "must be in the past"},
 			},
 			expectedFields: map[string]string{
 				"username":    "must contain only letters",
 				"dateOfBirth": "must be in the past",
 			},
+			expectedCodes: []string{"dateOfBirth.in_future", "username.invalid_chars"},
 		},
 		{
 			name:             "EmptyValidationErrors",
-			validationErrors: map[string]string{},
+			validationErrors: map[string]validator.FieldError{},
 			expectedFields:   map[string]string{},
+			expectedCodes:    []string{},
 		},
 	}
 
@@ -143,21 +148,21 @@ func Test_FailedValidationResponse_Returns422(t *testing.T) {
 			assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
 			assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
 
-			var response envelope
+			var response struct {
+				Error  map[string]string `json:"error"`
+				Errors []fieldError      `json:"errors"`
+			}
 			err := json.Unmarshal(w.Body.Bytes(), &response)
 			require.NoError(t, err)
 
-			// convert any to map
-			errorMap, ok := response["error"].(map[string]any)
-			require.True(t, ok)
+			assert.Equal(t, tt.expectedFields, response.Error)
 
-			if len(tt.expectedFields) == 0 {
-				assert.Empty(t, errorMap)
-			} else {
-				for field, expectedMsg := range tt.expectedFields {
-					assert.Equal(t, expectedMsg, errorMap[field])
-				}
+			codes := []string{}
+			for _, fe := range response.Errors {
+				assert.Equal(t, tt.expectedFields[fe.Field], fe.Message)
+				codes = append(codes, fe.Code)
 			}
+			assert.Equal(t, tt.expectedCodes, codes)
 		})
 	}
 }
@@ -189,3 +194,213 @@ func Test_BadRequestResponse_ExposesErrorMessage(t *testing.T) {
 	assert.Contains(t, response["error"], "validation failed")
 	assert.Contains(t, response["error"], "email format")
 }
+
+// Test_ErrorResponse_ProblemDetails verifies that clients asking for
+// application/problem+json get RFC 9457 bodies, with validation failures
+// listed per field in the errors extension.
+func Test_ErrorResponse_ProblemDetails(t *testing.T) {
+	t.Parallel()
+
+	app := &application{
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	tests := []struct {
+		name         string
+		status       int
+		message      any
+		fieldErrors  map[string]validator.FieldError
+		expectDetail string
+		expectErrors []fieldError
+	}{
+		{
+			name:         "StringMessage",
+			status:       http.StatusNotFound,
+			message:      "the requested resource could not be found",
+			expectDetail: "the requested resource could not be found",
+		},
+		{
+			name:   "ValidationErrors",
+			status: http.StatusUnprocessableEntity,
+			fieldErrors: map[string]validator.FieldError{
+				"username": {
+					Code:    "username.invalid_chars",
+					Message: "must contain only letters",
+					Params:  map[string]any{"pattern": "^[a-zA-Z]+$"},
+				},
+				"dateOfBirth": {Code: "dateOfBirth.in_future", Message: "must be in the past"},
+			},
+			expectDetail: "the request contains invalid fields",
+			expectErrors: []fieldError{
+				{Field: "dateOfBirth", Code: "dateOfBirth.in_future", Message: "must be in the past"},
+				{
+					Field:   "username",
+					Code:    "username.invalid_chars",
+					Message: "must contain only letters",
+					Params:  map[string]any{"pattern": "^[a-zA-Z]+$"},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/v1/hello/john?x=1", nil)
+			r.Header.Set("Accept", "application/problem+json")
+
+			if tt.fieldErrors != nil {
+				app.failedValidationResponse(w, r, tt.fieldErrors)
+			} else {
+				app.errorResponse(w, r, tt.status, tt.message)
+			}
+
+			assert.Equal(t, tt.status, w.Code)
+			assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+			assert.Equal(t, "Accept", w.Header().Get("Vary"))
+
+			var response problem
+			err := json.Unmarshal(w.Body.Bytes(), &response)
+			require.NoError(t, err)
+
+			assert.Equal(t, "about:blank", response.Type)
+			assert.Equal(t, http.StatusText(tt.status), response.Title)
+			assert.Equal(t, tt.status, response.Status)
+			assert.Equal(t, tt.expectDetail, response.Detail)
+			assert.Equal(t, "/v1/hello/john?x=1", response.Instance)
+			assert.Equal(t, tt.expectErrors, response.Errors)
+		})
+	}
+}
+
+func Test_WantsProblem(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		errorFormat   string
+		accept        string
+		expectProblem bool
+	}{
+		{name: "NoAcceptLegacyDefault", errorFormat: errorFormatLegacy, accept: "", expectProblem: false},
+		{name: "NoAcceptProblemDefault", errorFormat: errorFormatProblem, accept: "", expectProblem: true},
+		{name: "WildcardProblemDefault", errorFormat: errorFormatProblem, accept: "*/*", expectProblem: true},
+		{name: "ProblemRequested", errorFormat: errorFormatLegacy, accept: "application/problem+json", expectProblem: true},
+		{name: "JSONRequested", errorFormat: errorFormatProblem, accept: "application/json", expectProblem: false},
+		{name: "ProblemPreferred", errorFormat: errorFormatLegacy, accept: "application/json;q=0.5, application/problem+json", expectProblem: true},
+		{name: "JSONPreferred", errorFormat: errorFormatProblem, accept: "application/problem+json;q=0.2, application/json", expectProblem: false},
+		{name: "ProblemRefused", errorFormat: errorFormatProblem, accept: "application/problem+json;q=0", expectProblem: false},
+		{name: "Malformed", errorFormat: errorFormatLegacy, accept: "application/problem+json;q=abc", expectProblem: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			app := &application{}
+			app.config.errorFormat = tt.errorFormat
+
+			r := httptest.NewRequest(http.MethodGet, "/test", nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+
+			assert.Equal(t, tt.expectProblem, app.wantsProblem(r))
+		})
+	}
+}
+
+// Test_ErrorResponses_Localized verifies that error messages follow the
+// request's Accept-Language header, including validation messages looked
+// up by code and errors returned by readJSON.
+func Test_ErrorResponses_Localized(t *testing.T) {
+	t.Parallel()
+
+	app := &application{
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	tests := []struct {
+		name           string
+		acceptLanguage string
+		respond        func(w http.ResponseWriter, r *http.Request)
+		expectLanguage string
+		expectError    any
+	}{
+		{
+			name:           "NotFoundGerman",
+			acceptLanguage: "de-DE",
+			respond:        app.notFoundResponse,
+			expectLanguage: "de",
+			expectError:    "die angeforderte Ressource wurde nicht gefunden",
+		},
+		{
+			name:           "MethodNotAllowedRussian",
+			acceptLanguage: "ru",
+			respond:        app.methodNotAllowedResponse,
+			expectLanguage: "ru",
+			expectError:    "метод POST не поддерживается для этого ресурса",
+		},
+		{
+			name:           "BadRequestFromCatalog",
+			acceptLanguage: "de",
+			respond: func(w http.ResponseWriter, r *http.Request) {
+				app.badRequestResponse(w, r, &i18n.Error{Key: "request.empty"})
+			},
+			expectLanguage: "de",
+			expectError:    "der Body darf nicht leer sein",
+		},
+		{
+			name:           "BadRequestPlainError",
+			acceptLanguage: "de",
+			respond: func(w http.ResponseWriter, r *http.Request) {
+				app.badRequestResponse(w, r, errors.New("something else"))
+			},
+			expectLanguage: "de",
+			expectError:    "something else",
+		},
+		{
+			name:           "FailedValidationRussian",
+			acceptLanguage: "ru",
+			respond: func(w http.ResponseWriter, r *http.Request) {
+				app.failedValidationResponse(w, r, map[string]validator.FieldError{
+					"dateOfBirth": {Code: "dateOfBirth.in_future", Message: "must be in the past"},
+					"nickname":    {Code: "nickname.unknown_code", Message: "kept as is"},
+				})
+			},
+			expectLanguage: "ru",
+			expectError: map[string]any{
+				"dateOfBirth": "должна быть в прошлом",
+				"nickname":    "kept as is",
+			},
+		},
+		{
+			name:           "UnsupportedLanguage",
+			acceptLanguage: "fr",
+			respond:        app.notFoundResponse,
+			expectLanguage: "en",
+			expectError:    "the requested resource could not be found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/test", nil)
+			r.Header.Set("Accept-Language", tt.acceptLanguage)
+
+			tt.respond(w, r)
+
+			assert.Equal(t, tt.expectLanguage, w.Header().Get("Content-Language"))
+			assert.Contains(t, w.Header().Values("Vary"), "Accept-Language")
+
+			var response envelope
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Equal(t, tt.expectError, response["error"])
+		})
+	}
+}
@@ -18,3 +18,26 @@ func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Reques
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// livezHandler reports that the process is up and serving HTTP, whether or
+// not the database is reachable yet.
+func (app *application) livezHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"status": "alive"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readyzHandler reports whether the database is connected and migrated, so
+// that load balancers only route traffic to instances that can serve it.
+func (app *application) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.ready.Load() {
+		app.serviceUnavailableResponse(w, r)
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"status": "ready"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -3,10 +3,15 @@ package main
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
 	"net/http"
+	"net/netip"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/ab0utbla-k/rvt-hello-app/internal/i18n"
+	"github.com/julienschmidt/httprouter"
 )
 
 type envelope map[string]any
@@ -45,26 +50,26 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 
 		switch {
 		case errors.As(err, &syntaxError):
-			return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxError.Offset)
+			return &i18n.Error{Key: "request.json_syntax_at", Args: map[string]any{"offset": syntaxError.Offset}}
 
 		case errors.Is(err, io.ErrUnexpectedEOF):
-			return errors.New("body contains badly-formed JSON")
+			return &i18n.Error{Key: "request.json_syntax"}
 
 		case errors.As(err, &unmarshalTypeError):
 			if unmarshalTypeError.Field != "" {
-				return fmt.Errorf("body contains incorrect JSON type for field %q", unmarshalTypeError.Field)
+				return &i18n.Error{Key: "request.json_type_field", Args: map[string]any{"field": strconv.Quote(unmarshalTypeError.Field)}}
 			}
-			return fmt.Errorf("body contains incorrect JSON type (at character %d)", unmarshalTypeError.Offset)
+			return &i18n.Error{Key: "request.json_type_at", Args: map[string]any{"offset": unmarshalTypeError.Offset}}
 
 		case errors.Is(err, io.EOF):
-			return errors.New("body must not be empty")
+			return &i18n.Error{Key: "request.empty"}
 
 		case strings.HasPrefix(err.Error(), "json: unknown field "):
 			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
-			return fmt.Errorf("body contains unknown key %s", fieldName)
+			return &i18n.Error{Key: "request.unknown_key", Args: map[string]any{"key": fieldName}}
 
 		case errors.As(err, &maxBytesError):
-			return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+			return &i18n.Error{Key: "request.too_large", Args: map[string]any{"limit": maxBytesError.Limit}}
 
 		case errors.As(err, &invalidUnmarshalError):
 			panic(err)
@@ -76,8 +81,137 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 
 	err = dec.Decode(&struct{}{})
 	if !errors.Is(err, io.EOF) {
-		return errors.New("body must only contain a single JSON value")
+		return &i18n.Error{Key: "request.multiple_values"}
 	}
 
 	return nil
 }
+
+// idParam returns the positive integer in the named route parameter.
+func idParam(r *http.Request, name string) (int64, bool) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.ParseInt(params.ByName(name), 10, 64)
+	if err != nil || id < 1 {
+		return 0, false
+	}
+
+	return id, true
+}
+
+// locale returns the supported locale that best matches the request's
+// Accept-Language header.
+func (app *application) locale(r *http.Request) string {
+	return i18n.Match(r.Header.Get("Accept-Language"))
+}
+
+// clientIP returns the address of the client that sent r. X-Forwarded-For
+// is only believed when the request came from one of the trusted proxies:
+// it is walked from the right, skipping trusted proxies, and the first
+// address that is not one is the client.
+func (app *application) clientIP(r *http.Request) netip.Addr {
+	addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}
+	}
+
+	ip := addrPort.Addr().Unmap()
+	if !app.trustedProxy(ip) {
+		return ip
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			break
+		}
+
+		ip = hop.Unmap()
+		if !app.trustedProxy(ip) {
+			break
+		}
+	}
+
+	return ip
+}
+
+// overTLS reports whether the client connected over TLS, either to this
+// server or to a trusted proxy that says so in X-Forwarded-Proto.
+func (app *application) overTLS(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+
+	addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil || !app.trustedProxy(addrPort.Addr().Unmap()) {
+		return false
+	}
+
+	// The last entry was added by the proxy that connected to us.
+	protos := strings.Split(strings.Join(r.Header.Values("X-Forwarded-Proto"), ","), ",")
+	return strings.EqualFold(strings.TrimSpace(protos[len(protos)-1]), "https")
+}
+
+func (app *application) trustedProxy(ip netip.Addr) bool {
+	for _, prefix := range app.config.trustedProxies {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// trustedOrigin reports whether origin is one of the CORS trusted origins,
+// or a subdomain of a wildcard one. https://*.example.com matches
+// https://app.example.com and https://a.b.example.com, but not
+// https://example.com itself.
+func (app *application) trustedOrigin(origin string) bool {
+	origin = strings.ToLower(origin)
+
+	for _, trusted := range app.config.cors.trustedOrigins {
+		prefix, suffix, wildcard := strings.Cut(trusted, "*")
+		if !wildcard {
+			if origin == trusted {
+				return true
+			}
+			continue
+		}
+
+		subdomain, ok := strings.CutPrefix(origin, prefix)
+		if !ok {
+			continue
+		}
+
+		subdomain, ok = strings.CutSuffix(subdomain, suffix)
+		if ok && validSubdomain(subdomain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validSubdomain reports whether s is one or more dot-separated DNS labels.
+func validSubdomain(s string) bool {
+	for _, label := range strings.Split(s, ".") {
+		if label == "" {
+			return false
+		}
+
+		for _, c := range label {
+			if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// ceilSeconds rounds d up to whole seconds, for headers that count in
+// seconds.
+func ceilSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
@@ -181,3 +181,94 @@ func TestReadJSON_MaxBytesLimit(t *testing.T) {
 	require.Error(t, err)
 	assert.Contains(t, err.Error(), "must not be larger than")
 }
+
+func TestClientIP(t *testing.T) {
+	t.Parallel()
+
+	proxies, err := parseTrustedProxies("10.0.0.0/8, 192.0.2.10, 2001:db8::/32")
+	require.NoError(t, err)
+
+	app := &application{}
+	app.config.trustedProxies = proxies
+
+	tests := []struct {
+		name          string
+		remoteAddr    string
+		forwardedFor  []string
+		expectAddress string
+	}{
+		{name: "direct client", remoteAddr: "198.51.100.7:4000", expectAddress: "198.51.100.7"},
+		{name: "untrusted peer cannot forward", remoteAddr: "198.51.100.7:4000", forwardedFor: []string{"203.0.113.1"}, expectAddress: "198.51.100.7"},
+		{name: "trusted proxy", remoteAddr: "10.1.2.3:4000", forwardedFor: []string{"203.0.113.1"}, expectAddress: "203.0.113.1"},
+		{name: "trusted single address", remoteAddr: "192.0.2.10:4000", forwardedFor: []string{"203.0.113.1"}, expectAddress: "203.0.113.1"},
+		{name: "chain of trusted proxies", remoteAddr: "10.1.2.3:4000", forwardedFor: []string{"203.0.113.1, 10.9.9.9"}, expectAddress: "203.0.113.1"},
+		{name: "spoofed leftmost entry is ignored", remoteAddr: "10.1.2.3:4000", forwardedFor: []string{"1.1.1.1, 203.0.113.1"}, expectAddress: "203.0.113.1"},
+		{name: "several headers", remoteAddr: "10.1.2.3:4000", forwardedFor: []string{"1.1.1.1", "203.0.113.1"}, expectAddress: "203.0.113.1"},
+		{name: "malformed entry stops the walk", remoteAddr: "10.1.2.3:4000", forwardedFor: []string{"203.0.113.1, bogus"}, expectAddress: "10.1.2.3"},
+		{name: "trusted proxy without header", remoteAddr: "10.1.2.3:4000", expectAddress: "10.1.2.3"},
+		{name: "ipv6", remoteAddr: "[2001:db8::1]:4000", forwardedFor: []string{"2001:db9::5"}, expectAddress: "2001:db9::5"},
+		{name: "ipv4-mapped ipv6 peer", remoteAddr: "[::ffff:10.1.2.3]:4000", forwardedFor: []string{"203.0.113.1"}, expectAddress: "203.0.113.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwardedFor {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+
+			assert.Equal(t, tt.expectAddress, app.clientIP(r).String())
+		})
+	}
+
+	_, err = parseTrustedProxies("10.0.0.0/33")
+	assert.Error(t, err)
+	_, err = parseTrustedProxies("proxy.internal")
+	assert.Error(t, err)
+}
+
+func TestTrustedOrigin(t *testing.T) {
+	t.Parallel()
+
+	origins, err := parseCORSOrigins("https://app.example.com, HTTPS://*.Example.org, http://localhost:5173")
+	require.NoError(t, err)
+
+	app := &application{}
+	app.config.cors.trustedOrigins = origins
+
+	tests := []struct {
+		origin        string
+		expectTrusted bool
+	}{
+		{origin: "https://app.example.com", expectTrusted: true},
+		{origin: "https://APP.example.com", expectTrusted: true},
+		{origin: "http://app.example.com"},
+		{origin: "https://app.example.com:8443"},
+		{origin: "https://evil-app.example.com"},
+		{origin: "https://www.example.org", expectTrusted: true},
+		{origin: "https://a.b.example.org", expectTrusted: true},
+		{origin: "https://example.org"},
+		{origin: "https://evilexample.org"},
+		{origin: "https://.example.org"},
+		{origin: "https://evil.com/.example.org"},
+		{origin: "http://localhost:5173", expectTrusted: true},
+		{origin: "http://localhost:3000"},
+		{origin: "null"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.origin, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tt.expectTrusted, app.trustedOrigin(tt.origin))
+		})
+	}
+
+	for _, invalid := range []string{"app.example.com", "https://app.example.com/", "ftp://example.com", "https://*", "https://app.*.example.com", "*"} {
+		_, err = parseCORSOrigins(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
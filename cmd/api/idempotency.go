@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/ab0utbla-k/rvt-hello-app/internal/data"
+	"github.com/ab0utbla-k/rvt-hello-app/internal/i18n"
+)
+
+const (
+	// idempotencyLockTimeout is how long a request holds its key before a
+	// retry may take it over, in case the instance handling it died.
+	idempotencyLockTimeout = time.Minute
+
+	idempotencyPurgeInterval = 10 * time.Minute
+)
+
+// replayedHeaders are the response headers stored with an idempotency key.
+// The rest, such as RateLimit-*, describe the retry rather than the
+// original response.
+var replayedHeaders = []string{"Content-Type", "Content-Language", "Location", "Vary"}
+
+// idempotent lets clients retry writes safely by sending an Idempotency-Key
+// header. The first request with a key is handled and its response stored;
+// retries with the same method, path and body get that response back with
+// Idempotent-Replayed: true instead of being handled again. Server errors
+// are not stored, so those can be retried. It must run behind requireScope,
+// as keys belong to the caller's credential.
+func (app *application) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, ok := r.Header["Idempotency-Key"]
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(key) != 1 || !validIdempotencyKey(key[0]) {
+			app.badRequestResponse(w, r, &i18n.Error{Key: "request.idempotency_key"})
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1_048_576))
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			switch {
+			case errors.As(err, &maxBytesError):
+				app.badRequestResponse(w, r, &i18n.Error{Key: "request.too_large", Args: map[string]any{"limit": maxBytesError.Limit}})
+			default:
+				app.badRequestResponse(w, r, err)
+			}
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		record := &data.IdempotencyKey{
+			Caller:      app.contextGetCaller(r).credential(),
+			Key:         key[0],
+			Fingerprint: fingerprint(r, body),
+			ExpiresAt:   time.Now().Add(idempotencyLockTimeout),
+		}
+
+		err = app.models.Idempotency.Insert(record)
+		switch {
+		case errors.Is(err, data.ErrDuplicateIdempotencyKey):
+			app.replay(w, r, record)
+			return
+		case err != nil:
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		// Release the key unless the response gets stored, including when
+		// next panics. A key that a retry took over is not ours to release.
+		release := true
+		defer func() {
+			if release {
+				err := app.models.Idempotency.Delete(record)
+				if err != nil && !errors.Is(err, data.ErrIdempotencyKeyLost) {
+					app.logError(r, err)
+				}
+			}
+		}()
+
+		rw := newIdempotencyResponseWriter(w)
+		next.ServeHTTP(rw, r)
+
+		if rw.statusCode >= http.StatusInternalServerError {
+			return
+		}
+
+		record.Status = rw.statusCode
+		record.Header = make(map[string][]string)
+		for _, name := range replayedHeaders {
+			if values := rw.Header().Values(name); len(values) > 0 {
+				record.Header[name] = values
+			}
+		}
+		record.Body = rw.body.Bytes()
+		record.ExpiresAt = time.Now().Add(app.config.idempotency.ttl)
+
+		err = app.models.Idempotency.Complete(record)
+		switch {
+		case errors.Is(err, data.ErrIdempotencyKeyLost):
+			// The request outlived idempotencyLockTimeout and a retry holds
+			// the key now, so its response is the one to store.
+			app.logger.Warn("idempotency key taken over by a retry before the response was stored", "method", r.Method, "uri", r.URL.RequestURI())
+			release = false
+		case err != nil:
+			app.logError(r, err)
+		default:
+			release = false
+		}
+	}
+}
+
+// replay answers a request whose key was used before with the stored
+// response.
+func (app *application) replay(w http.ResponseWriter, r *http.Request, record *data.IdempotencyKey) {
+	stored, err := app.models.Idempotency.Get(record.Caller, record.Key)
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		// The first request failed or its key expired in the meantime, so
+		// a retry will be handled.
+		app.idempotencyKeyInUseResponse(w, r)
+		return
+	case err != nil:
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !bytes.Equal(stored.Fingerprint, record.Fingerprint) {
+		app.idempotencyKeyReusedResponse(w, r)
+		return
+	}
+
+	if stored.Status == 0 {
+		app.idempotencyKeyInUseResponse(w, r)
+		return
+	}
+
+	for name, values := range stored.Header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+
+	w.WriteHeader(stored.Status)
+	w.Write(stored.Body)
+}
+
+// validIdempotencyKey accepts up to 255 printable ASCII characters, enough
+// for the UUIDs clients usually send.
+func validIdempotencyKey(key string) bool {
+	if key == "" || len(key) > 255 {
+		return false
+	}
+
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x20 || key[i] > 0x7e {
+			return false
+		}
+	}
+
+	return true
+}
+
+// fingerprint identifies the request a key was used for by its method,
+// path and body.
+func fingerprint(r *http.Request, body []byte) []byte {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.Path+"\n")
+	h.Write(body)
+
+	return h.Sum(nil)
+}
+
+// idempotencyResponseWriter passes the response through while keeping a
+// copy of its status and body.
+type idempotencyResponseWriter struct {
+	wrapped       http.ResponseWriter
+	statusCode    int
+	headerWritten bool
+	body          bytes.Buffer
+}
+
+func newIdempotencyResponseWriter(w http.ResponseWriter) *idempotencyResponseWriter {
+	return &idempotencyResponseWriter{
+		wrapped:    w,
+		statusCode: http.StatusOK,
+	}
+}
+
+func (iw *idempotencyResponseWriter) Header() http.Header {
+	return iw.wrapped.Header()
+}
+
+func (iw *idempotencyResponseWriter) WriteHeader(statusCode int) {
+	iw.wrapped.WriteHeader(statusCode)
+
+	if !iw.headerWritten {
+		iw.statusCode = statusCode
+		iw.headerWritten = true
+	}
+}
+
+func (iw *idempotencyResponseWriter) Write(b []byte) (int, error) {
+	iw.headerWritten = true
+	iw.body.Write(b)
+	return iw.wrapped.Write(b)
+}
+
+func (iw *idempotencyResponseWriter) Unwrap() http.ResponseWriter {
+	return iw.wrapped
+}
+
+// purgeIdempotencyKeys deletes expired idempotency keys every interval
+// until ctx is done. Nothing is purged before the database is ready.
+func (app *application) purgeIdempotencyKeys(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !app.ready.Load() {
+				continue
+			}
+
+			n, err := app.models.Idempotency.DeleteExpired()
+			if err != nil {
+				app.logger.Error("purging idempotency keys", "error", err.Error())
+				continue
+			}
+
+			if n > 0 {
+				app.logger.Info("purged expired idempotency keys", "count", n)
+			}
+		}
+	}
+}
@@ -3,22 +3,34 @@ package main
 import (
 	"context"
 	"database/sql"
-	"embed"
 	"errors"
 	"expvar"
 	"flag"
+	"fmt"
+	"io"
 	"log/slog"
+	"net/netip"
+	"net/url"
 	"os"
+	"os/signal"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/ab0utbla-k/rvt-hello-app/internal/backoff"
+	"github.com/ab0utbla-k/rvt-hello-app/internal/cache"
 	"github.com/ab0utbla-k/rvt-hello-app/internal/data"
-	"github.com/golang-migrate/migrate/v4"
-	"github.com/golang-migrate/migrate/v4/database/postgres"
-	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/ab0utbla-k/rvt-hello-app/internal/mailer"
+	"github.com/ab0utbla-k/rvt-hello-app/internal/outbox"
+	"github.com/ab0utbla-k/rvt-hello-app/internal/ratelimit"
+	"github.com/ab0utbla-k/rvt-hello-app/internal/templates"
+	"github.com/ab0utbla-k/rvt-hello-app/internal/tokens"
+	"github.com/ab0utbla-k/rvt-hello-app/migrations"
 	_ "github.com/lib/pq"
 )
 
@@ -26,25 +38,162 @@ const (
 	version = "1.0.0"
 )
 
-//go:embed migrations/*.sql
-var migrationFiles embed.FS
-
 type config struct {
 	port int
 	env  string
-	db   struct {
+
+	// trustedProxies are the reverse proxies whose X-Forwarded-For header
+	// is believed when working out a client's IP.
+	trustedProxies []netip.Prefix
+
+	security struct {
+		// hstsMaxAge is how long browsers that reached the API over TLS
+		// must keep using HTTPS. Zero leaves out Strict-Transport-Security.
+		hstsMaxAge            time.Duration
+		hstsIncludeSubdomains bool
+	}
+
+	cors struct {
+		// trustedOrigins may call the API from a browser. An origin whose
+		// host starts with "*." trusts every subdomain of the rest.
+		trustedOrigins []string
+		// allowCredentials lets browsers send cookies and HTTP
+		// authentication with cross-origin requests.
+		allowCredentials bool
+	}
+
+	limiter struct {
+		enabled bool
+		// backend is "memory", "postgres" or "redis". Only the last two
+		// share limits between instances.
+		backend  string
+		redisURL string
+		read     ratelimit.Rate
+		write    ratelimit.Rate
+		admin    ratelimit.Rate
+	}
+
+	// errorFormat is the error body sent to clients whose Accept header
+	// does not pick one: "legacy" or "problem" (RFC 9457).
+	errorFormat string
+
+	auth struct {
+		// publicReads lets clients without an API key read birthday
+		// messages; writes always need a key with the write scope.
+		publicReads bool
+
+		// jwt configures end-user bearer tokens. They are rejected when
+		// jwks is empty.
+		jwt struct {
+			jwks     string
+			issuer   string
+			audience string
+		}
+	}
+
+	messages struct {
+		templatesDir    string
+		defaultTemplate string
+	}
+
+	db struct {
 		dsn          string
+		driver       string
 		maxOpenConns int
 		maxIdleConns int
 		maxIdleTime  time.Duration
+		connect      struct {
+			attempts   int
+			backoff    time.Duration
+			maxBackoff time.Duration
+		}
+		serveBeforeReady bool
+		autoMigrate      bool
+		migrateTimeout   time.Duration
+		replica          struct {
+			dsn           string
+			maxLag        time.Duration
+			checkInterval time.Duration
+		}
+	}
+	cache struct {
+		backend  string
+		ttl      time.Duration
+		size     int
+		redisURL string
+	}
+
+	idempotency struct {
+		// ttl is how long a response is replayed to retries with the same
+		// Idempotency-Key.
+		ttl time.Duration
+	}
+
+	notifications struct {
+		// smtpURL is the mail server birthday emails are sent through.
+		// None are sent when it is empty.
+		smtpURL string
+		from    string
+		// sendHour is the hour of the day, in each user's time zone, from
+		// which their emails are sent.
+		sendHour int
+		// reminderDays is how many days ahead of a birthday a reminder
+		// is sent, 0 for none.
+		reminderDays int
+		interval     time.Duration
+	}
+
+	webhooks struct {
+		// maxAttempts is how many times a delivery is tried before it is
+		// marked failed. Retries back off exponentially from backoff up to
+		// maxBackoff.
+		maxAttempts int
+		backoff     time.Duration
+		maxBackoff  time.Duration
+	}
+
+	outbox struct {
+		// sinks are where the events recorded in the outbox are
+		// published: log, webhook and file.
+		sinks []string
+		// file is the JSON lines file the file sink appends to.
+		file     string
+		interval time.Duration
 	}
 }
 
 type application struct {
 	config config
 	logger *slog.Logger
-	models data.Models
 	wg     sync.WaitGroup
+
+	// The fields down to sinks need the database, so they are only set
+	// once it is connected, before app.ready.
+	models data.Models
+
+	// replica is the read replica, nil when DB_REPLICA_DSN is not set.
+	replica *data.Replica
+
+	// limiter holds the per-client rate limits, nil when
+	// RATE_LIMIT_ENABLED is false.
+	limiter ratelimit.Limiter
+
+	// sinks publish the events recorded in the outbox.
+	sinks []outbox.Sink
+
+	// tokens verifies end users' JWTs, nil when JWT_JWKS is not set.
+	tokens *tokens.Verifier
+
+	// templates holds the operator's message templates, nil when
+	// MESSAGE_TEMPLATES_DIR is not set.
+	templates *templates.Set
+
+	// mailer sends birthday emails, nil when SMTP_URL is not set.
+	mailer *mailer.Mailer
+
+	// ready is set once the database is connected and migrated. Handlers
+	// behind requireReady must not touch models before then.
+	ready atomic.Bool
 }
 
 func main() {
@@ -56,39 +205,235 @@ func main() {
 	cfg.db.maxOpenConns = getEnv("DB_MAX_OPEN_CONNS", 25, parseInt)
 	cfg.db.maxIdleConns = getEnv("DB_MAX_IDLE_CONNS", 25, parseInt)
 	cfg.db.maxIdleTime = getEnv("DB_MAX_IDLE_TIME", 15*time.Minute, parseDuration)
+	cfg.db.connect.attempts = getEnv("DB_CONNECT_ATTEMPTS", 10, parseInt)
+	cfg.db.connect.backoff = getEnv("DB_CONNECT_BACKOFF", 500*time.Millisecond, parseDuration)
+	cfg.db.connect.maxBackoff = getEnv("DB_CONNECT_MAX_BACKOFF", 30*time.Second, parseDuration)
+	cfg.db.serveBeforeReady = getEnv("SERVE_BEFORE_DB_READY", false, parseBool)
+	cfg.db.autoMigrate = getEnv("DB_AUTO_MIGRATE", true, parseBool)
+	cfg.db.migrateTimeout = getEnv("DB_MIGRATE_TIMEOUT", 5*time.Minute, parseDuration)
+	cfg.db.replica.dsn = getEnv("DB_REPLICA_DSN", "", parseString)
+	cfg.db.replica.maxLag = getEnv("DB_REPLICA_MAX_LAG", 10*time.Second, parseDuration)
+	cfg.db.replica.checkInterval = getEnv("DB_REPLICA_CHECK_INTERVAL", 5*time.Second, parseDuration)
+	cfg.errorFormat = getEnv("ERROR_FORMAT", "legacy", parseString)
+	cfg.limiter.enabled = getEnv("RATE_LIMIT_ENABLED", true, parseBool)
+	cfg.limiter.backend = getEnv("RATE_LIMIT_BACKEND", "memory", parseString)
+	cfg.limiter.redisURL = getEnv("RATE_LIMIT_REDIS_URL", "redis://localhost:6379/0", parseString)
+	cfg.limiter.read.RPS = getEnv("RATE_LIMIT_READ_RPS", 10.0, parseFloat)
+	cfg.limiter.read.Burst = getEnv("RATE_LIMIT_READ_BURST", 20, parseInt)
+	cfg.limiter.write.RPS = getEnv("RATE_LIMIT_WRITE_RPS", 1.0, parseFloat)
+	cfg.limiter.write.Burst = getEnv("RATE_LIMIT_WRITE_BURST", 5, parseInt)
+	cfg.limiter.admin.RPS = getEnv("RATE_LIMIT_ADMIN_RPS", 1.0, parseFloat)
+	cfg.limiter.admin.Burst = getEnv("RATE_LIMIT_ADMIN_BURST", 5, parseInt)
+	trustedProxies := getEnv("TRUSTED_PROXIES", "", parseString)
+	trustedOrigins := getEnv("CORS_TRUSTED_ORIGINS", "", parseString)
+	cfg.cors.allowCredentials = getEnv("CORS_ALLOW_CREDENTIALS", false, parseBool)
+	cfg.security.hstsMaxAge = getEnv("HSTS_MAX_AGE", 365*24*time.Hour, parseDuration)
+	cfg.security.hstsIncludeSubdomains = getEnv("HSTS_INCLUDE_SUBDOMAINS", false, parseBool)
+	cfg.auth.publicReads = getEnv("AUTH_PUBLIC_READS", true, parseBool)
+	cfg.auth.jwt.jwks = getEnv("JWT_JWKS", "", parseString)
+	cfg.auth.jwt.issuer = getEnv("JWT_ISSUER", "", parseString)
+	cfg.auth.jwt.audience = getEnv("JWT_AUDIENCE", "", parseString)
+	cfg.messages.templatesDir = getEnv("MESSAGE_TEMPLATES_DIR", "", parseString)
+	cfg.messages.defaultTemplate = getEnv("MESSAGE_TEMPLATE", "", parseString)
+	cfg.cache.backend = getEnv("CACHE_BACKEND", "none", parseString)
+	cfg.cache.ttl = getEnv("CACHE_TTL", 5*time.Minute, parseDuration)
+	cfg.cache.size = getEnv("CACHE_SIZE", 10_000, parseInt)
+	cfg.cache.redisURL = getEnv("CACHE_REDIS_URL", "redis://localhost:6379/0", parseString)
+	cfg.idempotency.ttl = getEnv("IDEMPOTENCY_KEY_TTL", 24*time.Hour, parseDuration)
+	cfg.notifications.smtpURL = getEnv("SMTP_URL", "", parseString)
+	cfg.notifications.from = getEnv("SMTP_FROM", "birthdays@localhost", parseString)
+	cfg.notifications.sendHour = getEnv("NOTIFY_SEND_HOUR", 9, parseInt)
+	cfg.notifications.reminderDays = getEnv("NOTIFY_REMINDER_DAYS", 0, parseInt)
+	cfg.notifications.interval = getEnv("NOTIFY_INTERVAL", 15*time.Minute, parseDuration)
+	cfg.webhooks.maxAttempts = getEnv("WEBHOOK_MAX_ATTEMPTS", 10, parseInt)
+	cfg.webhooks.backoff = getEnv("WEBHOOK_BACKOFF", 30*time.Second, parseDuration)
+	cfg.webhooks.maxBackoff = getEnv("WEBHOOK_MAX_BACKOFF", 6*time.Hour, parseDuration)
+	outboxSinks := getEnv("OUTBOX_SINKS", "webhook", parseString)
+	cfg.outbox.file = getEnv("OUTBOX_FILE", "", parseString)
+	cfg.outbox.interval = getEnv("OUTBOX_POLL_INTERVAL", time.Second, parseDuration)
 
 	flag.IntVar(&cfg.port, "port", cfg.port, "API server port")
 	flag.StringVar(&cfg.env, "env", cfg.env, "Environment (development|staging|production)")
-	flag.StringVar(&cfg.db.dsn, "db-dsn", cfg.db.dsn, "PostgreSQL DSN")
-	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", cfg.db.maxOpenConns, "PostgreSQL max open connections")
-	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", cfg.db.maxIdleConns, "PostgreSQL max idle connections")
-	flag.DurationVar(&cfg.db.maxIdleTime, "db-max-idle-time", cfg.db.maxIdleTime, "PostgreSQL max connection idle time")
+	flag.StringVar(&cfg.db.dsn, "db-dsn", cfg.db.dsn, "Database DSN (PostgreSQL, or sqlite://path for SQLite)")
+	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", cfg.db.maxOpenConns, "Database max open connections")
+	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", cfg.db.maxIdleConns, "Database max idle connections")
+	flag.DurationVar(&cfg.db.maxIdleTime, "db-max-idle-time", cfg.db.maxIdleTime, "Database max connection idle time")
+	flag.IntVar(&cfg.db.connect.attempts, "db-connect-attempts", cfg.db.connect.attempts, "Initial database connection attempts (0 retries forever)")
+	flag.DurationVar(&cfg.db.connect.backoff, "db-connect-backoff", cfg.db.connect.backoff, "Initial delay between database connection attempts")
+	flag.DurationVar(&cfg.db.connect.maxBackoff, "db-connect-max-backoff", cfg.db.connect.maxBackoff, "Max delay between database connection attempts")
+	flag.BoolVar(&cfg.db.serveBeforeReady, "serve-before-db-ready", cfg.db.serveBeforeReady, "Serve /livez and /readyz while the database is still unreachable")
+	flag.BoolVar(&cfg.db.autoMigrate, "db-auto-migrate", cfg.db.autoMigrate, "Apply pending migrations on server start")
+	flag.DurationVar(&cfg.db.migrateTimeout, "db-migrate-timeout", cfg.db.migrateTimeout, "Max time to wait for another instance to apply migrations")
+	flag.StringVar(&cfg.db.replica.dsn, "db-replica-dsn", cfg.db.replica.dsn, "PostgreSQL read replica DSN (optional)")
+	flag.DurationVar(&cfg.db.replica.maxLag, "db-replica-max-lag", cfg.db.replica.maxLag, "Max replication lag before reads fall back to the primary")
+	flag.DurationVar(&cfg.db.replica.checkInterval, "db-replica-check-interval", cfg.db.replica.checkInterval, "Read replica health check interval")
+	flag.StringVar(&cfg.errorFormat, "error-format", cfg.errorFormat, "Default error response format (legacy|problem)")
+	flag.BoolVar(&cfg.limiter.enabled, "rate-limit-enabled", cfg.limiter.enabled, "Enable per-client rate limiting")
+	flag.StringVar(&cfg.limiter.backend, "rate-limit-backend", cfg.limiter.backend, "Rate limiter backend (memory|postgres|redis)")
+	flag.StringVar(&cfg.limiter.redisURL, "rate-limit-redis-url", cfg.limiter.redisURL, "Rate limiter Redis URL (redis backend)")
+	flag.Float64Var(&cfg.limiter.read.RPS, "rate-limit-read-rps", cfg.limiter.read.RPS, "Rate limiter requests per second for reads (0 disables)")
+	flag.IntVar(&cfg.limiter.read.Burst, "rate-limit-read-burst", cfg.limiter.read.Burst, "Rate limiter burst for reads")
+	flag.Float64Var(&cfg.limiter.write.RPS, "rate-limit-write-rps", cfg.limiter.write.RPS, "Rate limiter requests per second for writes (0 disables)")
+	flag.IntVar(&cfg.limiter.write.Burst, "rate-limit-write-burst", cfg.limiter.write.Burst, "Rate limiter burst for writes")
+	flag.Float64Var(&cfg.limiter.admin.RPS, "rate-limit-admin-rps", cfg.limiter.admin.RPS, "Rate limiter requests per second for admin routes (0 disables)")
+	flag.IntVar(&cfg.limiter.admin.Burst, "rate-limit-admin-burst", cfg.limiter.admin.Burst, "Rate limiter burst for admin routes")
+	flag.StringVar(&trustedProxies, "trusted-proxies", trustedProxies, "Comma-separated IPs or CIDRs of proxies whose X-Forwarded-For is trusted")
+	flag.StringVar(&trustedOrigins, "cors-trusted-origins", trustedOrigins, "Comma-separated origins allowed to call the API from a browser, such as https://*.example.com")
+	flag.BoolVar(&cfg.cors.allowCredentials, "cors-allow-credentials", cfg.cors.allowCredentials, "Allow credentialed cross-origin requests")
+	flag.DurationVar(&cfg.security.hstsMaxAge, "hsts-max-age", cfg.security.hstsMaxAge, "Strict-Transport-Security max-age for clients on HTTPS (0 disables)")
+	flag.BoolVar(&cfg.security.hstsIncludeSubdomains, "hsts-include-subdomains", cfg.security.hstsIncludeSubdomains, "Extend Strict-Transport-Security to subdomains")
+	flag.BoolVar(&cfg.auth.publicReads, "auth-public-reads", cfg.auth.publicReads, "Serve GET /v1/hello without an API key")
+	flag.StringVar(&cfg.auth.jwt.jwks, "jwt-jwks", cfg.auth.jwt.jwks, "JWKS file path or http(s) URL with the keys that sign end-user JWTs (empty disables JWTs)")
+	flag.StringVar(&cfg.auth.jwt.issuer, "jwt-issuer", cfg.auth.jwt.issuer, "Required iss claim of JWTs (optional)")
+	flag.StringVar(&cfg.auth.jwt.audience, "jwt-audience", cfg.auth.jwt.audience, "Required aud claim of JWTs (optional)")
+	flag.StringVar(&cfg.messages.templatesDir, "message-templates-dir", cfg.messages.templatesDir, "Directory of birthday message templates (*.tmpl)")
+	flag.StringVar(&cfg.messages.defaultTemplate, "message-template", cfg.messages.defaultTemplate, "Message template used when a request does not pick one (empty for the built-in messages)")
+	flag.StringVar(&cfg.cache.backend, "cache-backend", cfg.cache.backend, "Users cache backend (none|memory|redis)")
+	flag.DurationVar(&cfg.cache.ttl, "cache-ttl", cfg.cache.ttl, "Users cache entry TTL")
+	flag.IntVar(&cfg.cache.size, "cache-size", cfg.cache.size, "Users cache max entries (memory backend)")
+	flag.StringVar(&cfg.cache.redisURL, "cache-redis-url", cfg.cache.redisURL, "Users cache Redis URL (redis backend)")
+	flag.DurationVar(&cfg.idempotency.ttl, "idempotency-key-ttl", cfg.idempotency.ttl, "How long responses are replayed for a repeated Idempotency-Key")
+	flag.StringVar(&cfg.notifications.smtpURL, "smtp-url", cfg.notifications.smtpURL, "SMTP server for birthday emails, smtp://[user:password@]host[:port] or smtps:// (empty disables emails)")
+	flag.StringVar(&cfg.notifications.from, "smtp-from", cfg.notifications.from, "Sender of birthday emails")
+	flag.IntVar(&cfg.notifications.sendHour, "notify-send-hour", cfg.notifications.sendHour, "Hour of the day in each user's time zone from which birthday emails are sent (0-23)")
+	flag.IntVar(&cfg.notifications.reminderDays, "notify-reminder-days", cfg.notifications.reminderDays, "Days before a birthday to send a reminder email (0 disables reminders)")
+	flag.DurationVar(&cfg.notifications.interval, "notify-interval", cfg.notifications.interval, "How often to check for birthday emails and events that are due")
+	flag.IntVar(&cfg.webhooks.maxAttempts, "webhook-max-attempts", cfg.webhooks.maxAttempts, "Times a webhook delivery is tried before it is marked failed")
+	flag.DurationVar(&cfg.webhooks.backoff, "webhook-backoff", cfg.webhooks.backoff, "Delay before the first webhook retry, doubled on every further retry")
+	flag.DurationVar(&cfg.webhooks.maxBackoff, "webhook-max-backoff", cfg.webhooks.maxBackoff, "Longest delay between webhook retries")
+	flag.StringVar(&outboxSinks, "outbox-sinks", outboxSinks, "Comma-separated sinks domain events are published to (log|webhook|file)")
+	flag.StringVar(&cfg.outbox.file, "outbox-file", cfg.outbox.file, "JSON lines file the file sink appends events to")
+	flag.DurationVar(&cfg.outbox.interval, "outbox-poll-interval", cfg.outbox.interval, "How often the outbox is checked for events to publish")
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [migrate up|down N|goto V|force V|version|status]\n       %s [flags] apikey create NAME SCOPE...|list|revoke ID\n", os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
 
 	flag.Parse()
 
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 
 	if strings.TrimSpace(cfg.db.dsn) == "" {
-		logger.Error("missing required DB_DSN (PostgreSQL DSN)")
+		logger.Error("missing required DB_DSN (database DSN)")
 		os.Exit(1)
 	}
 
-	db, err := openDB(cfg)
+	if cfg.errorFormat != errorFormatLegacy && cfg.errorFormat != errorFormatProblem {
+		logger.Error("invalid ERROR_FORMAT, must be legacy or problem", "value", cfg.errorFormat)
+		os.Exit(1)
+	}
+
+	proxies, err := parseTrustedProxies(trustedProxies)
 	if err != nil {
-		logger.Error(err.Error())
+		logger.Error("invalid TRUSTED_PROXIES", "error", err.Error())
 		os.Exit(1)
 	}
-	defer db.Close()
+	cfg.trustedProxies = proxies
 
-	logger.Info("database connection pool established")
+	origins, err := parseCORSOrigins(trustedOrigins)
+	if err != nil {
+		logger.Error("invalid CORS_TRUSTED_ORIGINS", "error", err.Error())
+		os.Exit(1)
+	}
+	cfg.cors.trustedOrigins = origins
 
-	err = runMigrations(db)
-	if err != nil && !errors.Is(err, migrate.ErrNoChange) {
-		logger.Error(err.Error())
+	if cfg.cache.size < 1 {
+		logger.Error("invalid CACHE_SIZE, must be at least 1", "value", cfg.cache.size)
 		os.Exit(1)
 	}
 
-	logger.Info("migrations applied successfully.")
+	if cfg.cache.ttl <= 0 {
+		logger.Error("invalid CACHE_TTL, must be positive", "value", cfg.cache.ttl)
+		os.Exit(1)
+	}
+
+	for _, limit := range []struct {
+		name string
+		rate ratelimit.Rate
+	}{
+		{"READ", cfg.limiter.read},
+		{"WRITE", cfg.limiter.write},
+		{"ADMIN", cfg.limiter.admin},
+	} {
+		if limit.rate.RPS < 0 {
+			logger.Error("invalid RATE_LIMIT_"+limit.name+"_RPS, must not be negative", "value", limit.rate.RPS)
+			os.Exit(1)
+		}
+
+		// A bucket that holds no tokens would refuse every request.
+		if limit.rate.RPS > 0 && limit.rate.Burst < 1 {
+			logger.Error("invalid RATE_LIMIT_"+limit.name+"_BURST, must be at least 1", "value", limit.rate.Burst)
+			os.Exit(1)
+		}
+	}
+
+	if cfg.db.replica.checkInterval <= 0 {
+		logger.Error("invalid DB_REPLICA_CHECK_INTERVAL, must be positive", "value", cfg.db.replica.checkInterval)
+		os.Exit(1)
+	}
+
+	if cfg.notifications.sendHour < 0 || cfg.notifications.sendHour > 23 {
+		logger.Error("invalid NOTIFY_SEND_HOUR, must be between 0 and 23", "value", cfg.notifications.sendHour)
+		os.Exit(1)
+	}
+
+	if cfg.notifications.reminderDays < 0 || cfg.notifications.reminderDays > 365 {
+		logger.Error("invalid NOTIFY_REMINDER_DAYS, must be between 0 and 365", "value", cfg.notifications.reminderDays)
+		os.Exit(1)
+	}
+
+	if cfg.notifications.interval <= 0 {
+		logger.Error("invalid NOTIFY_INTERVAL, must be positive", "value", cfg.notifications.interval)
+		os.Exit(1)
+	}
+
+	if cfg.webhooks.maxAttempts < 1 {
+		logger.Error("invalid WEBHOOK_MAX_ATTEMPTS, must be at least 1", "value", cfg.webhooks.maxAttempts)
+		os.Exit(1)
+	}
+
+	sinkNames, err := parseOutboxSinks(outboxSinks)
+	if err != nil {
+		logger.Error("invalid OUTBOX_SINKS", "error", err.Error())
+		os.Exit(1)
+	}
+	cfg.outbox.sinks = sinkNames
+
+	if slices.Contains(cfg.outbox.sinks, "file") && cfg.outbox.file == "" {
+		logger.Error("missing OUTBOX_FILE, required by the file sink")
+		os.Exit(1)
+	}
+
+	if cfg.outbox.interval <= 0 {
+		logger.Error("invalid OUTBOX_POLL_INTERVAL, must be positive", "value", cfg.outbox.interval)
+		os.Exit(1)
+	}
+
+	cfg.db.driver, cfg.db.dsn = parseDSN(cfg.db.dsn)
+
+	if flag.Arg(0) == "migrate" {
+		err := runMigrateCommand(cfg, logger, flag.Args()[1:])
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	if flag.Arg(0) == "apikey" {
+		err := runAPIKeyCommand(cfg, logger, flag.Args()[1:])
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	if flag.NArg() > 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
 
 	expvar.NewString("version").Set(version)
 
@@ -96,37 +441,243 @@ func main() {
 		return runtime.NumGoroutine()
 	}))
 
-	expvar.Publish("database", expvar.Func(func() any {
-		return db.Stats()
-	}))
-
 	expvar.Publish("timestamp", expvar.Func(func() any {
 		return time.Now().Unix()
 	}))
 
+	messageTemplates, err := loadTemplates(cfg)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	tokenVerifier, err := loadTokenVerifier(cfg)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	birthdayMailer, err := openMailer(cfg)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
+		config:    cfg,
+		logger:    logger,
+		templates: messageTemplates,
+		tokens:    tokenVerifier,
+		mailer:    birthdayMailer,
+	}
+
+	// With serve-before-db-ready the probes answer while the database is
+	// still coming up; data routes return 503 until app.ready is set.
+	serveErr := make(chan error, 1)
+	if cfg.db.serveBeforeReady {
+		go func() {
+			serveErr <- app.serve()
+		}()
 	}
 
-	err = app.serve()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	db, err := connectDB(ctx, cfg, logger)
+	stop()
+	if err != nil {
+		// The server caught the same signal and is shutting down on its own.
+		if cfg.db.serveBeforeReady && errors.Is(err, context.Canceled) {
+			err = <-serveErr
+			if err != nil {
+				logger.Error(err.Error())
+				os.Exit(1)
+			}
+			return
+		}
+
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	logger.Info("database connection pool established", "driver", cfg.db.driver)
+
+	var replica *data.Replica
+
+	if cfg.db.replica.dsn != "" {
+		replicaDriver, replicaDSN := parseDSN(cfg.db.replica.dsn)
+		if cfg.db.driver != "postgres" || replicaDriver != "postgres" {
+			logger.Error("DB_REPLICA_DSN is only supported with PostgreSQL")
+			os.Exit(1)
+		}
+
+		replicaDB, err := newDBPool(cfg, replicaDSN)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		defer replicaDB.Close()
+
+		replica = data.NewReplica(db, replicaDB, cfg.db.replica.maxLag)
+
+		// An unavailable replica must not stop the API from starting; reads
+		// go to the primary until the monitor sees the replica recover.
+		err = replica.Check()
+		if err != nil {
+			logger.Warn("read replica unavailable, reading from primary", "error", err.Error())
+		} else {
+			logger.Info("read replica connection pool established", "lag", replica.Lag())
+		}
+	}
+
+	if cfg.db.autoMigrate {
+		err = migrations.Run(db, cfg.db.driver, cfg.db.migrateTimeout, logger)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+
+		logger.Info("migrations applied successfully.")
+	} else {
+		logger.Info("automatic migrations disabled")
+
+		err = migrations.Verify(db, cfg.db.driver, logger)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+	}
+
+	expvar.Publish("database", expvar.Func(func() any {
+		if replica == nil {
+			return db.Stats()
+		}
+
+		return map[string]any{
+			"primary": db.Stats(),
+			"replica": replica.Stats(),
+		}
+	}))
+
+	expvar.Publish("database_retries", expvar.Func(func() any {
+		return data.DefaultRetrier.Stats()
+	}))
+
+	models := data.NewModels(db)
+	switch {
+	case cfg.db.driver == "sqlite":
+		models = data.NewSQLiteModels(db)
+	case replica != nil:
+		models = data.NewReplicatedModels(db, replica)
+	}
+
+	usersCache, err := openCache(cfg)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	if usersCache != nil {
+		if closer, ok := usersCache.(io.Closer); ok {
+			defer closer.Close()
+		}
+
+		cachedUsers := data.NewCachedUserModel(models.Users, usersCache)
+		models.Users = cachedUsers
+
+		expvar.Publish("users_cache", expvar.Func(func() any {
+			return cachedUsers.Stats()
+		}))
+
+		logger.Info("users cache enabled", "backend", cfg.cache.backend, "ttl", cfg.cache.ttl)
+	}
+
+	limiter, err := openLimiter(cfg, db)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	if limiter != nil {
+		if l, ok := limiter.(*ratelimit.Redis); ok {
+			defer l.Close()
+		}
+
+		logger.Info("rate limiting enabled", "backend", cfg.limiter.backend)
+	}
+
+	sinks, err := openSinks(cfg, models, logger)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	for _, sink := range sinks {
+		if closer, ok := sink.(io.Closer); ok {
+			defer closer.Close()
+		}
+	}
+
+	logger.Info("outbox sinks enabled", "sinks", strings.Join(cfg.outbox.sinks, ","))
+
+	app.models = models
+	app.replica = replica
+	app.limiter = limiter
+	app.sinks = sinks
+	app.ready.Store(true)
+
+	if cfg.db.serveBeforeReady {
+		logger.Info("application ready")
+		err = <-serveErr
+	} else {
+		err = app.serve()
+	}
 	if err != nil {
 		logger.Error(err.Error())
 		os.Exit(1)
 	}
 }
 
-func openDB(cfg config) (*sql.DB, error) {
-	db, err := sql.Open("postgres", cfg.db.dsn)
+// connectDB opens the primary database, retrying with exponential backoff
+// and jitter so that the API survives starting before PostgreSQL is ready.
+func connectDB(ctx context.Context, cfg config, logger *slog.Logger) (*sql.DB, error) {
+	b := backoff.Backoff{
+		Initial: cfg.db.connect.backoff,
+		Max:     cfg.db.connect.maxBackoff,
+	}
+
+	for attempt := 1; ; attempt++ {
+		db, err := openDB(cfg, cfg.db.dsn)
+		if err == nil {
+			return db, nil
+		}
+
+		if cfg.db.connect.attempts > 0 && attempt >= cfg.db.connect.attempts {
+			return nil, fmt.Errorf("database unreachable after %d attempt(s): %w", attempt, err)
+		}
+
+		delay := b.Delay(attempt)
+
+		logger.Warn("database unreachable, retrying",
+			"attempt", attempt,
+			"max_attempts", cfg.db.connect.attempts,
+			"retry_in", delay,
+			"error", err.Error(),
+		)
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+func openDB(cfg config, dsn string) (*sql.DB, error) {
+	db, err := newDBPool(cfg, dsn)
 	if err != nil {
 		return nil, err
 	}
 
-	db.SetMaxOpenConns(cfg.db.maxOpenConns)
-	db.SetMaxIdleConns(cfg.db.maxIdleConns)
-	db.SetConnMaxIdleTime(cfg.db.maxIdleTime)
-
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -139,6 +690,160 @@ func openDB(cfg config) (*sql.DB, error) {
 	return db, nil
 }
 
+// newDBPool creates a connection pool for dsn without connecting to the
+// database.
+func newDBPool(cfg config, dsn string) (*sql.DB, error) {
+	db, err := sql.Open(cfg.db.driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	db.SetMaxOpenConns(cfg.db.maxOpenConns)
+	db.SetMaxIdleConns(cfg.db.maxIdleConns)
+	db.SetConnMaxIdleTime(cfg.db.maxIdleTime)
+
+	// SQLite allows a single writer at a time, so a larger pool only
+	// produces SQLITE_BUSY errors under concurrent writes.
+	if cfg.db.driver == "sqlite" {
+		db.SetMaxOpenConns(1)
+	}
+
+	return db, nil
+}
+
+// loadTemplates loads and validates the message templates, and checks that
+// the default template, if any, is one of them.
+func loadTemplates(cfg config) (*templates.Set, error) {
+	if cfg.messages.templatesDir == "" {
+		if cfg.messages.defaultTemplate != "" {
+			return nil, fmt.Errorf("MESSAGE_TEMPLATE is %q but MESSAGE_TEMPLATES_DIR is not set", cfg.messages.defaultTemplate)
+		}
+		return nil, nil
+	}
+
+	set, err := templates.Load(cfg.messages.templatesDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.messages.defaultTemplate != "" && !set.Has(cfg.messages.defaultTemplate) {
+		return nil, fmt.Errorf("MESSAGE_TEMPLATE %q is not one of %v", cfg.messages.defaultTemplate, set.Names())
+	}
+
+	return set, nil
+}
+
+// openMailer returns the mailer for birthday emails, or nil when they are
+// disabled.
+func openMailer(cfg config) (*mailer.Mailer, error) {
+	if cfg.notifications.smtpURL == "" {
+		return nil, nil
+	}
+
+	m, err := mailer.New(cfg.notifications.smtpURL, cfg.notifications.from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP_URL or SMTP_FROM: %w", err)
+	}
+
+	return m, nil
+}
+
+// loadTokenVerifier loads the JWKS that end-user JWTs are checked against,
+// or returns nil when JWTs are disabled.
+func loadTokenVerifier(cfg config) (*tokens.Verifier, error) {
+	if cfg.auth.jwt.jwks == "" {
+		return nil, nil
+	}
+
+	keys, err := tokens.LoadKeySet(cfg.auth.jwt.jwks)
+	if err != nil {
+		return nil, err
+	}
+
+	return tokens.NewVerifier(keys, cfg.auth.jwt.issuer, cfg.auth.jwt.audience), nil
+}
+
+// openCache returns the users cache selected by cfg, or nil when caching is
+// disabled.
+func openCache(cfg config) (cache.Cache, error) {
+	switch cfg.cache.backend {
+	case "none", "":
+		return nil, nil
+	case "memory":
+		return cache.NewLRU(cfg.cache.size, cfg.cache.ttl)
+	case "redis":
+		return cache.NewRedis(cfg.cache.redisURL, cfg.cache.ttl)
+	default:
+		return nil, fmt.Errorf("unknown cache backend %q", cfg.cache.backend)
+	}
+}
+
+// openLimiter returns the rate limiter selected by cfg, or nil when rate
+// limiting is disabled. The postgres backend keeps its counters in db.
+func openLimiter(cfg config, db *sql.DB) (ratelimit.Limiter, error) {
+	if !cfg.limiter.enabled {
+		return nil, nil
+	}
+
+	rates := map[string]ratelimit.Rate{
+		rateLimitRead:  cfg.limiter.read,
+		rateLimitWrite: cfg.limiter.write,
+		rateLimitAdmin: cfg.limiter.admin,
+	}
+
+	switch cfg.limiter.backend {
+	case "memory":
+		return ratelimit.NewMemory(rates), nil
+	case "postgres":
+		// A SQLite database belongs to a single instance, so there is
+		// nothing to share limits with.
+		if cfg.db.driver != "postgres" {
+			return nil, errors.New("RATE_LIMIT_BACKEND=postgres is only supported with PostgreSQL")
+		}
+		return ratelimit.NewPostgres(db, rates), nil
+	case "redis":
+		return ratelimit.NewRedis(cfg.limiter.redisURL, rates)
+	default:
+		return nil, fmt.Errorf("unknown rate limit backend %q", cfg.limiter.backend)
+	}
+}
+
+// openSinks returns the outbox sinks named in cfg, in order.
+func openSinks(cfg config, models data.Models, logger *slog.Logger) ([]outbox.Sink, error) {
+	var sinks []outbox.Sink
+
+	for _, name := range cfg.outbox.sinks {
+		switch name {
+		case "log":
+			sinks = append(sinks, outbox.LogSink{Logger: logger})
+		case "webhook":
+			sinks = append(sinks, outbox.WebhookSink{Webhooks: models.Webhooks})
+		case "file":
+			sink, err := outbox.OpenFileSink(cfg.outbox.file)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, sink)
+		default:
+			return nil, fmt.Errorf("unknown outbox sink %q", name)
+		}
+	}
+
+	return sinks, nil
+}
+
+// parseDSN returns the database/sql driver name and the data source name for
+// dsn. The sqlite:// scheme selects SQLite with the remainder used as the
+// database path, opened with foreign keys enforced; anything else is treated
+// as a PostgreSQL DSN.
+func parseDSN(dsn string) (driver, source string) {
+	if path, ok := strings.CutPrefix(dsn, "sqlite://"); ok {
+		return "sqlite", data.SQLiteDSN(path)
+	}
+
+	return "postgres", dsn
+}
+
 func getEnv[T any](key string, defaultValue T, parser func(string) (T, error)) T {
 	value, ok := os.LookupEnv(key)
 	if !ok {
@@ -163,30 +868,99 @@ func parseInt(s string) (int, error) {
 	return int(val), err
 }
 
-func parseDuration(s string) (time.Duration, error) {
-	return time.ParseDuration(s)
+func parseFloat(s string) (float64, error) {
+	return strconv.ParseFloat(s, 64)
 }
 
-func runMigrations(db *sql.DB) error {
-	sourceDriver, err := iofs.New(migrationFiles, "migrations")
-	if err != nil {
-		return err
+// parseTrustedProxies parses a comma-separated list of IP addresses and
+// CIDR prefixes. A bare address is a prefix of one address.
+func parseTrustedProxies(s string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		if strings.Contains(field, "/") {
+			prefix, err := netip.ParsePrefix(field)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+
+		addr, err := netip.ParseAddr(field)
+		if err != nil {
+			return nil, err
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
 	}
 
-	driver, err := postgres.WithInstance(db, &postgres.Config{})
-	if err != nil {
-		return err
-	}
+	return prefixes, nil
+}
 
-	m, err := migrate.NewWithInstance("iofs", sourceDriver, "postgres", driver)
-	if err != nil {
-		return err
+// parseCORSOrigins parses a comma-separated list of origins, each a scheme
+// and host with an optional port. The host may start with "*." to match
+// its subdomains.
+func parseCORSOrigins(s string) ([]string, error) {
+	var origins []string
+
+	for _, field := range strings.Split(s, ",") {
+		field = strings.ToLower(strings.TrimSpace(field))
+		if field == "" {
+			continue
+		}
+
+		u, err := url.Parse(field)
+		if err != nil {
+			return nil, err
+		}
+
+		host := strings.TrimPrefix(u.Host, "*.")
+		if (u.Scheme != "http" && u.Scheme != "https") || host == "" || strings.Contains(host, "*") ||
+			u.User != nil || u.Path != "" || u.RawQuery != "" || u.Fragment != "" {
+			return nil, fmt.Errorf("%q is not an origin such as https://app.example.com or https://*.example.com", field)
+		}
+
+		origins = append(origins, field)
 	}
 
-	err = m.Up()
-	if err != nil {
-		return err
+	return origins, nil
+}
+
+// parseOutboxSinks parses a comma-separated list of sink names. Each may
+// appear once.
+func parseOutboxSinks(s string) ([]string, error) {
+	var sinks []string
+
+	for _, field := range strings.Split(s, ",") {
+		field = strings.ToLower(strings.TrimSpace(field))
+		if field == "" {
+			continue
+		}
+
+		if !slices.Contains([]string{"log", "webhook", "file"}, field) {
+			return nil, fmt.Errorf("unknown sink %q, must be log, webhook or file", field)
+		}
+
+		if slices.Contains(sinks, field) {
+			return nil, fmt.Errorf("sink %q is listed twice", field)
+		}
+
+		sinks = append(sinks, field)
 	}
 
-	return nil
+	return sinks, nil
+}
+
+func parseBool(s string) (bool, error) {
+	return strconv.ParseBool(s)
+}
+
+func parseDuration(s string) (time.Duration, error) {
+	return time.ParseDuration(s)
 }
@@ -1,13 +1,45 @@
 package main
 
 import (
+	"errors"
 	"expvar"
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/ab0utbla-k/rvt-hello-app/internal/data"
 )
 
+// secureHeaders hardens every response. It runs outside recoverPanic and
+// the router, so that their error responses get the headers too. Nothing
+// the API returns is meant to be cached, as birthdays are personal data.
+// Strict-Transport-Security is only sent to clients that connected over
+// TLS, as browsers ignore it otherwise.
+func (app *application) secureHeaders(next http.Handler) http.Handler {
+	var hsts string
+	if app.config.security.hstsMaxAge > 0 {
+		hsts = fmt.Sprintf("max-age=%d", int(app.config.security.hstsMaxAge.Seconds()))
+		if app.config.security.hstsIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; frame-ancestors 'none'")
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		w.Header().Set("Cache-Control", "no-store")
+
+		if hsts != "" && app.overTLS(r) {
+			w.Header().Set("Strict-Transport-Security", hsts)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (app *application) recoverPanic(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
@@ -21,6 +53,247 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 	})
 }
 
+func (app *application) requireReady(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !app.ready.Load() {
+			app.serviceUnavailableResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+// errInvalidCredentials is returned by callerFor for a bearer token that is
+// neither an active API key nor a valid JWT.
+var errInvalidCredentials = errors.New("invalid credentials")
+
+// authenticate adds the caller identified by the bearer token in the
+// Authorization header to the request context, or the anonymous caller when
+// there is none. A token that is present but not accepted gets a 401 even on
+// routes anonymous callers may use. Probes are not wrapped, so they keep
+// working with a stale token.
+//
+// Each rejected token is charged to the client IP's limit for group, so
+// guessing credentials is rate limited like anonymous traffic and a client
+// past its limit gets a 429 instead of another lookup's worth of 401.
+func (app *application) authenticate(group string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Authorization")
+
+		authorizationHeader := r.Header.Get("Authorization")
+		if authorizationHeader == "" {
+			next.ServeHTTP(w, app.contextSetCaller(r, anonymousCaller))
+			return
+		}
+
+		token, ok := strings.CutPrefix(authorizationHeader, "Bearer ")
+		if !ok {
+			if app.allow(w, r, group, app.ipRateLimitKey(r)) {
+				app.invalidCredentialsResponse(w, r)
+			}
+			return
+		}
+
+		c, err := app.callerFor(token)
+		if err != nil {
+			switch {
+			case errors.Is(err, errInvalidCredentials):
+				if app.allow(w, r, group, app.ipRateLimitKey(r)) {
+					app.invalidCredentialsResponse(w, r)
+				}
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		next.ServeHTTP(w, app.contextSetCaller(r, c))
+	}
+}
+
+// callerFor resolves token to a caller. Tokens with the API key prefix are
+// looked up in app.models.APIKeys; anything else is verified as a JWT when
+// JWT_JWKS is set. A JWT grants read and write, limited to the subject's own
+// record by the handlers, and admin with the admin claim.
+func (app *application) callerFor(token string) (*caller, error) {
+	if strings.HasPrefix(token, data.APIKeyPrefix) {
+		key, err := app.models.APIKeys.GetByPlaintext(token)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				return nil, errInvalidCredentials
+			default:
+				return nil, err
+			}
+		}
+
+		return &caller{apiKeyID: key.ID, scopes: key.Scopes}, nil
+	}
+
+	if app.tokens == nil {
+		return nil, errInvalidCredentials
+	}
+
+	claims, err := app.tokens.Verify(token)
+	if err != nil {
+		app.logger.Debug("rejected bearer token", "error", err.Error())
+		return nil, errInvalidCredentials
+	}
+
+	scopes := []string{data.ScopeRead, data.ScopeWrite}
+	if claims.Admin {
+		scopes = append(scopes, data.ScopeAdmin)
+	}
+
+	return &caller{username: claims.Subject, scopes: scopes}, nil
+}
+
+// requireScope rejects anonymous callers and callers without scope. It must
+// run behind authenticate.
+func (app *application) requireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c := app.contextGetCaller(r)
+
+		if c.isAnonymous() {
+			app.authenticationRequiredResponse(w, r)
+			return
+		}
+
+		if !c.hasScope(scope) {
+			app.notPermittedResponse(w, r, scope)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+// rateLimit counts the request against the caller's limit for group, keyed
+// by credential for authenticated callers and by client IP otherwise. Every
+// response carries the limit's RateLimit-* headers. It must run behind
+// authenticate and requireReady, which makes app.limiter safe to read.
+func (app *application) rateLimit(group string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if app.limiter == nil || !app.limiter.Limited(group) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := app.contextGetCaller(r).credential()
+		if key == "" {
+			key = app.ipRateLimitKey(r)
+		}
+
+		if app.allow(w, r, group, key) {
+			next.ServeHTTP(w, r)
+		}
+	}
+}
+
+// ipRateLimitKey returns the rate limit key of the client IP, the bucket
+// shared by anonymous requests and rejected credentials.
+func (app *application) ipRateLimitKey(r *http.Request) string {
+	return "ip:" + app.clientIP(r).String()
+}
+
+// allow counts the request against key's limit for group and sets the
+// RateLimit-* headers. It reports whether the caller may go on; when not, it
+// has already sent the 429.
+func (app *application) allow(w http.ResponseWriter, r *http.Request, group, key string) bool {
+	if app.limiter == nil || !app.limiter.Limited(group) {
+		return true
+	}
+
+	result, err := app.limiter.Allow(group, key)
+	if err != nil {
+		// An unreachable shared store must not take the API down with
+		// it, so the request goes through unlimited.
+		app.logError(r, fmt.Errorf("rate limit: %w", err))
+		return true
+	}
+
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+
+	if !result.Allowed {
+		app.rateLimitExceededResponse(w, r, result.RetryAfter)
+		return false
+	}
+
+	return true
+}
+
+// The CORS response headers. Scripts can read the CORS-safelisted response
+// headers, such as Content-Type and Content-Language, without being told.
+const (
+	corsAllowedMethods = "GET, PUT, POST, DELETE"
+	corsAllowedHeaders = "Authorization, Content-Type, Accept-Language, Idempotency-Key"
+	corsExposedHeaders = "Location, Retry-After, RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, " +
+		"Idempotent-Replayed, Deprecation, Sunset, Link, WWW-Authenticate"
+)
+
+// enableCORS lets scripts on a trusted origin call the API. It answers
+// preflight requests itself, before authentication, as browsers send them
+// without credentials. Requests from other origins pass through untouched
+// and get no CORS headers, which makes the browser refuse the response.
+func (app *application) enableCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Origin")
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+
+		origin := r.Header.Get("Origin")
+		if origin == "" || !app.trustedOrigin(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if app.config.cors.allowCredentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", corsAllowedMethods)
+			w.Header().Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireReadScope is requireScope for reads, which stay open to anonymous
+// clients unless AUTH_PUBLIC_READS is turned off.
+func (app *application) requireReadScope(next http.HandlerFunc) http.HandlerFunc {
+	if app.config.auth.publicReads {
+		return next
+	}
+
+	return app.requireScope(data.ScopeRead, next)
+}
+
+// deprecated marks responses from a legacy alias with the Deprecation
+// (RFC 9745) and Sunset (RFC 8594) headers, and links to the same path
+// under successor.
+func (app *application) deprecated(successor string, next http.HandlerFunc) http.HandlerFunc {
+	deprecation := fmt.Sprintf("@%d", legacyDeprecation.Unix())
+	sunset := legacySunset.Format(http.TimeFormat)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", deprecation)
+		w.Header().Set("Sunset", sunset)
+		w.Header().Add("Link", fmt.Sprintf(`<%s%s>; rel="successor-version"`, successor, r.URL.EscapedPath()))
+
+		next.ServeHTTP(w, r)
+	}
+}
+
 type metricsResponseWriter struct {
 	wrapped       http.ResponseWriter
 	statusCode    int
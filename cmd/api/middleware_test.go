@@ -1,14 +1,24 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/ab0utbla-k/rvt-hello-app/internal/data"
+	"github.com/ab0utbla-k/rvt-hello-app/internal/ratelimit"
+	"github.com/ab0utbla-k/rvt-hello-app/internal/testutils"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestRecoverPanic(t *testing.T) {
@@ -78,3 +88,486 @@ func TestRecoverPanic(t *testing.T) {
 		})
 	}
 }
+
+func TestRequireReady(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		ready        bool
+		expectStatus int
+	}{
+		{
+			name:         "not ready",
+			ready:        false,
+			expectStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:         "ready",
+			ready:        true,
+			expectStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			app := &application{
+				logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+			}
+			app.ready.Store(tt.ready)
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/v1/hello/john", nil)
+
+			app.requireReady(next).ServeHTTP(w, r)
+
+			assert.Equal(t, tt.expectStatus, w.Code)
+		})
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	t.Parallel()
+
+	app := &application{
+		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		limiter: ratelimit.NewMemory(map[string]ratelimit.Rate{rateLimitWrite: {RPS: 0.5, Burst: 2}}),
+	}
+
+	next := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}
+	handler := app.rateLimit(rateLimitWrite, next)
+
+	send := func(remoteAddr string, c *caller) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPut, "/v1/hello/john", nil)
+		r.RemoteAddr = remoteAddr
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, app.contextSetCaller(r, c))
+		return w
+	}
+
+	w := send("192.0.2.1:1234", anonymousCaller)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "2", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "2", w.Header().Get("RateLimit-Reset"))
+
+	// The port is not part of the key.
+	w = send("192.0.2.1:5678", anonymousCaller)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+
+	w = send("192.0.2.1:1234", anonymousCaller)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+	assert.JSONEq(t, `{"error": "rate limit exceeded, please try again later"}`, w.Body.String())
+
+	// Authenticated callers have a bucket per credential, wherever they
+	// connect from.
+	key := &caller{apiKeyID: 7, scopes: []string{"write"}}
+	assert.Equal(t, http.StatusNoContent, send("192.0.2.1:1234", key).Code)
+	assert.Equal(t, http.StatusNoContent, send("198.51.100.1:1234", key).Code)
+	assert.Equal(t, http.StatusTooManyRequests, send("198.51.100.2:1234", key).Code)
+
+	user := &caller{username: "john", scopes: []string{"write"}}
+	assert.Equal(t, http.StatusNoContent, send("192.0.2.1:1234", user).Code)
+
+	// Groups without a rate are not limited and get no headers.
+	w = httptest.NewRecorder()
+	app.rateLimit(rateLimitRead, next).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/hello/john", nil))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Empty(t, w.Header().Get("RateLimit-Limit"))
+}
+
+func TestRateLimit_InvalidCredentials(t *testing.T) {
+	t.Parallel()
+
+	app := &application{
+		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		models:  data.NewSQLiteModels(testutils.SetupSQLiteTestDB(t)),
+		limiter: ratelimit.NewMemory(map[string]ratelimit.Rate{rateLimitWrite: {RPS: 0.5, Burst: 2}}),
+	}
+	app.ready.Store(true)
+
+	handler := app.apiHandler(rateLimitWrite, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler reached with invalid credentials")
+	})
+
+	send := func(remoteAddr, authorization string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPut, "/v1/hello/john", nil)
+		r.RemoteAddr = remoteAddr
+		r.Header.Set("Authorization", authorization)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := send("192.0.2.1:1234", "Bearer "+data.APIKeyPrefix+"guess1")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Remaining"))
+
+	w = send("192.0.2.1:1234", "Basic am9objpzZWNyZXQ=")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+
+	// Every new guess costs the same bucket, so the client is cut off.
+	for i := range 3 {
+		w = send("192.0.2.1:1234", "Bearer "+data.APIKeyPrefix+"guess"+strconv.Itoa(i+2))
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "2", w.Header().Get("Retry-After"))
+	}
+
+	// Other clients are not affected.
+	assert.Equal(t, http.StatusUnauthorized, send("198.51.100.1:1234", "Bearer not-a-token").Code)
+}
+
+type unavailableLimiter struct{}
+
+func (unavailableLimiter) Limited(string) bool { return true }
+
+func (unavailableLimiter) Allow(string, string) (ratelimit.Result, error) {
+	return ratelimit.Result{}, errors.New("connection refused")
+}
+
+func TestRateLimit_StoreUnavailable(t *testing.T) {
+	t.Parallel()
+
+	app := &application{
+		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		limiter: unavailableLimiter{},
+	}
+
+	handler := app.rateLimit(rateLimitWrite, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	r := httptest.NewRequest(http.MethodPut, "/v1/hello/john", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, app.contextSetCaller(r, anonymousCaller))
+
+	assert.Equal(t, http.StatusNoContent, w.Code, "requests go through when the store is down")
+	assert.Empty(t, w.Header().Get("RateLimit-Limit"))
+}
+
+func TestCleanupRateLimits(t *testing.T) {
+	t.Parallel()
+
+	app := &application{
+		limiter: ratelimit.NewMemory(map[string]ratelimit.Rate{rateLimitWrite: {RPS: 1, Burst: 1}}),
+	}
+
+	for _, ready := range []bool{false, true} {
+		app.ready.Store(ready)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			app.cleanupRateLimits(ctx, time.Millisecond)
+		}()
+
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("cleanupRateLimits did not return after ctx was cancelled (ready %t)", ready)
+		}
+	}
+}
+
+func TestIdempotent(t *testing.T) {
+	t.Parallel()
+
+	app := &application{
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		models: data.NewSQLiteModels(testutils.SetupSQLiteTestDB(t)),
+	}
+	app.config.idempotency.ttl = time.Hour
+
+	var calls int
+	handler := app.idempotent(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+
+		body, _ := io.ReadAll(r.Body)
+		if string(body) == "fail" {
+			app.serverErrorResponse(w, r, errors.New("failed"))
+			return
+		}
+
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(calls))
+		app.writeJSON(w, http.StatusCreated, envelope{"calls": calls, "body": string(body)}, http.Header{"Location": {"/v1/things/1"}})
+	})
+
+	send := func(c *caller, key, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPut, "/v1/hello/john", strings.NewReader(body))
+		if key != "" {
+			r.Header.Set("Idempotency-Key", key)
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, app.contextSetCaller(r, c))
+		return w
+	}
+
+	service := &caller{apiKeyID: 1, scopes: []string{"write"}}
+
+	first := send(service, "key-1", "a")
+	require.Equal(t, http.StatusCreated, first.Code)
+	assert.Empty(t, first.Header().Get("Idempotent-Replayed"))
+
+	retry := send(service, "key-1", "a")
+	assert.Equal(t, http.StatusCreated, retry.Code)
+	assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, first.Body.String(), retry.Body.String())
+	assert.Equal(t, "/v1/things/1", retry.Header().Get("Location"))
+	assert.Equal(t, "application/json", retry.Header().Get("Content-Type"))
+	assert.Empty(t, retry.Header().Get("RateLimit-Remaining"), "only headers describing the response are replayed")
+	assert.Equal(t, 1, calls)
+
+	w := send(service, "key-1", "b")
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.JSONEq(t, `{"error": "this Idempotency-Key was already used for a different request"}`, w.Body.String())
+
+	// Keys belong to a credential.
+	assert.Equal(t, http.StatusCreated, send(&caller{username: "john", scopes: []string{"write"}}, "key-1", "b").Code)
+	assert.Equal(t, 2, calls)
+
+	// Without a key every request is handled.
+	send(service, "", "a")
+	send(service, "", "a")
+	assert.Equal(t, 4, calls)
+
+	// Server errors release the key, so a retry is handled again.
+	assert.Equal(t, http.StatusInternalServerError, send(service, "key-2", "fail").Code)
+	assert.Equal(t, http.StatusInternalServerError, send(service, "key-2", "fail").Code)
+	assert.Equal(t, 6, calls)
+
+	// A request still holding its key makes retries wait.
+	require.NoError(t, app.models.Idempotency.Insert(&data.IdempotencyKey{
+		Caller:      service.credential(),
+		Key:         "key-3",
+		Fingerprint: fingerprint(httptest.NewRequest(http.MethodPut, "/v1/hello/john", nil), []byte("a")),
+		ExpiresAt:   time.Now().Add(time.Minute),
+	}))
+	w = send(service, "key-3", "a")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, 6, calls)
+
+	for _, key := range []string{strings.Repeat("k", 256), "tab\tkey"} {
+		assert.Equal(t, http.StatusBadRequest, send(service, key, "a").Code, key)
+	}
+}
+
+func TestIdempotent_ClaimTakenOver(t *testing.T) {
+	t.Parallel()
+
+	db := testutils.SetupSQLiteTestDB(t)
+	app := &application{
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		models: data.NewSQLiteModels(db),
+	}
+	app.config.idempotency.ttl = time.Hour
+
+	service := &caller{apiKeyID: 1, scopes: []string{"write"}}
+	retry := &data.IdempotencyKey{
+		Caller:      service.credential(),
+		Fingerprint: fingerprint(httptest.NewRequest(http.MethodPut, "/v1/hello/john", nil), []byte("retry")),
+		ExpiresAt:   time.Now().Add(time.Minute),
+	}
+
+	// The handler outlives its claim, and a retry takes the key over
+	// before it responds.
+	handler := app.idempotent(func(w http.ResponseWriter, r *http.Request) {
+		_, err := db.Exec("UPDATE idempotency_keys SET expires_at = 0")
+		require.NoError(t, err)
+		require.NoError(t, app.models.Idempotency.Insert(retry))
+
+		body, _ := io.ReadAll(r.Body)
+		if string(body) == "fail" {
+			app.serverErrorResponse(w, r, errors.New("failed"))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	for _, body := range []string{"ok", "fail"} {
+		retry.Key = "key-" + body
+
+		r := httptest.NewRequest(http.MethodPut, "/v1/hello/john", strings.NewReader(body))
+		r.Header.Set("Idempotency-Key", retry.Key)
+		handler.ServeHTTP(httptest.NewRecorder(), app.contextSetCaller(r, service))
+
+		stored, err := app.models.Idempotency.Get(retry.Caller, retry.Key)
+		require.NoError(t, err, body)
+		assert.Zero(t, stored.Status, "the slow request must not store its response over the retry's claim (%s)", body)
+		assert.Equal(t, retry.Fingerprint, stored.Fingerprint, body)
+	}
+}
+
+func TestEnableCORS(t *testing.T) {
+	t.Parallel()
+
+	app := &application{}
+	app.config.cors.trustedOrigins = []string{"https://app.example.com"}
+
+	withCredentials := &application{}
+	withCredentials.config.cors.trustedOrigins = app.config.cors.trustedOrigins
+	withCredentials.config.cors.allowCredentials = true
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	tests := []struct {
+		name             string
+		app              *application
+		method           string
+		origin           string
+		requestMethod    string
+		expectStatus     int
+		expectOrigin     string
+		expectPreflight  bool
+		expectCredential bool
+	}{
+		{name: "same origin", app: app, method: http.MethodGet, expectStatus: http.StatusTeapot},
+		{name: "trusted origin", app: app, method: http.MethodPut, origin: "https://app.example.com", expectStatus: http.StatusTeapot, expectOrigin: "https://app.example.com"},
+		{name: "untrusted origin", app: app, method: http.MethodPut, origin: "https://evil.example.com", expectStatus: http.StatusTeapot},
+		{name: "preflight", app: app, method: http.MethodOptions, origin: "https://app.example.com", requestMethod: http.MethodPut, expectStatus: http.StatusNoContent, expectOrigin: "https://app.example.com", expectPreflight: true},
+		{name: "untrusted preflight", app: app, method: http.MethodOptions, origin: "https://evil.example.com", requestMethod: http.MethodPut, expectStatus: http.StatusTeapot},
+		{name: "plain OPTIONS", app: app, method: http.MethodOptions, origin: "https://app.example.com", expectStatus: http.StatusTeapot, expectOrigin: "https://app.example.com"},
+		{name: "credentials", app: withCredentials, method: http.MethodOptions, origin: "https://app.example.com", requestMethod: http.MethodPut, expectStatus: http.StatusNoContent, expectOrigin: "https://app.example.com", expectPreflight: true, expectCredential: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := httptest.NewRequest(tt.method, "/v1/hello/john", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.requestMethod != "" {
+				r.Header.Set("Access-Control-Request-Method", tt.requestMethod)
+				r.Header.Set("Access-Control-Request-Headers", "authorization, content-type")
+			}
+
+			w := httptest.NewRecorder()
+			tt.app.enableCORS(next).ServeHTTP(w, r)
+
+			assert.Equal(t, tt.expectStatus, w.Code)
+			assert.Contains(t, w.Header().Values("Vary"), "Origin")
+			assert.Equal(t, tt.expectOrigin, w.Header().Get("Access-Control-Allow-Origin"))
+
+			if tt.expectPreflight {
+				assert.Contains(t, w.Header().Get("Access-Control-Allow-Methods"), http.MethodPut)
+				assert.Contains(t, w.Header().Get("Access-Control-Allow-Headers"), "Content-Type")
+				assert.Contains(t, w.Header().Get("Access-Control-Allow-Headers"), "Authorization")
+			} else {
+				assert.Empty(t, w.Header().Get("Access-Control-Allow-Methods"))
+			}
+
+			if tt.expectOrigin != "" && !tt.expectPreflight {
+				assert.Contains(t, w.Header().Get("Access-Control-Expose-Headers"), "RateLimit-Remaining")
+			}
+
+			if tt.expectCredential {
+				assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+			} else {
+				assert.Empty(t, w.Header().Get("Access-Control-Allow-Credentials"))
+			}
+		})
+	}
+}
+
+func TestSecureHeaders(t *testing.T) {
+	t.Parallel()
+
+	app := &application{
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	app.config.security.hstsMaxAge = 365 * 24 * time.Hour
+	app.config.trustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	routes := append(app.routeTable(), route{http.MethodGet, "/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("something went wrong")
+	}})
+	handler := app.standardMiddleware(app.newRouter(routes))
+
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		remoteAddr   string
+		tls          bool
+		proto        string
+		expectStatus int
+		expectHSTS   bool
+	}{
+		{name: "panic", method: http.MethodGet, path: "/panic", expectStatus: http.StatusInternalServerError},
+		{name: "not found", method: http.MethodGet, path: "/v1/nope", expectStatus: http.StatusNotFound},
+		{name: "method not allowed", method: http.MethodPatch, path: "/v1/hello/john", expectStatus: http.StatusMethodNotAllowed},
+		{name: "TLS", method: http.MethodGet, path: "/panic", tls: true, expectStatus: http.StatusInternalServerError, expectHSTS: true},
+		{name: "trusted proxy on HTTPS", method: http.MethodGet, path: "/v1/nope", remoteAddr: "10.0.0.1:1234", proto: "https", expectStatus: http.StatusNotFound, expectHSTS: true},
+		{name: "trusted proxy on HTTP", method: http.MethodGet, path: "/v1/nope", remoteAddr: "10.0.0.1:1234", proto: "https, http", expectStatus: http.StatusNotFound},
+		{name: "untrusted proxy", method: http.MethodGet, path: "/v1/nope", remoteAddr: "192.0.2.1:1234", proto: "https", expectStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			target := tt.path
+			if tt.tls {
+				target = "https://example.com" + tt.path
+			}
+
+			r := httptest.NewRequest(tt.method, target, nil)
+			if tt.remoteAddr != "" {
+				r.RemoteAddr = tt.remoteAddr
+			}
+			if tt.proto != "" {
+				r.Header.Set("X-Forwarded-Proto", tt.proto)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.expectStatus, w.Code)
+			assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+			assert.Equal(t, "default-src 'none'; frame-ancestors 'none'", w.Header().Get("Content-Security-Policy"))
+			assert.Equal(t, "no-referrer", w.Header().Get("Referrer-Policy"))
+			assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+
+			if tt.expectHSTS {
+				assert.Equal(t, "max-age=31536000", w.Header().Get("Strict-Transport-Security"))
+			} else {
+				assert.Empty(t, w.Header().Get("Strict-Transport-Security"))
+			}
+		})
+	}
+
+	t.Run("HSTS disabled", func(t *testing.T) {
+		t.Parallel()
+
+		app := &application{}
+		app.config.security.hstsIncludeSubdomains = true
+
+		r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+		w := httptest.NewRecorder()
+		app.secureHeaders(http.NotFoundHandler()).ServeHTTP(w, r)
+
+		assert.Empty(t, w.Header().Get("Strict-Transport-Security"))
+		assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+	})
+}
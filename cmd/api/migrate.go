@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/ab0utbla-k/rvt-hello-app/migrations"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/source"
+)
+
+const migrateUsage = "usage: migrate up | down N | goto V | force V | version | status"
+
+// runMigrateCommand connects to the configured database and runs the
+// migrate subcommand given by args.
+func runMigrateCommand(cfg config, logger *slog.Logger, args []string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	db, err := connectDB(ctx, cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return migrateCommand(db, cfg.db.driver, args, os.Stdout)
+}
+
+func migrateCommand(db *sql.DB, driverName string, args []string, w io.Writer) (err error) {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	m, err := migrations.New(db, driverName)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		sourceErr, databaseErr := m.Close()
+		err = errors.Join(err, sourceErr, databaseErr)
+	}()
+
+	switch args[0] {
+	case "up":
+		err = m.Up()
+
+	case "down":
+		var n int
+		n, err = migrateArg(args)
+		if err == nil && n < 1 {
+			err = errors.New("down: N must be at least 1")
+		}
+		if err == nil {
+			err = m.Steps(-n)
+		}
+
+	case "goto":
+		var v int
+		v, err = migrateArg(args)
+		if err == nil && v < 0 {
+			err = errors.New("goto: V must not be negative")
+		}
+		if err == nil {
+			err = m.Migrate(uint(v))
+		}
+
+	case "force":
+		var v int
+		v, err = migrateArg(args)
+		if err == nil {
+			err = m.Force(v)
+		}
+
+	case "version":
+		return printMigrationVersion(m, w)
+
+	case "status":
+		return printMigrationStatus(m, driverName, w)
+
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	switch {
+	case errors.Is(err, migrate.ErrNoChange):
+		fmt.Fprintln(w, "no change")
+	case err != nil:
+		return err
+	}
+
+	return printMigrationVersion(m, w)
+}
+
+func migrateArg(args []string) (int, error) {
+	if len(args) != 2 {
+		return 0, fmt.Errorf("%s: expected exactly one numeric argument", args[0])
+	}
+
+	n, err := strconv.Atoi(args[1])
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid argument %q", args[0], args[1])
+	}
+
+	return n, nil
+}
+
+func printMigrationVersion(m *migrate.Migrate, w io.Writer) error {
+	version, dirty, err := m.Version()
+	switch {
+	case errors.Is(err, migrate.ErrNilVersion):
+		fmt.Fprintln(w, "version: none")
+		return nil
+	case err != nil:
+		return err
+	}
+
+	if dirty {
+		fmt.Fprintf(w, "version: %d (dirty)\n", version)
+	} else {
+		fmt.Fprintf(w, "version: %d\n", version)
+	}
+
+	return nil
+}
+
+// printMigrationStatus lists every embedded migration as applied or pending
+// relative to the database's current version.
+func printMigrationStatus(m *migrate.Migrate, driverName string, w io.Writer) error {
+	current, dirty, err := m.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return err
+	}
+	applied := err == nil
+
+	sourceDriver, err := migrations.Source(driverName)
+	if err != nil {
+		return err
+	}
+	defer sourceDriver.Close()
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tSTATUS")
+
+	version, err := sourceDriver.First()
+	for err == nil {
+		var name string
+		name, err = migrationName(sourceDriver, version)
+		if err != nil {
+			return err
+		}
+
+		status := "pending"
+		switch {
+		case applied && version == current && dirty:
+			status = "dirty"
+		case applied && version <= current:
+			status = "applied"
+		}
+
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", version, name, status)
+
+		version, err = sourceDriver.Next(version)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return tw.Flush()
+}
+
+func migrationName(sourceDriver source.Driver, version uint) (string, error) {
+	r, name, err := sourceDriver.ReadUp(version)
+	if err != nil {
+		return "", err
+	}
+	r.Close()
+
+	return name, nil
+}
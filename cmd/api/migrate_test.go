@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/ab0utbla-k/rvt-hello-app/migrations"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateCommand(t *testing.T) {
+	t.Parallel()
+
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "migrate.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	run := func(args ...string) string {
+		t.Helper()
+
+		var out bytes.Buffer
+		require.NoError(t, migrateCommand(db, "sqlite", args, &out))
+		return out.String()
+	}
+
+	head, err := migrations.Head("sqlite")
+	require.NoError(t, err)
+
+	assert.Equal(t, "version: none\n", run("version"))
+	assert.Regexp(t, `1\s+create_users_table\s+pending`, run("status"))
+
+	assert.Equal(t, fmt.Sprintf("version: %d\n", head), run("up"))
+	assert.Regexp(t, `1\s+create_users_table\s+applied`, run("status"))
+	assert.Equal(t, fmt.Sprintf("no change\nversion: %d\n", head), run("up"))
+
+	assert.Equal(t, "version: 1\n", run("goto", "1"))
+	assert.Equal(t, "version: none\n", run("down", "1"))
+	assert.Equal(t, "version: 1\n", run("goto", "1"))
+	assert.Equal(t, "version: 1\n", run("force", "1"))
+}
+
+func TestMigrateCommand_InvalidArgs(t *testing.T) {
+	t.Parallel()
+
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "migrate.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	tests := []struct {
+		name        string
+		args        []string
+		expectError string
+	}{
+		{name: "no subcommand", args: nil, expectError: "usage"},
+		{name: "unknown subcommand", args: []string{"sideways"}, expectError: "usage"},
+		{name: "down without N", args: []string{"down"}, expectError: "exactly one numeric argument"},
+		{name: "down zero", args: []string{"down", "0"}, expectError: "at least 1"},
+		{name: "goto non-numeric", args: []string{"goto", "latest"}, expectError: "invalid argument"},
+		{name: "goto negative", args: []string{"goto", "-1"}, expectError: "must not be negative"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := migrateCommand(db, "sqlite", tt.args, &out)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expectError)
+		})
+	}
+}
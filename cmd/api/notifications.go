@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ab0utbla-k/rvt-hello-app/internal/data"
+	"github.com/ab0utbla-k/rvt-hello-app/internal/i18n"
+	"github.com/ab0utbla-k/rvt-hello-app/internal/mailer"
+)
+
+// watchBirthdays acts on the birthdays that are due every interval until
+// ctx is done. Runs before the database is ready are skipped.
+func (app *application) watchBirthdays(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !app.ready.Load() {
+				continue
+			}
+
+			app.notifyBirthdays(ctx, time.Now())
+		}
+	}
+}
+
+// notifyBirthdays records user.birthday in the outbox for the users whose
+// birthday it is at now. When a mailer is configured it also emails them a
+// greeting, and a reminder to those whose birthday is the configured
+// number of days away. Days start in each user's own time zone, and no email is sent
+// before the configured hour there. Every email is claimed in the
+// notifications table before it is sent, so it goes out at most once
+// however often this runs and on however many instances. It stops before
+// the next user once ctx is done, so shutdown does not wait for every email.
+func (app *application) notifyBirthdays(ctx context.Context, now time.Time) {
+	reminderDays := app.config.notifications.reminderDays
+
+	// A user's date can be a day either side of the UTC date, depending on
+	// their time zone.
+	var dates []time.Time
+	for _, days := range []int{-1, 0, 1} {
+		dates = append(dates, now.UTC().AddDate(0, 0, days))
+		if app.mailer != nil && reminderDays > 0 {
+			dates = append(dates, now.UTC().AddDate(0, 0, days+reminderDays))
+		}
+	}
+
+	users, err := app.models.Users.ListByBirthday(dates)
+	if err != nil {
+		app.logger.Error("listing users with birthdays", "error", err.Error())
+		return
+	}
+
+	for _, user := range users {
+		if ctx.Err() != nil {
+			return
+		}
+
+		birthday := user.BirthdayAt(now)
+
+		if birthday.IsToday {
+			err := app.recordBirthday(user, birthday)
+			if err != nil {
+				app.logger.Error("recording birthday event", "username", user.Username, "error", err.Error())
+			}
+		}
+
+		if app.mailer == nil || user.Email == "" || now.In(user.Location()).Hour() < app.config.notifications.sendHour {
+			continue
+		}
+
+		switch {
+		case birthday.IsToday:
+			app.notify(ctx, user, birthday, data.NotificationBirthday)
+		case reminderDays > 0 && birthday.DaysUntil == reminderDays:
+			app.notify(ctx, user, birthday, data.NotificationReminder)
+		}
+	}
+}
+
+// notify sends user the kind of email about birthday, unless it was
+// claimed already. A claim is only given up when the email was certainly
+// not sent, so that the next run tries again.
+func (app *application) notify(ctx context.Context, user *data.User, birthday data.Birthday, kind string) {
+	n := &data.Notification{Username: user.Username, Kind: kind, Birthday: birthday.Next}
+	logger := app.logger.With("username", user.Username, "kind", kind)
+
+	err := app.models.Notifications.Claim(n)
+	if err != nil {
+		if !errors.Is(err, data.ErrDuplicateNotification) {
+			logger.Error("claiming birthday email", "error", err.Error())
+		}
+		return
+	}
+
+	msg, err := app.birthdayEmail(user, birthday, kind)
+	if err == nil {
+		err = app.mailer.Send(ctx, msg)
+	}
+	if err != nil {
+		logger.Error("sending birthday email", "error", err.Error())
+
+		// Sending again could deliver the email twice, so the claim stays.
+		if errors.Is(err, mailer.ErrMaybeSent) {
+			return
+		}
+
+		err = app.models.Notifications.Release(n)
+		if err != nil {
+			logger.Error("releasing birthday email", "error", err.Error())
+		}
+		return
+	}
+
+	err = app.models.Notifications.MarkSent(n)
+	if err != nil {
+		logger.Error("marking birthday email sent", "error", err.Error())
+	}
+
+	logger.Info("sent birthday email")
+}
+
+// birthdayEmail writes the email in the user's locale. Its body is the
+// birthday message GET /v1/hello returns with the default template.
+func (app *application) birthdayEmail(user *data.User, birthday data.Birthday, kind string) (mailer.Message, error) {
+	locale := user.Locale
+	if locale == "" {
+		locale = i18n.Default
+	}
+
+	args := map[string]any{"username": user.Username}
+
+	subject := i18n.T(locale, "notification.birthday_subject", args)
+	if kind == data.NotificationReminder {
+		subject = i18n.N(locale, "notification.reminder_subject", birthday.DaysUntil, args)
+	}
+
+	body, _, err := app.birthdayMessage(user.Username, birthday, locale, app.config.messages.defaultTemplate)
+	if err != nil {
+		return mailer.Message{}, err
+	}
+
+	return mailer.Message{
+		To:       user.Email,
+		Subject:  subject,
+		Body:     body,
+		Language: locale,
+	}, nil
+}
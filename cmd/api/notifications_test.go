@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ab0utbla-k/rvt-hello-app/internal/data"
+	"github.com/ab0utbla-k/rvt-hello-app/internal/mailer"
+	"github.com/ab0utbla-k/rvt-hello-app/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type sentEmail struct {
+	to, subject, body string
+}
+
+func newNotificationTestApp(t *testing.T, server *testutils.SMTPServer) *application {
+	t.Helper()
+
+	m, err := mailer.New(server.URL, "birthdays@example.com")
+	require.NoError(t, err)
+
+	app := &application{
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		models: data.NewSQLiteModels(testutils.SetupSQLiteTestDB(t)),
+		mailer: m,
+	}
+	app.config.notifications.sendHour = 9
+	app.config.notifications.reminderDays = 3
+
+	return app
+}
+
+func sentEmails(t *testing.T, server *testutils.SMTPServer) []sentEmail {
+	t.Helper()
+
+	var emails []sentEmail
+	for _, m := range server.Messages() {
+		msg, err := mail.ReadMessage(bytes.NewReader(m.Data))
+		require.NoError(t, err)
+
+		subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+		require.NoError(t, err)
+
+		body, err := io.ReadAll(quotedprintable.NewReader(msg.Body))
+		require.NoError(t, err)
+
+		emails = append(emails, sentEmail{to: m.To[0], subject: subject, body: string(bytes.TrimSpace(body))})
+	}
+	return emails
+}
+
+func TestNotifyBirthdays(t *testing.T) {
+	t.Parallel()
+
+	server := testutils.NewSMTPServer(t)
+	app := newNotificationTestApp(t, server)
+
+	date := func(month time.Month, day int) time.Time {
+		return time.Date(1990, month, day, 0, 0, 0, 0, time.UTC)
+	}
+
+	for _, user := range []*data.User{
+		// 10:30 on May 10th in Berlin.
+		{Username: "john", DateOfBirth: date(time.May, 10), Email: "john@example.com", TimeZone: "Europe/Berlin"},
+		// 01:30 on May 10th in Los Angeles, too early.
+		{Username: "alice", DateOfBirth: date(time.May, 10), Email: "alice@example.com", TimeZone: "America/Los_Angeles"},
+		// 17:30 on May 10th in Tokyo, three days ahead.
+		{Username: "bob", DateOfBirth: date(time.May, 13), Email: "bob@example.com", TimeZone: "Asia/Tokyo", Locale: "de"},
+		// Two days ahead, which is not when reminders go out.
+		{Username: "carol", DateOfBirth: date(time.May, 12), Email: "carol@example.com", TimeZone: "Europe/Berlin"},
+		{Username: "dave", DateOfBirth: date(time.May, 10), TimeZone: "Europe/Berlin"},
+	} {
+		_, err := app.models.Users.Insert(user)
+		require.NoError(t, err)
+	}
+
+	now := time.Date(2026, time.May, 10, 8, 30, 0, 0, time.UTC)
+
+	app.notifyBirthdays(context.Background(), now)
+	app.notifyBirthdays(context.Background(), now.Add(time.Hour))
+
+	assert.ElementsMatch(t, []sentEmail{
+		{to: "john@example.com", subject: "Happy birthday, john!", body: "Hello, john! Happy birthday!"},
+		{to: "bob@example.com", subject: "Dein Geburtstag ist in 3 Tagen", body: "Hallo, bob! Dein Geburtstag ist in 3 Tagen"},
+	}, sentEmails(t, server))
+
+	// Los Angeles reaches 9:00 eight hours later.
+	app.notifyBirthdays(context.Background(), now.Add(8*time.Hour))
+
+	emails := sentEmails(t, server)
+	require.Len(t, emails, 3)
+	assert.Equal(t, "alice@example.com", emails[2].to)
+}
+
+func TestNotifyBirthdays_AtMostOnce(t *testing.T) {
+	t.Parallel()
+
+	server := testutils.NewSMTPServer(t)
+	app := newNotificationTestApp(t, server)
+
+	_, err := app.models.Users.Insert(&data.User{
+		Username:    "john",
+		DateOfBirth: time.Date(1990, time.May, 10, 0, 0, 0, 0, time.UTC),
+		Email:       "john@example.com",
+		TimeZone:    "UTC",
+	})
+	require.NoError(t, err)
+
+	now := time.Date(2026, time.May, 10, 12, 0, 0, 0, time.UTC)
+
+	// Several instances sharing the database run at the same time.
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			app.notifyBirthdays(context.Background(), now)
+		}()
+	}
+	wg.Wait()
+
+	assert.Len(t, server.Messages(), 1)
+
+	// Next year's birthday is a new notification.
+	app.notifyBirthdays(context.Background(), now.AddDate(1, 0, 0))
+	assert.Len(t, server.Messages(), 2)
+}
+
+func TestNotifyBirthdays_Cancelled(t *testing.T) {
+	t.Parallel()
+
+	server := testutils.NewSMTPServer(t)
+	app := newNotificationTestApp(t, server)
+
+	_, err := app.models.Users.Insert(&data.User{
+		Username:    "john",
+		DateOfBirth: time.Date(1990, time.May, 10, 0, 0, 0, 0, time.UTC),
+		Email:       "john@example.com",
+		TimeZone:    "UTC",
+	})
+	require.NoError(t, err)
+
+	now := time.Date(2026, time.May, 10, 12, 0, 0, 0, time.UTC)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	app.notifyBirthdays(ctx, now)
+	assert.Empty(t, server.Messages(), "no email is started once shutdown began")
+
+	// Nothing was claimed, so the next run sends it.
+	app.notifyBirthdays(context.Background(), now.Add(15*time.Minute))
+	assert.Len(t, server.Messages(), 1)
+}
+
+func TestNotifyBirthdays_SendFailure(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, time.May, 10, 12, 0, 0, 0, time.UTC)
+	user := &data.User{
+		Username:    "john",
+		DateOfBirth: time.Date(1990, time.May, 10, 0, 0, 0, 0, time.UTC),
+		Email:       "john@example.com",
+		TimeZone:    "UTC",
+	}
+
+	t.Run("rejected", func(t *testing.T) {
+		t.Parallel()
+
+		server := testutils.NewSMTPServer(t)
+		app := newNotificationTestApp(t, server)
+		_, err := app.models.Users.Insert(user)
+		require.NoError(t, err)
+
+		server.Reject.Store(true)
+		app.notifyBirthdays(context.Background(), now)
+		assert.Empty(t, server.Messages())
+
+		// The claim was given up, so the next run sends it.
+		server.Reject.Store(false)
+		app.notifyBirthdays(context.Background(), now.Add(15*time.Minute))
+		assert.Len(t, server.Messages(), 1)
+	})
+
+	t.Run("not confirmed", func(t *testing.T) {
+		t.Parallel()
+
+		server := testutils.NewSMTPServer(t)
+		app := newNotificationTestApp(t, server)
+		_, err := app.models.Users.Insert(user)
+		require.NoError(t, err)
+
+		server.Hangup.Store(true)
+		app.notifyBirthdays(context.Background(), now)
+		require.Len(t, server.Messages(), 1)
+
+		// The server may have delivered it, so it is not sent again.
+		server.Hangup.Store(false)
+		app.notifyBirthdays(context.Background(), now.Add(15*time.Minute))
+		assert.Len(t, server.Messages(), 1)
+	})
+}
@@ -0,0 +1,815 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ab0utbla-k/rvt-hello-app/internal/data"
+	"github.com/ab0utbla-k/rvt-hello-app/internal/outbox"
+	"github.com/ab0utbla-k/rvt-hello-app/internal/ratelimit"
+	"github.com/ab0utbla-k/rvt-hello-app/internal/testutils"
+	"github.com/julienschmidt/httprouter"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// routeStatuses lists the status codes each route can respond with, keyed by
+// method and OpenAPI path. A route missing from here fails
+// TestOpenAPI_DocumentsEveryRoute, so new routes have to be documented
+// before they can be merged.
+var routeStatuses = map[string][]int{
+	"GET /healthcheck":                 {200, 500},
+	"GET /livez":                       {200, 500},
+	"GET /readyz":                      {200, 500, 503},
+	"GET /openapi.json":                {200},
+	"GET /debug/vars":                  {200},
+	"GET /v1/hello/{username}":         {200, 400, 401, 403, 404, 429, 500, 503},
+	"PUT /v1/hello/{username}":         {204, 400, 401, 403, 409, 422, 429, 500, 503},
+	"GET /hello/{username}":            {200, 400, 401, 403, 404, 429, 500, 503},
+	"PUT /hello/{username}":            {204, 400, 401, 403, 409, 422, 429, 500, 503},
+	"GET /v1/api-keys":                 {200, 401, 403, 429, 500, 503},
+	"POST /v1/api-keys":                {201, 400, 401, 403, 422, 429, 500, 503},
+	"DELETE /v1/api-keys/{id}":         {204, 400, 401, 403, 404, 409, 422, 429, 500, 503},
+	"GET /v1/webhooks":                 {200, 401, 403, 429, 500, 503},
+	"POST /v1/webhooks":                {201, 400, 401, 403, 422, 429, 500, 503},
+	"DELETE /v1/webhooks/{id}":         {204, 400, 401, 403, 404, 409, 422, 429, 500, 503},
+	"GET /v1/webhooks/{id}/deliveries": {200, 401, 403, 404, 429, 500, 503},
+	"POST /v1/webhooks/{id}/deliveries/{delivery}/redeliver": {202, 400, 401, 403, 404, 409, 422, 429, 500, 503},
+}
+
+var (
+	routeParamRX    = regexp.MustCompile(`:(\w+)`)
+	versionPrefixRX = regexp.MustCompile(`^/v\d+/`)
+)
+
+// openAPIPath converts an httprouter path such as /hello/:username to its
+// OpenAPI form, /hello/{username}.
+func openAPIPath(path string) string {
+	return routeParamRX.ReplaceAllString(path, "{$1}")
+}
+
+// openAPIDocument is the parsed openapi.json, with helpers to follow the
+// $refs between its sections.
+type openAPIDocument struct {
+	root     map[string]any
+	compiler *jsonschema.Compiler
+}
+
+func loadOpenAPIDocument(t *testing.T) *openAPIDocument {
+	t.Helper()
+
+	var root map[string]any
+	require.NoError(t, json.Unmarshal(openAPISpec, &root))
+
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(openAPISpec))
+	require.NoError(t, err)
+
+	compiler := jsonschema.NewCompiler()
+	compiler.DefaultDraft(jsonschema.Draft2020)
+	compiler.AssertFormat()
+	require.NoError(t, compiler.AddResource("openapi.json", doc))
+
+	return &openAPIDocument{root: root, compiler: compiler}
+}
+
+// lookup walks tokens down from the document root, following any $ref it
+// meets, and returns the node it ends at together with its JSON pointer.
+func (d *openAPIDocument) lookup(tokens ...string) (any, string, bool) {
+	var (
+		node    any = d.root
+		pointer string
+	)
+
+	follow := func() bool {
+		for {
+			obj, ok := node.(map[string]any)
+			if !ok {
+				return true
+			}
+
+			ref, ok := obj["$ref"].(string)
+			if !ok {
+				return true
+			}
+
+			if !strings.HasPrefix(ref, "#/") {
+				return false
+			}
+
+			pointer = ""
+			node = d.root
+
+			for _, token := range strings.Split(ref[2:], "/") {
+				token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+
+				obj, ok := node.(map[string]any)
+				if !ok {
+					return false
+				}
+
+				node, ok = obj[token]
+				if !ok {
+					return false
+				}
+
+				pointer += "/" + escapePointerToken(token)
+			}
+		}
+	}
+
+	for _, token := range tokens {
+		if !follow() {
+			return nil, "", false
+		}
+
+		obj, ok := node.(map[string]any)
+		if !ok {
+			return nil, "", false
+		}
+
+		node, ok = obj[token]
+		if !ok {
+			return nil, "", false
+		}
+
+		pointer += "/" + escapePointerToken(token)
+	}
+
+	if !follow() {
+		return nil, "", false
+	}
+
+	return node, pointer, true
+}
+
+func (d *openAPIDocument) schema(t *testing.T, tokens ...string) *jsonschema.Schema {
+	t.Helper()
+
+	_, pointer, ok := d.lookup(tokens...)
+	require.True(t, ok, "no schema at %v", tokens)
+
+	schema, err := d.compiler.Compile("openapi.json#" + pointer)
+	require.NoError(t, err)
+
+	return schema
+}
+
+func escapePointerToken(token string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(token)
+}
+
+func TestOpenAPIHandler(t *testing.T) {
+	t.Parallel()
+
+	app := &application{
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/openapi.json", nil)
+
+	app.openAPIHandler(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	var spec struct {
+		OpenAPI string `json:"openapi"`
+		Info    struct {
+			Version string `json:"version"`
+		} `json:"info"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &spec))
+	assert.Equal(t, "3.1.0", spec.OpenAPI)
+	assert.Equal(t, version, spec.Info.Version)
+}
+
+// TestOpenAPI_DocumentsEveryRoute walks the routing table and fails if a
+// route, or a status code it can respond with, is missing from the spec, or
+// if the spec documents an operation that no longer exists.
+func TestOpenAPI_DocumentsEveryRoute(t *testing.T) {
+	t.Parallel()
+
+	doc := loadOpenAPIDocument(t)
+	app := &application{
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	// Only the unversioned aliases of API routes should be marked deprecated.
+	deprecatedRoutes := make(map[string]bool)
+	for _, rt := range app.apiRoutes() {
+		if !versionPrefixRX.MatchString(rt.path) {
+			deprecatedRoutes[rt.method+" "+rt.path] = true
+		}
+	}
+
+	registered := make(map[string]bool)
+
+	for _, rt := range app.routeTable() {
+		method := strings.ToLower(rt.method)
+		path := openAPIPath(rt.path)
+		key := rt.method + " " + path
+		registered[key] = true
+
+		t.Run(key, func(t *testing.T) {
+			statuses, ok := routeStatuses[key]
+			require.True(t, ok, "add %s to routeStatuses", key)
+
+			node, _, ok := doc.lookup("paths", path, method)
+			require.True(t, ok, "%s is not documented in openapi.json", key)
+
+			operation := node.(map[string]any)
+			deprecated, _ := operation["deprecated"].(bool)
+			assert.Equal(t, deprecatedRoutes[rt.method+" "+rt.path], deprecated, "deprecated flag of %s", key)
+
+			for _, status := range statuses {
+				_, _, ok := doc.lookup("paths", path, method, "responses", strconv.Itoa(status))
+				assert.True(t, ok, "%s does not document status %d", key, status)
+			}
+		})
+	}
+
+	paths, _, _ := doc.lookup("paths")
+	for path, item := range paths.(map[string]any) {
+		for method := range item.(map[string]any) {
+			if method == "parameters" {
+				continue
+			}
+
+			key := strings.ToUpper(method) + " " + path
+			assert.True(t, registered[key], "openapi.json documents %s, which is not routed", key)
+		}
+	}
+}
+
+// TestOpenAPI_RequestResponseValidation sends requests through the real
+// route table and checks them, and the responses they get, against the spec.
+func TestOpenAPI_RequestResponseValidation(t *testing.T) {
+	doc := loadOpenAPIDocument(t)
+	db := testutils.SetupSQLiteTestDB(t)
+
+	app := &application{
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		models: data.NewSQLiteModels(db),
+	}
+	app.config.idempotency.ttl = time.Hour
+	app.sinks = []outbox.Sink{outbox.WebhookSink{Webhooks: app.models.Webhooks}}
+	app.ready.Store(true)
+
+	router := httprouter.New()
+	router.NotFound = http.HandlerFunc(app.notFoundResponse)
+	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
+
+	for _, rt := range app.routeTable() {
+		router.HandlerFunc(rt.method, rt.path, rt.handler)
+	}
+
+	keys := map[string]string{"none": ""}
+	for _, scope := range data.Scopes {
+		key, err := data.GenerateAPIKey(scope, []string{scope})
+		require.NoError(t, err)
+		require.NoError(t, app.models.APIKeys.Insert(key))
+		keys[scope] = key.Plaintext
+	}
+
+	// The cases run in order, so later ones can read users saved earlier.
+	// Requests are sent with the admin key unless key names another.
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		route          string
+		accept         string
+		key            string
+		idempotencyKey string
+		body           string
+		validRequest   bool
+		expectStatus   int
+	}{
+		{
+			name:         "save user",
+			method:       http.MethodPut,
+			path:         "/v1/hello/alice",
+			route:        "/v1/hello/{username}",
+			body:         `{"dateOfBirth": "1990-01-01"}`,
+			validRequest: true,
+			expectStatus: http.StatusNoContent,
+		},
+		{
+			name:         "save user through legacy alias",
+			method:       http.MethodPut,
+			path:         "/hello/bob",
+			route:        "/hello/{username}",
+			body:         `{"dateOfBirth": "1991-02-03"}`,
+			validRequest: true,
+			expectStatus: http.StatusNoContent,
+		},
+		{
+			name:         "save user with preferred locale",
+			method:       http.MethodPut,
+			path:         "/v1/hello/carl",
+			route:        "/v1/hello/{username}",
+			body:         `{"dateOfBirth": "1985-07-14", "locale": "de"}`,
+			validRequest: true,
+			expectStatus: http.StatusNoContent,
+		},
+		{
+			name:         "save user with email and time zone",
+			method:       http.MethodPut,
+			path:         "/v1/hello/erin",
+			route:        "/v1/hello/{username}",
+			body:         `{"dateOfBirth": "1993-05-10", "email": "erin@example.com", "timeZone": "Asia/Tokyo"}`,
+			validRequest: true,
+			expectStatus: http.StatusNoContent,
+		},
+		{
+			name:         "save user with invalid time zone",
+			method:       http.MethodPut,
+			path:         "/v1/hello/erin",
+			route:        "/v1/hello/{username}",
+			body:         `{"dateOfBirth": "1993-05-10", "timeZone": "Mars/Olympus"}`,
+			validRequest: true,
+			expectStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "save user with idempotency key",
+			method:         http.MethodPut,
+			path:           "/v1/hello/dave",
+			route:          "/v1/hello/{username}",
+			idempotencyKey: "save-dave",
+			body:           `{"dateOfBirth": "1992-03-04"}`,
+			validRequest:   true,
+			expectStatus:   http.StatusNoContent,
+		},
+		{
+			name:           "replay idempotency key",
+			method:         http.MethodPut,
+			path:           "/v1/hello/dave",
+			route:          "/v1/hello/{username}",
+			idempotencyKey: "save-dave",
+			body:           `{"dateOfBirth": "1992-03-04"}`,
+			validRequest:   true,
+			expectStatus:   http.StatusNoContent,
+		},
+		{
+			name:           "reuse idempotency key for another request",
+			method:         http.MethodPut,
+			path:           "/v1/hello/dave",
+			route:          "/v1/hello/{username}",
+			idempotencyKey: "save-dave",
+			body:           `{"dateOfBirth": "1993-03-04"}`,
+			validRequest:   true,
+			expectStatus:   http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "malformed idempotency key",
+			method:         http.MethodPut,
+			path:           "/v1/hello/dave",
+			route:          "/v1/hello/{username}",
+			idempotencyKey: strings.Repeat("k", 256),
+			body:           `{"dateOfBirth": "1992-03-04"}`,
+			validRequest:   true,
+			expectStatus:   http.StatusBadRequest,
+		},
+		{
+			name:         "unsupported locale",
+			method:       http.MethodPut,
+			path:         "/v1/hello/carl",
+			route:        "/v1/hello/{username}",
+			body:         `{"dateOfBirth": "1985-07-14", "locale": "fr"}`,
+			expectStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:         "get birthday message",
+			method:       http.MethodGet,
+			path:         "/v1/hello/alice",
+			route:        "/v1/hello/{username}",
+			expectStatus: http.StatusOK,
+		},
+		{
+			name:         "get birthday message through legacy alias",
+			method:       http.MethodGet,
+			path:         "/hello/bob",
+			route:        "/hello/{username}",
+			expectStatus: http.StatusOK,
+		},
+		{
+			name:         "unknown user",
+			method:       http.MethodGet,
+			path:         "/v1/hello/nobody",
+			route:        "/v1/hello/{username}",
+			expectStatus: http.StatusNotFound,
+		},
+		{
+			name:         "unknown message template",
+			method:       http.MethodGet,
+			path:         "/v1/hello/alice?template=nope",
+			route:        "/v1/hello/{username}",
+			expectStatus: http.StatusBadRequest,
+		},
+		{
+			name:         "date of birth in the future",
+			method:       http.MethodPut,
+			path:         "/v1/hello/alice",
+			route:        "/v1/hello/{username}",
+			body:         `{"dateOfBirth": "2999-01-01"}`,
+			validRequest: true,
+			expectStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:         "unknown user as problem details",
+			method:       http.MethodGet,
+			path:         "/v1/hello/nobody",
+			route:        "/v1/hello/{username}",
+			accept:       "application/problem+json",
+			expectStatus: http.StatusNotFound,
+		},
+		{
+			name:         "failed validation as problem details",
+			method:       http.MethodPut,
+			path:         "/v1/hello/alice",
+			route:        "/v1/hello/{username}",
+			accept:       "application/problem+json",
+			body:         `{"dateOfBirth": "2999-01-01"}`,
+			validRequest: true,
+			expectStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:         "malformed date",
+			method:       http.MethodPut,
+			path:         "/v1/hello/alice",
+			route:        "/v1/hello/{username}",
+			body:         `{"dateOfBirth": "01/01/1990"}`,
+			expectStatus: http.StatusBadRequest,
+		},
+		{
+			name:         "wrong type",
+			method:       http.MethodPut,
+			path:         "/v1/hello/alice",
+			route:        "/v1/hello/{username}",
+			body:         `{"dateOfBirth": 19900101}`,
+			expectStatus: http.StatusBadRequest,
+		},
+		{
+			name:         "unknown field",
+			method:       http.MethodPut,
+			path:         "/v1/hello/alice",
+			route:        "/v1/hello/{username}",
+			body:         `{"dateOfBirth": "1990-01-01", "name": "alice"}`,
+			expectStatus: http.StatusBadRequest,
+		},
+		{
+			name:         "missing date of birth",
+			method:       http.MethodPut,
+			path:         "/v1/hello/alice",
+			route:        "/v1/hello/{username}",
+			body:         `{}`,
+			expectStatus: http.StatusBadRequest,
+		},
+		{
+			name:         "get birthday message without api key",
+			method:       http.MethodGet,
+			path:         "/v1/hello/alice",
+			route:        "/v1/hello/{username}",
+			key:          "none",
+			expectStatus: http.StatusUnauthorized,
+		},
+		{
+			name:         "save user without api key",
+			method:       http.MethodPut,
+			path:         "/v1/hello/alice",
+			route:        "/v1/hello/{username}",
+			key:          "none",
+			body:         `{"dateOfBirth": "1990-01-01"}`,
+			validRequest: true,
+			expectStatus: http.StatusUnauthorized,
+		},
+		{
+			name:         "save user with read-only api key",
+			method:       http.MethodPut,
+			path:         "/v1/hello/alice",
+			route:        "/v1/hello/{username}",
+			key:          "read",
+			body:         `{"dateOfBirth": "1990-01-01"}`,
+			validRequest: true,
+			expectStatus: http.StatusForbidden,
+		},
+		{
+			name:         "create api key",
+			method:       http.MethodPost,
+			path:         "/v1/api-keys",
+			route:        "/v1/api-keys",
+			body:         `{"name": "billing", "scopes": ["read", "write"]}`,
+			validRequest: true,
+			expectStatus: http.StatusCreated,
+		},
+		{
+			name:         "create api key with unknown scope",
+			method:       http.MethodPost,
+			path:         "/v1/api-keys",
+			route:        "/v1/api-keys",
+			body:         `{"name": "billing", "scopes": ["delete"]}`,
+			expectStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:         "create api key with write api key",
+			method:       http.MethodPost,
+			path:         "/v1/api-keys",
+			route:        "/v1/api-keys",
+			key:          "write",
+			body:         `{"name": "billing", "scopes": ["read"]}`,
+			validRequest: true,
+			expectStatus: http.StatusForbidden,
+		},
+		{
+			name:         "list api keys",
+			method:       http.MethodGet,
+			path:         "/v1/api-keys",
+			route:        "/v1/api-keys",
+			expectStatus: http.StatusOK,
+		},
+		{
+			name:           "revoke api key",
+			method:         http.MethodDelete,
+			path:           "/v1/api-keys/4",
+			route:          "/v1/api-keys/{id}",
+			idempotencyKey: "revoke-4",
+			expectStatus:   http.StatusNoContent,
+		},
+		{
+			name:           "retry revoking api key",
+			method:         http.MethodDelete,
+			path:           "/v1/api-keys/4",
+			route:          "/v1/api-keys/{id}",
+			idempotencyKey: "revoke-4",
+			expectStatus:   http.StatusNoContent,
+		},
+		{
+			name:         "revoke unknown api key",
+			method:       http.MethodDelete,
+			path:         "/v1/api-keys/999",
+			route:        "/v1/api-keys/{id}",
+			expectStatus: http.StatusNotFound,
+		},
+		{
+			name:         "list api keys without api key",
+			method:       http.MethodGet,
+			path:         "/v1/api-keys",
+			route:        "/v1/api-keys",
+			key:          "none",
+			expectStatus: http.StatusUnauthorized,
+		},
+		{
+			name:         "create webhook",
+			method:       http.MethodPost,
+			path:         "/v1/webhooks",
+			route:        "/v1/webhooks",
+			body:         `{"url": "https://hooks.example.com/birthdays", "events": ["user.created", "user.birthday"]}`,
+			validRequest: true,
+			expectStatus: http.StatusCreated,
+		},
+		{
+			name:         "create webhook with unknown event",
+			method:       http.MethodPost,
+			path:         "/v1/webhooks",
+			route:        "/v1/webhooks",
+			body:         `{"url": "https://hooks.example.com/birthdays", "events": ["user.deleted"]}`,
+			expectStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:         "create webhook with short secret",
+			method:       http.MethodPost,
+			path:         "/v1/webhooks",
+			route:        "/v1/webhooks",
+			body:         `{"url": "https://hooks.example.com/birthdays", "events": ["user.created"], "secret": "hunter2"}`,
+			expectStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:         "save user subscribed to",
+			method:       http.MethodPut,
+			path:         "/v1/hello/frank",
+			route:        "/v1/hello/{username}",
+			body:         `{"dateOfBirth": "1988-03-04", "locale": "en"}`,
+			validRequest: true,
+			expectStatus: http.StatusNoContent,
+		},
+		{
+			name:         "list webhooks",
+			method:       http.MethodGet,
+			path:         "/v1/webhooks",
+			route:        "/v1/webhooks",
+			expectStatus: http.StatusOK,
+		},
+		{
+			name:         "list webhook deliveries",
+			method:       http.MethodGet,
+			path:         "/v1/webhooks/1/deliveries",
+			route:        "/v1/webhooks/{id}/deliveries",
+			expectStatus: http.StatusOK,
+		},
+		{
+			name:         "list deliveries of unknown webhook",
+			method:       http.MethodGet,
+			path:         "/v1/webhooks/999/deliveries",
+			route:        "/v1/webhooks/{id}/deliveries",
+			expectStatus: http.StatusNotFound,
+		},
+		{
+			name:           "redeliver webhook delivery",
+			method:         http.MethodPost,
+			path:           "/v1/webhooks/1/deliveries/1/redeliver",
+			route:          "/v1/webhooks/{id}/deliveries/{delivery}/redeliver",
+			idempotencyKey: "redeliver-1",
+			expectStatus:   http.StatusAccepted,
+		},
+		{
+			name:         "redeliver unknown delivery",
+			method:       http.MethodPost,
+			path:         "/v1/webhooks/1/deliveries/999/redeliver",
+			route:        "/v1/webhooks/{id}/deliveries/{delivery}/redeliver",
+			expectStatus: http.StatusNotFound,
+		},
+		{
+			name:         "list webhooks with write api key",
+			method:       http.MethodGet,
+			path:         "/v1/webhooks",
+			route:        "/v1/webhooks",
+			key:          "write",
+			expectStatus: http.StatusForbidden,
+		},
+		{
+			name:           "delete webhook",
+			method:         http.MethodDelete,
+			path:           "/v1/webhooks/1",
+			route:          "/v1/webhooks/{id}",
+			idempotencyKey: "delete-webhook-1",
+			expectStatus:   http.StatusNoContent,
+		},
+		{
+			name:         "delete unknown webhook",
+			method:       http.MethodDelete,
+			path:         "/v1/webhooks/1",
+			route:        "/v1/webhooks/{id}",
+			expectStatus: http.StatusNotFound,
+		},
+		{
+			name:         "healthcheck",
+			method:       http.MethodGet,
+			path:         "/healthcheck",
+			route:        "/healthcheck",
+			expectStatus: http.StatusOK,
+		},
+		{
+			name:         "liveness",
+			method:       http.MethodGet,
+			path:         "/livez",
+			route:        "/livez",
+			expectStatus: http.StatusOK,
+		},
+		{
+			name:         "readiness",
+			method:       http.MethodGet,
+			path:         "/readyz",
+			route:        "/readyz",
+			expectStatus: http.StatusOK,
+		},
+		{
+			name:         "openapi document",
+			method:       http.MethodGet,
+			path:         "/openapi.json",
+			route:        "/openapi.json",
+			expectStatus: http.StatusOK,
+		},
+		{
+			name:         "metrics",
+			method:       http.MethodGet,
+			path:         "/debug/vars",
+			route:        "/debug/vars",
+			expectStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := strings.ToLower(tt.method)
+
+			var body io.Reader
+			if tt.body != "" {
+				schema := doc.schema(t, "paths", tt.route, method, "requestBody", "content", "application/json", "schema")
+
+				instance, err := jsonschema.UnmarshalJSON(strings.NewReader(tt.body))
+				require.NoError(t, err)
+
+				err = schema.Validate(instance)
+				if tt.validRequest {
+					assert.NoError(t, err, "request body should match the spec")
+				} else {
+					assert.Error(t, err, "request body should violate the spec")
+				}
+
+				body = strings.NewReader(tt.body)
+			}
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(tt.method, tt.path, body)
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			if tt.idempotencyKey != "" {
+				r.Header.Set("Idempotency-Key", tt.idempotencyKey)
+			}
+			if tt.key == "" {
+				tt.key = data.ScopeAdmin
+			}
+			if keys[tt.key] != "" {
+				r.Header.Set("Authorization", "Bearer "+keys[tt.key])
+			}
+			router.ServeHTTP(w, r)
+
+			// Publish the events the request recorded, as the dispatcher
+			// would in the background.
+			app.flushOutbox(context.Background())
+
+			require.Equal(t, tt.expectStatus, w.Code, w.Body.String())
+
+			doc.checkResponse(t, tt.route, method, w)
+		})
+	}
+}
+
+// checkResponse checks that w's status is documented for the operation, that
+// the documented headers are present, and that the body matches the schema
+// of its media type.
+func (d *openAPIDocument) checkResponse(t *testing.T, route, method string, w *httptest.ResponseRecorder) {
+	t.Helper()
+
+	status := strconv.Itoa(w.Code)
+	node, _, ok := d.lookup("paths", route, method, "responses", status)
+	require.True(t, ok, "status %s is not documented for %s %s", status, method, route)
+
+	response := node.(map[string]any)
+
+	if headers, ok := response["headers"].(map[string]any); ok {
+		for name := range headers {
+			assert.NotEmpty(t, w.Header().Get(name), "missing documented header %s", name)
+		}
+	}
+
+	if _, ok := response["content"]; !ok {
+		assert.Empty(t, w.Body.String())
+		return
+	}
+
+	mediaType, _, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	require.NoError(t, err)
+
+	_, _, ok = d.lookup("paths", route, method, "responses", status, "content", mediaType)
+	require.True(t, ok, "%s is not documented for status %s of %s %s", mediaType, status, method, route)
+
+	schema := d.schema(t, "paths", route, method, "responses", status, "content", mediaType, "schema")
+
+	instance, err := jsonschema.UnmarshalJSON(bytes.NewReader(w.Body.Bytes()))
+	require.NoError(t, err)
+	assert.NoError(t, schema.Validate(instance), "response body should match the spec")
+}
+
+// TestOpenAPI_RateLimitResponse checks the 429 that no request in
+// TestOpenAPI_RequestResponseValidation runs into.
+func TestOpenAPI_RateLimitResponse(t *testing.T) {
+	doc := loadOpenAPIDocument(t)
+
+	app := &application{
+		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		models:  data.NewSQLiteModels(testutils.SetupSQLiteTestDB(t)),
+		limiter: ratelimit.NewMemory(map[string]ratelimit.Rate{rateLimitWrite: {RPS: 0.001, Burst: 1}}),
+	}
+	app.ready.Store(true)
+
+	router := httprouter.New()
+	for _, rt := range app.apiRoutes() {
+		router.HandlerFunc(rt.method, rt.path, rt.handler)
+	}
+
+	// The limit applies before the scope check, so anonymous requests use up
+	// the bucket too.
+	for _, expectStatus := range []int{http.StatusUnauthorized, http.StatusTooManyRequests} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPut, "/v1/hello/alice", strings.NewReader(`{"dateOfBirth": "1990-01-01"}`))
+		router.ServeHTTP(w, r)
+
+		require.Equal(t, expectStatus, w.Code, w.Body.String())
+		doc.checkResponse(t, "/v1/hello/{username}", "put", w)
+	}
+}
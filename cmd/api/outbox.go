@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/ab0utbla-k/rvt-hello-app/internal/data"
+)
+
+const (
+	outboxBatchSize = 100
+	// outboxDrainTimeout bounds how long shutdown waits for the outbox to
+	// be published. What is left is published after the next start.
+	outboxDrainTimeout = 10 * time.Second
+	// outboxRetention is how long dispatched events are kept. Birthday
+	// events are not recorded again while theirs is kept, so it must be
+	// longer than a birthday lasts around the world.
+	outboxRetention     = 7 * 24 * time.Hour
+	outboxPurgeInterval = time.Hour
+)
+
+// dispatchOutbox publishes the events in the outbox to app.sinks every
+// interval until ctx is done, and then drains it. Runs before the database
+// is ready are skipped.
+func (app *application) dispatchOutbox(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	purge := time.NewTicker(outboxPurgeInterval)
+	defer purge.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if !app.ready.Load() {
+				return
+			}
+
+			drain, cancel := context.WithTimeout(context.Background(), outboxDrainTimeout)
+			app.flushOutbox(drain)
+			cancel()
+
+			return
+		case <-ticker.C:
+			if !app.ready.Load() {
+				continue
+			}
+
+			app.flushOutbox(ctx)
+		case <-purge.C:
+			if !app.ready.Load() {
+				continue
+			}
+
+			n, err := app.models.Outbox.DeleteDispatched(time.Now().Add(-outboxRetention))
+			if err != nil {
+				app.logger.Error("purging outbox", "error", err.Error())
+				continue
+			}
+
+			if n > 0 {
+				app.logger.Info("purged dispatched outbox events", "count", n)
+			}
+		}
+	}
+}
+
+// flushOutbox publishes batches of events until the outbox is empty, a
+// sink fails or ctx is done.
+func (app *application) flushOutbox(ctx context.Context) {
+	for ctx.Err() == nil {
+		n, err := app.models.Outbox.Dispatch(outboxBatchSize, app.publishEvent)
+		if err != nil {
+			app.logger.Error("dispatching outbox events", "dispatched", n, "error", err.Error())
+			return
+		}
+
+		if n < outboxBatchSize {
+			return
+		}
+	}
+}
+
+// publishEvent hands e to every sink in turn. It stays in the outbox
+// unless all of them accept it, so the sinks before a failing one see it
+// again on the next run.
+func (app *application) publishEvent(e *data.OutboxEvent) error {
+	for _, sink := range app.sinks {
+		err := sink.Publish(e)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// recordBirthday records user.birthday in the outbox. Its event ID names
+// the user and the date, so repeated runs record it once.
+func (app *application) recordBirthday(user *data.User, birthday data.Birthday) error {
+	event, err := data.NewBirthdayEvent(user, birthday)
+	if err != nil {
+		return err
+	}
+
+	return app.models.Outbox.Insert(event)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ab0utbla-k/rvt-hello-app/internal/data"
+	"github.com/ab0utbla-k/rvt-hello-app/internal/outbox"
+	"github.com/ab0utbla-k/rvt-hello-app/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingSink keeps the events published to it, and fails with err when
+// it is set.
+type recordingSink struct {
+	mu     sync.Mutex
+	events []*data.OutboxEvent
+	err    error
+}
+
+func (s *recordingSink) Publish(e *data.OutboxEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+	s.events = append(s.events, e)
+	return nil
+}
+
+func (s *recordingSink) types() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var types []string
+	for _, e := range s.events {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+func newOutboxTestApp(t *testing.T, sinks ...outbox.Sink) *application {
+	t.Helper()
+
+	app := &application{
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		models: data.NewSQLiteModels(testutils.SetupSQLiteTestDB(t)),
+		sinks:  sinks,
+	}
+	app.ready.Store(true)
+
+	return app
+}
+
+func TestFlushOutbox(t *testing.T) {
+	t.Parallel()
+
+	sink := &recordingSink{}
+	app := newOutboxTestApp(t, sink)
+
+	user := &data.User{Username: "john", DateOfBirth: time.Date(1990, time.May, 10, 0, 0, 0, 0, time.UTC), Email: "john@example.com"}
+
+	_, err := app.models.Users.Insert(user)
+	require.NoError(t, err)
+	_, err = app.models.Users.Insert(user)
+	require.NoError(t, err)
+
+	app.flushOutbox(context.Background())
+	assert.Equal(t, []string{data.EventUserCreated, data.EventUserUpdated}, sink.types())
+
+	var payload data.EventPayload
+	require.NoError(t, json.Unmarshal(sink.events[1].Payload, &payload))
+	assert.Equal(t, sink.events[1].EventID, payload.ID)
+	assert.Equal(t, data.UserData{Username: "john", DateOfBirth: "1990-05-10"}, payload.Data, "events must not carry email addresses")
+
+	// Dispatched events are not published again.
+	app.flushOutbox(context.Background())
+	assert.Len(t, sink.types(), 2)
+}
+
+func TestFlushOutbox_FailingSink(t *testing.T) {
+	t.Parallel()
+
+	first := &recordingSink{}
+	second := &recordingSink{err: errors.New("disk full")}
+	app := newOutboxTestApp(t, first, second)
+
+	_, err := app.models.Users.Insert(&data.User{Username: "john", DateOfBirth: time.Now().AddDate(-30, 0, 0)})
+	require.NoError(t, err)
+
+	app.flushOutbox(context.Background())
+	assert.Len(t, first.types(), 1)
+	assert.Empty(t, second.types())
+
+	// The event stays in the outbox until every sink takes it, so the
+	// sinks before the failing one see it again.
+	second.mu.Lock()
+	second.err = nil
+	second.mu.Unlock()
+
+	app.flushOutbox(context.Background())
+	assert.Len(t, first.types(), 2)
+	assert.Equal(t, first.events[0].EventID, first.events[1].EventID)
+	assert.Len(t, second.types(), 1)
+}
+
+func TestDispatchOutbox_DrainsOnShutdown(t *testing.T) {
+	t.Parallel()
+
+	sink := &recordingSink{}
+	app := newOutboxTestApp(t, sink)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		app.dispatchOutbox(ctx, time.Hour)
+	}()
+
+	_, err := app.models.Users.Insert(&data.User{Username: "john", DateOfBirth: time.Now().AddDate(-30, 0, 0)})
+	require.NoError(t, err)
+
+	cancel()
+	<-done
+
+	assert.Equal(t, []string{data.EventUserCreated}, sink.types())
+}
+
+func TestParseOutboxSinks(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		input       string
+		expect      []string
+		expectError bool
+	}{
+		{input: "", expect: nil},
+		{input: "webhook", expect: []string{"webhook"}},
+		{input: " log, File ,webhook", expect: []string{"log", "file", "webhook"}},
+		{input: "kafka", expectError: true},
+		{input: "log,log", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			t.Parallel()
+
+			sinks, err := parseOutboxSinks(tt.input)
+			if tt.expectError {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expect, sinks)
+		})
+	}
+}
@@ -3,21 +3,117 @@ package main
 import (
 	"expvar"
 	"net/http"
+	"time"
 
+	"github.com/ab0utbla-k/rvt-hello-app/internal/data"
 	"github.com/julienschmidt/httprouter"
 )
 
+// The unversioned API paths were superseded by /v1 on legacyDeprecation and
+// will be removed on legacySunset.
+var (
+	legacyDeprecation = time.Date(2026, time.October, 18, 0, 0, 0, 0, time.UTC)
+	legacySunset      = time.Date(2027, time.April, 18, 0, 0, 0, 0, time.UTC)
+)
+
+// Rate limit groups. Each has its own RATE_LIMIT_<GROUP>_* settings.
+const (
+	rateLimitRead  = "read"
+	rateLimitWrite = "write"
+	rateLimitAdmin = "admin"
+)
+
+// route is one entry of the routing table. httprouter cannot list what was
+// registered with it, so the table is also what the OpenAPI tests walk.
+type route struct {
+	method  string
+	path    string
+	handler http.HandlerFunc
+}
+
 func (app *application) routes() http.Handler {
+	return app.metrics(app.standardMiddleware(app.newRouter(app.routeTable())))
+}
+
+func (app *application) newRouter(routes []route) *httprouter.Router {
 	router := httprouter.New()
 
 	router.NotFound = http.HandlerFunc(app.notFoundResponse)
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
 
-	router.HandlerFunc(http.MethodGet, "/healthcheck", app.healthcheckHandler)
-	router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
+	for _, rt := range routes {
+		router.HandlerFunc(rt.method, rt.path, rt.handler)
+	}
+
+	return router
+}
+
+// standardMiddleware wraps the router in what every request goes through.
+// metrics is left out, as it publishes expvars that can only be set up once
+// per process.
+func (app *application) standardMiddleware(next http.Handler) http.Handler {
+	return app.secureHeaders(app.recoverPanic(app.enableCORS(next)))
+}
 
-	router.HandlerFunc(http.MethodGet, "/hello/:username", app.getBirthdayMessageHandler)
-	router.HandlerFunc(http.MethodPut, "/hello/:username", app.saveUserHandler)
+func (app *application) routeTable() []route {
+	routes := []route{
+		{http.MethodGet, "/healthcheck", app.healthcheckHandler},
+		{http.MethodGet, "/livez", app.livezHandler},
+		{http.MethodGet, "/readyz", app.readyzHandler},
+		{http.MethodGet, "/openapi.json", app.openAPIHandler},
+		{http.MethodGet, "/debug/vars", expvar.Handler().ServeHTTP},
+	}
+
+	return append(routes, app.apiRoutes()...)
+}
+
+// apiRoutes returns every version of the public API under its own prefix.
+// Each version has its own route table, so a /v2 can change the contract
+// while sharing app.models with /v1.
+func (app *application) apiRoutes() []route {
+	var routes []route
+
+	for _, rt := range app.v1Routes() {
+		routes = append(routes,
+			route{rt.method, "/v1" + rt.path, rt.handler},
+			// Clients written before /v1 existed still use the bare paths.
+			route{rt.method, rt.path, app.deprecated("/v1", rt.handler)},
+		)
+	}
+
+	// The admin routes postdate /v1, so they have no legacy alias.
+	for _, rt := range app.v1AdminRoutes() {
+		routes = append(routes, route{rt.method, "/v1" + rt.path, rt.handler})
+	}
+
+	return routes
+}
+
+// apiHandler wraps next in the middleware every API route shares: the
+// readiness check, authentication and the rate limit of group, which also
+// covers rejected credentials.
+func (app *application) apiHandler(group string, next http.HandlerFunc) http.HandlerFunc {
+	return app.requireReady(app.authenticate(group, app.rateLimit(group, next)))
+}
+
+func (app *application) v1Routes() []route {
+	return []route{
+		{http.MethodGet, "/hello/:username", app.apiHandler(rateLimitRead, app.requireReadScope(app.getBirthdayMessageHandler))},
+		{http.MethodPut, "/hello/:username", app.apiHandler(rateLimitWrite, app.requireScope(data.ScopeWrite, app.idempotent(app.saveUserHandler)))},
+	}
+}
 
-	return app.metrics(app.recoverPanic(router))
+// Creating a key or a webhook is not idempotent: the response holds the
+// plaintext key or the signing secret, which must never be stored.
+func (app *application) v1AdminRoutes() []route {
+	return []route{
+		{http.MethodGet, "/api-keys", app.apiHandler(rateLimitAdmin, app.requireScope(data.ScopeAdmin, app.listAPIKeysHandler))},
+		{http.MethodPost, "/api-keys", app.apiHandler(rateLimitAdmin, app.requireScope(data.ScopeAdmin, app.createAPIKeyHandler))},
+		{http.MethodDelete, "/api-keys/:id", app.apiHandler(rateLimitAdmin, app.requireScope(data.ScopeAdmin, app.idempotent(app.revokeAPIKeyHandler)))},
+		{http.MethodGet, "/webhooks", app.apiHandler(rateLimitAdmin, app.requireScope(data.ScopeAdmin, app.listWebhooksHandler))},
+		{http.MethodPost, "/webhooks", app.apiHandler(rateLimitAdmin, app.requireScope(data.ScopeAdmin, app.createWebhookHandler))},
+		{http.MethodDelete, "/webhooks/:id", app.apiHandler(rateLimitAdmin, app.requireScope(data.ScopeAdmin, app.idempotent(app.deleteWebhookHandler)))},
+		{http.MethodGet, "/webhooks/:id/deliveries", app.apiHandler(rateLimitAdmin, app.requireScope(data.ScopeAdmin, app.listWebhookDeliveriesHandler))},
+		{http.MethodPost, "/webhooks/:id/deliveries/:delivery/redeliver", app.apiHandler(rateLimitAdmin, app.requireScope(data.ScopeAdmin, app.idempotent(app.redeliverWebhookHandler)))},
+	}
 }
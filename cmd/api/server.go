@@ -9,6 +9,15 @@ import (
 	"os/signal"
 	"syscall"
 	"time"
+
+	"github.com/ab0utbla-k/rvt-hello-app/internal/ratelimit"
+)
+
+const (
+	rateLimitCleanupInterval = time.Minute
+	// rateLimitMaxIdle is how long the memory limiter keeps a client it has
+	// not seen.
+	rateLimitMaxIdle = 3 * time.Minute
 )
 
 func (app *application) serve() error {
@@ -22,6 +31,48 @@ func (app *application) serve() error {
 
 	shutdownError := make(chan error)
 
+	// Background work started here runs until the server shuts down.
+	background, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+		app.purgeIdempotencyKeys(background, idempotencyPurgeInterval)
+	}()
+
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+		app.cleanupRateLimits(background, rateLimitCleanupInterval)
+	}()
+
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+		app.monitorReplica(background, app.config.db.replica.checkInterval)
+	}()
+
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+		app.watchBirthdays(background, app.config.notifications.interval)
+	}()
+
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+		app.deliverWebhooks(background, webhookPollInterval)
+	}()
+
+	// The dispatcher drains the outbox once background is cancelled, after
+	// the server has stopped taking the requests that write to it.
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+		app.dispatchOutbox(background, app.config.outbox.interval)
+	}()
+
 	go func() {
 		quit := make(chan os.Signal, 1)
 		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -39,6 +90,7 @@ func (app *application) serve() error {
 
 		app.logger.Info("completing background tasks", "addr", srv.Addr)
 
+		stopBackground()
 		app.wg.Wait()
 		shutdownError <- nil
 	}()
@@ -59,3 +111,46 @@ func (app *application) serve() error {
 
 	return nil
 }
+
+// waitReady blocks until the database is ready, checking every interval,
+// and reports whether it is. It returns false once ctx is done.
+func (app *application) waitReady(ctx context.Context, interval time.Duration) bool {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for !app.ready.Load() {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+
+	return true
+}
+
+// cleanupRateLimits runs the rate limiter's cleanup every interval until ctx
+// is done. app.limiter is only set once the database is ready, so nothing
+// runs before then. Redis expires its own counters.
+func (app *application) cleanupRateLimits(ctx context.Context, interval time.Duration) {
+	if !app.waitReady(ctx, interval) {
+		return
+	}
+
+	switch l := app.limiter.(type) {
+	case *ratelimit.Memory:
+		l.Run(ctx, interval, rateLimitMaxIdle)
+	case *ratelimit.Postgres:
+		l.Run(ctx, interval, app.logger)
+	}
+}
+
+// monitorReplica checks the read replica's health every interval until ctx
+// is done, from when the database is ready.
+func (app *application) monitorReplica(ctx context.Context, interval time.Duration) {
+	if !app.waitReady(ctx, interval) || app.replica == nil {
+		return
+	}
+
+	app.replica.Monitor(ctx, interval)
+}
@@ -2,11 +2,13 @@ package main
 
 import (
 	"errors"
-	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/ab0utbla-k/rvt-hello-app/internal/data"
+	"github.com/ab0utbla-k/rvt-hello-app/internal/i18n"
+	"github.com/ab0utbla-k/rvt-hello-app/internal/templates"
 	"github.com/ab0utbla-k/rvt-hello-app/internal/validator"
 	"github.com/julienschmidt/httprouter"
 )
@@ -15,8 +17,16 @@ func (app *application) saveUserHandler(w http.ResponseWriter, r *http.Request)
 	params := httprouter.ParamsFromContext(r.Context())
 	username := params.ByName("username")
 
+	if !app.contextGetCaller(r).canActFor(username) {
+		app.notOwnerResponse(w, r)
+		return
+	}
+
 	var input struct {
 		DateOfBirth string `json:"dateOfBirth"`
+		Locale      string `json:"locale"`
+		Email       string `json:"email"`
+		TimeZone    string `json:"timeZone"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -27,13 +37,16 @@ func (app *application) saveUserHandler(w http.ResponseWriter, r *http.Request)
 
 	dateOfBirth, err := time.Parse("2006-01-02", input.DateOfBirth)
 	if err != nil {
-		app.badRequestResponse(w, r, fmt.Errorf("invalid date format, use YYYY-MM-DD"))
+		app.badRequestResponse(w, r, &i18n.Error{Key: "request.date_format"})
 		return
 	}
 
 	user := &data.User{
 		Username:    username,
 		DateOfBirth: dateOfBirth,
+		Locale:      input.Locale,
+		Email:       input.Email,
+		TimeZone:    input.TimeZone,
 	}
 
 	v := validator.New()
@@ -44,7 +57,9 @@ func (app *application) saveUserHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = app.models.Users.Insert(user)
+	// The event for webhooks and other sinks is recorded in the outbox in
+	// the same transaction.
+	_, err = app.models.Users.Insert(user)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -57,6 +72,15 @@ func (app *application) getBirthdayMessageHandler(w http.ResponseWriter, r *http
 	params := httprouter.ParamsFromContext(r.Context())
 	username := params.ByName("username")
 
+	template := app.messageTemplate(r)
+	if template != "" && !app.templates.Has(template) {
+		app.badRequestResponse(w, r, &i18n.Error{
+			Key:  "request.unknown_template",
+			Args: map[string]any{"name": strconv.Quote(template)},
+		})
+		return
+	}
+
 	user, err := app.models.Users.Get(username)
 	if err != nil {
 		switch {
@@ -68,11 +92,69 @@ func (app *application) getBirthdayMessageHandler(w http.ResponseWriter, r *http
 		return
 	}
 
-	message := user.GetBirthdayMessage()
+	// A locale the user chose for themselves wins over the client's.
+	locale := app.locale(r)
+	if user.Locale != "" {
+		locale = user.Locale
+	}
+
+	birthday := user.Birthday()
 
-	env := envelope{"message": message}
-	err = app.writeJSON(w, http.StatusOK, env, nil)
+	message, language, err := app.birthdayMessage(user.Username, birthday, locale, template)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	if language != "" {
+		headers.Set("Content-Language", language)
 	}
+	headers.Set("Vary", "Accept-Language")
+
+	env := envelope{
+		"message":           message,
+		"daysUntilBirthday": birthday.DaysUntil,
+		"nextBirthday":      birthday.Next.Format("2006-01-02"),
+		"ageTurning":        birthday.AgeTurning,
+		"isBirthdayToday":   birthday.IsToday,
+		"weekday":           birthday.Next.Weekday().String(),
+	}
+	err = app.writeJSON(w, http.StatusOK, env, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// messageTemplate returns the template the request asks for with
+// ?template=, or the configured default. Empty means the built-in messages.
+func (app *application) messageTemplate(r *http.Request) string {
+	if name := r.URL.Query().Get("template"); name != "" {
+		return name
+	}
+
+	return app.config.messages.defaultTemplate
+}
+
+// birthdayMessage renders the named template for birthday, or the built-in
+// message when name is empty. It also returns the language the message is
+// in, which is empty when the template has no variant for locale, as the
+// language of the variant it falls back to is not known.
+func (app *application) birthdayMessage(username string, birthday data.Birthday, locale, name string) (message, language string, err error) {
+	if name == "" {
+		return birthday.Message(username, locale), locale, nil
+	}
+
+	message, err = app.templates.Render(name, templates.Data{
+		Username:  username,
+		DaysUntil: birthday.DaysUntil,
+		Age:       birthday.AgeTurning,
+		IsToday:   birthday.IsToday,
+		Locale:    locale,
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	return message, app.templates.Variant(name, locale), nil
 }
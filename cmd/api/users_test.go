@@ -3,18 +3,25 @@ package main
 import (
 	"bytes"
 	"database/sql"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/ab0utbla-k/rvt-hello-app/internal/data"
+	"github.com/ab0utbla-k/rvt-hello-app/internal/templates"
 	"github.com/ab0utbla-k/rvt-hello-app/internal/testutils"
+	"github.com/ab0utbla-k/rvt-hello-app/internal/tokens"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/julienschmidt/httprouter"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -26,32 +33,72 @@ type APITestSuite struct {
 	app    *application
 	router *httprouter.Router
 	db     *sql.DB
+
+	// apiKey has the read and write scopes and is sent by makeRequest.
+	apiKey string
+	// jwtSecret signs end-user tokens the app accepts.
+	jwtSecret []byte
+
+	setupDB   func(t *testing.T) *sql.DB
+	cleanupDB func(t *testing.T, db *sql.DB)
+	newModels func(db *sql.DB) data.Models
 }
 
 func (suite *APITestSuite) SetupSuite() {
-	suite.db = testutils.SetupTestDB(suite.T())
+	suite.db = suite.setupDB(suite.T())
 
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
 
 	suite.app = &application{
 		logger: logger,
-		models: data.NewModels(suite.db),
+		models: suite.newModels(suite.db),
 	}
+	suite.app.config.auth.publicReads = true
+
+	suite.jwtSecret = []byte("0123456789abcdef0123456789abcdef")
+	jwks := filepath.Join(suite.T().TempDir(), "jwks.json")
+	require.NoError(suite.T(), os.WriteFile(jwks, []byte(`{"keys": [{"kty": "oct", "alg": "HS256", "k": "`+
+		base64.RawURLEncoding.EncodeToString(suite.jwtSecret)+`"}]}`), 0o600))
+
+	jwtKeys, err := tokens.LoadKeySet(jwks)
+	require.NoError(suite.T(), err)
+	suite.app.tokens = tokens.NewVerifier(jwtKeys, "", "")
 
 	suite.router = httprouter.New()
 	suite.router.MethodNotAllowed = http.HandlerFunc(suite.app.methodNotAllowedResponse)
 	suite.router.NotFound = http.HandlerFunc(suite.app.notFoundResponse)
 
-	suite.router.HandlerFunc(http.MethodPut, "/hello/:username", suite.app.saveUserHandler)
-	suite.router.HandlerFunc(http.MethodGet, "/hello/:username", suite.app.getBirthdayMessageHandler)
+	suite.app.ready.Store(true)
+	for _, rt := range suite.app.apiRoutes() {
+		suite.router.HandlerFunc(rt.method, rt.path, rt.handler)
+	}
 }
 
 func TestAPITestSuite(t *testing.T) {
-	suite.Run(t, new(APITestSuite))
+	suite.Run(t, &APITestSuite{
+		setupDB:   testutils.SetupTestDB,
+		cleanupDB: testutils.CleanupDB,
+		newModels: data.NewModels,
+	})
 }
 
+func TestAPITestSuite_SQLite(t *testing.T) {
+	suite.Run(t, &APITestSuite{
+		setupDB:   testutils.SetupSQLiteTestDB,
+		cleanupDB: testutils.CleanupSQLiteDB,
+		newModels: data.NewSQLiteModels,
+	})
+}
+
+// SetupTest empties the database, API keys included, and creates the key
+// makeRequest sends.
 func (suite *APITestSuite) SetupTest() {
-	testutils.CleanupDB(suite.T(), suite.db)
+	suite.cleanupDB(suite.T(), suite.db)
+
+	key, err := data.GenerateAPIKey("suite", []string{data.ScopeRead, data.ScopeWrite})
+	require.NoError(suite.T(), err)
+	require.NoError(suite.T(), suite.app.models.APIKeys.Insert(key))
+	suite.apiKey = key.Plaintext
 }
 
 func (suite *APITestSuite) makeRequest(method, path string, body any) *httptest.ResponseRecorder {
@@ -66,6 +113,7 @@ func (suite *APITestSuite) makeRequest(method, path string, body any) *httptest.
 	if body != nil {
 		r.Header.Set("Content-Type", "application/json")
 	}
+	r.Header.Set("Authorization", "Bearer "+suite.apiKey)
 
 	w := httptest.NewRecorder()
 	suite.router.ServeHTTP(w, r)
@@ -105,7 +153,7 @@ func (suite *APITestSuite) TestSaveUser_Success() {
 				"dateOfBirth": tt.dateOfBirth,
 			}
 
-			w := suite.makeRequest(http.MethodPut, "/hello/"+tt.username, payload)
+			w := suite.makeRequest(http.MethodPut, "/v1/hello/"+tt.username, payload)
 			assert.Equal(suite.T(), tt.expectCode, w.Code)
 
 			// Verify user was saved
@@ -157,8 +205,9 @@ func (suite *APITestSuite) TestSaveUser_InvalidJSON() {
 
 	for _, tt := range tests {
 		suite.Run(tt.name, func() {
-			req := httptest.NewRequest(http.MethodPut, "/hello/"+tt.username, strings.NewReader(tt.body))
+			req := httptest.NewRequest(http.MethodPut, "/v1/hello/"+tt.username, strings.NewReader(tt.body))
 			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer "+suite.apiKey)
 			w := httptest.NewRecorder()
 
 			suite.router.ServeHTTP(w, req)
@@ -180,24 +229,28 @@ func (suite *APITestSuite) TestSaveUser_ValidationFailure() {
 		username    string
 		dateOfBirth string
 		expectField string
+		expectCode  string
 	}{
 		{
 			name:        "username with numbers",
 			username:    "john123",
 			dateOfBirth: "1990-01-01",
 			expectField: "username",
+			expectCode:  "username.invalid_chars",
 		},
 		{
 			name:        "username with spaces",
 			username:    "john doe",
 			dateOfBirth: "1990-01-01",
 			expectField: "username",
+			expectCode:  "username.invalid_chars",
 		},
 		{
 			name:        "future date of birth",
 			username:    "john",
 			dateOfBirth: tomorrow,
 			expectField: "dateOfBirth",
+			expectCode:  "dateOfBirth.in_future",
 		},
 	}
 
@@ -207,16 +260,20 @@ func (suite *APITestSuite) TestSaveUser_ValidationFailure() {
 				"dateOfBirth": tt.dateOfBirth,
 			}
 			escaped := url.PathEscape(tt.username)
-			w := suite.makeRequest(http.MethodPut, "/hello/"+escaped, payload)
+			w := suite.makeRequest(http.MethodPut, "/v1/hello/"+escaped, payload)
 			assert.Equal(suite.T(), http.StatusUnprocessableEntity, w.Code)
 
-			var response envelope
+			var response struct {
+				Error  map[string]string `json:"error"`
+				Errors []fieldError      `json:"errors"`
+			}
 			err := json.Unmarshal(w.Body.Bytes(), &response)
 			require.NoError(suite.T(), err)
 
-			errorMap, ok := response["error"].(map[string]any)
-			require.True(suite.T(), ok)
-			assert.Contains(suite.T(), errorMap, tt.expectField)
+			assert.Contains(suite.T(), response.Error, tt.expectField)
+			require.Len(suite.T(), response.Errors, 1)
+			assert.Equal(suite.T(), tt.expectField, response.Errors[0].Field)
+			assert.Equal(suite.T(), tt.expectCode, response.Errors[0].Code)
 		})
 	}
 }
@@ -230,12 +287,12 @@ func (suite *APITestSuite) TestSaveUser_UpdateExisting() {
 
 	// Create original user
 	payload := map[string]string{"dateOfBirth": originalDate}
-	w := suite.makeRequest(http.MethodPut, "/hello/"+username, payload)
+	w := suite.makeRequest(http.MethodPut, "/v1/hello/"+username, payload)
 	assert.Equal(suite.T(), http.StatusNoContent, w.Code)
 
 	// Update user
 	payload = map[string]string{"dateOfBirth": updatedDate}
-	w = suite.makeRequest(http.MethodPut, "/hello/"+username, payload)
+	w = suite.makeRequest(http.MethodPut, "/v1/hello/"+username, payload)
 	assert.Equal(suite.T(), http.StatusNoContent, w.Code)
 
 	// Verify update
@@ -284,11 +341,11 @@ func (suite *APITestSuite) TestGetBirthdayMessage_ExistingUser() {
 				"dateOfBirth": tt.dateOfBirth.Format("2006-01-02"),
 			}
 
-			w := suite.makeRequest(http.MethodPut, "/hello/"+username, payload)
+			w := suite.makeRequest(http.MethodPut, "/v1/hello/"+username, payload)
 			require.Equal(suite.T(), http.StatusNoContent, w.Code)
 
 			// Get birthday message
-			w = suite.makeRequest(http.MethodGet, "/hello/"+username, nil)
+			w = suite.makeRequest(http.MethodGet, "/v1/hello/"+username, nil)
 			assert.Equal(suite.T(), http.StatusOK, w.Code)
 
 			var response envelope
@@ -304,7 +361,7 @@ func (suite *APITestSuite) TestGetBirthdayMessage_ExistingUser() {
 }
 
 func (suite *APITestSuite) TestGetBirthdayMessage_NonExistentUser() {
-	w := suite.makeRequest(http.MethodGet, "/hello/nonexistent", nil)
+	w := suite.makeRequest(http.MethodGet, "/v1/hello/nonexistent", nil)
 	assert.Equal(suite.T(), http.StatusNotFound, w.Code)
 
 	var response envelope
@@ -321,11 +378,11 @@ func (suite *APITestSuite) TestGetBirthdayMessage_ResponseFormat() {
 		"dateOfBirth": dateOfBirth.Format("2006-01-02"),
 	}
 
-	w := suite.makeRequest(http.MethodPut, "/hello/"+username, payload)
+	w := suite.makeRequest(http.MethodPut, "/v1/hello/"+username, payload)
 	require.Equal(suite.T(), http.StatusNoContent, w.Code)
 
 	// Get birthday message
-	w = suite.makeRequest(http.MethodGet, "/hello/"+username, nil)
+	w = suite.makeRequest(http.MethodGet, "/v1/hello/"+username, nil)
 	assert.Equal(suite.T(), http.StatusOK, w.Code)
 	assert.Equal(suite.T(), "application/json", w.Header().Get("Content-Type"))
 
@@ -337,7 +394,482 @@ func (suite *APITestSuite) TestGetBirthdayMessage_ResponseFormat() {
 	require.True(suite.T(), ok, "message must be a string")
 	assert.NotEmpty(suite.T(), message)
 	assert.True(suite.T(), strings.HasPrefix(message, "Hello, "+username+"!"))
-	assert.Len(suite.T(), response, 1, "response should only contain 'message' field")
+
+	birthday := (&data.User{DateOfBirth: dateOfBirth}).Birthday()
+
+	assert.Equal(suite.T(), envelope{
+		"message":           message,
+		"daysUntilBirthday": float64(birthday.DaysUntil),
+		"nextBirthday":      birthday.Next.Format("2006-01-02"),
+		"ageTurning":        float64(birthday.AgeTurning),
+		"isBirthdayToday":   birthday.IsToday,
+		"weekday":           birthday.Next.Weekday().String(),
+	}, response)
+}
+
+func (suite *APITestSuite) TestGetBirthdayMessage_Localized() {
+	today := time.Now()
+	inThreeDays := time.Date(1990, today.Month(), today.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, 3)
+
+	w := suite.makeRequest(http.MethodPut, "/v1/hello/anna", map[string]string{
+		"dateOfBirth": inThreeDays.Format("2006-01-02"),
+	})
+	require.Equal(suite.T(), http.StatusNoContent, w.Code)
+
+	w = suite.makeRequest(http.MethodPut, "/v1/hello/hans", map[string]string{
+		"dateOfBirth": inThreeDays.Format("2006-01-02"),
+		"locale":      "de",
+	})
+	require.Equal(suite.T(), http.StatusNoContent, w.Code)
+
+	tests := []struct {
+		name           string
+		username       string
+		acceptLanguage string
+		expectLanguage string
+		expectMessage  string
+	}{
+		{
+			name:           "no preference",
+			username:       "anna",
+			expectLanguage: "en",
+			expectMessage:  "Hello, anna! Your birthday is in 3 days",
+		},
+		{
+			name:           "accept-language",
+			username:       "anna",
+			acceptLanguage: "ru-RU,ru;q=0.9,en;q=0.5",
+			expectLanguage: "ru",
+			expectMessage:  "Привет, anna! До твоего дня рождения 3 дня",
+		},
+		{
+			name:           "stored locale wins over accept-language",
+			username:       "hans",
+			acceptLanguage: "ru",
+			expectLanguage: "de",
+			expectMessage:  "Hallo, hans! Dein Geburtstag ist in 3 Tagen",
+		},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			r := httptest.NewRequest(http.MethodGet, "/v1/hello/"+tt.username, nil)
+			if tt.acceptLanguage != "" {
+				r.Header.Set("Accept-Language", tt.acceptLanguage)
+			}
+
+			w := httptest.NewRecorder()
+			suite.router.ServeHTTP(w, r)
+
+			require.Equal(suite.T(), http.StatusOK, w.Code)
+			assert.Equal(suite.T(), tt.expectLanguage, w.Header().Get("Content-Language"))
+
+			var response envelope
+			require.NoError(suite.T(), json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Equal(suite.T(), tt.expectMessage, response["message"])
+		})
+	}
+}
+
+func (suite *APITestSuite) TestGetBirthdayMessage_Template() {
+	dir := suite.T().TempDir()
+	files := map[string]string{
+		"short.tmpl":    "{{if .IsToday}}Happy {{.Age}}th, {{.Username}}!{{else}}{{.Username}}: {{.DaysUntil}} days to go{{end}}",
+		"short.de.tmpl": "{{.Username}}: noch {{.DaysUntil}} Tage",
+		"banner.tmpl":   "🎉 {{.Username}} 🎉",
+	}
+	for name, content := range files {
+		require.NoError(suite.T(), os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+
+	set, err := templates.Load(dir)
+	require.NoError(suite.T(), err)
+
+	suite.app.templates = set
+	suite.T().Cleanup(func() {
+		suite.app.templates = nil
+		suite.app.config.messages.defaultTemplate = ""
+	})
+
+	today := time.Now()
+	inFourDays := time.Date(1990, today.Month(), today.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, 4)
+
+	w := suite.makeRequest(http.MethodPut, "/v1/hello/mia", map[string]string{
+		"dateOfBirth": inFourDays.Format("2006-01-02"),
+	})
+	require.Equal(suite.T(), http.StatusNoContent, w.Code)
+
+	tests := []struct {
+		name            string
+		query           string
+		defaultTemplate string
+		acceptLanguage  string
+		expectStatus    int
+		expectMessage   string
+		expectLanguage  string
+	}{
+		{
+			name:           "built-in message without a template",
+			expectStatus:   http.StatusOK,
+			expectMessage:  "Hello, mia! Your birthday is in 4 days",
+			expectLanguage: "en",
+		},
+		{
+			name:          "template from query",
+			query:         "?template=short",
+			expectStatus:  http.StatusOK,
+			expectMessage: "mia: 4 days to go",
+		},
+		{
+			name:           "locale variant",
+			query:          "?template=short",
+			acceptLanguage: "de",
+			expectStatus:   http.StatusOK,
+			expectMessage:  "mia: noch 4 Tage",
+			expectLanguage: "de",
+		},
+		{
+			// The fallback variant's language is not known, so it is
+			// not claimed to be the negotiated one.
+			name:           "no variant for the locale",
+			query:          "?template=banner",
+			acceptLanguage: "ru",
+			expectStatus:   http.StatusOK,
+			expectMessage:  "🎉 mia 🎉",
+		},
+		{
+			name:            "configured default",
+			defaultTemplate: "banner",
+			expectStatus:    http.StatusOK,
+			expectMessage:   "🎉 mia 🎉",
+		},
+		{
+			name:            "query overrides default",
+			query:           "?template=short",
+			defaultTemplate: "banner",
+			expectStatus:    http.StatusOK,
+			expectMessage:   "mia: 4 days to go",
+		},
+		{
+			name:         "unknown template",
+			query:        "?template=nope",
+			expectStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			suite.app.config.messages.defaultTemplate = tt.defaultTemplate
+
+			r := httptest.NewRequest(http.MethodGet, "/v1/hello/mia"+tt.query, nil)
+			if tt.acceptLanguage != "" {
+				r.Header.Set("Accept-Language", tt.acceptLanguage)
+			}
+
+			w := httptest.NewRecorder()
+			suite.router.ServeHTTP(w, r)
+
+			require.Equal(suite.T(), tt.expectStatus, w.Code)
+
+			var response envelope
+			require.NoError(suite.T(), json.Unmarshal(w.Body.Bytes(), &response))
+
+			if tt.expectStatus == http.StatusOK {
+				assert.Equal(suite.T(), tt.expectMessage, response["message"])
+				assert.Equal(suite.T(), tt.expectLanguage, w.Header().Get("Content-Language"))
+			} else {
+				assert.Equal(suite.T(), `unknown message template "nope"`, response["error"])
+			}
+		})
+	}
+}
+
+func (suite *APITestSuite) TestSaveUser_UnsupportedLocale() {
+	w := suite.makeRequest(http.MethodPut, "/v1/hello/pierre", map[string]string{
+		"dateOfBirth": "1990-01-01",
+		"locale":      "fr",
+	})
+	require.Equal(suite.T(), http.StatusUnprocessableEntity, w.Code)
+
+	var response struct {
+		Errors []fieldError `json:"errors"`
+	}
+	require.NoError(suite.T(), json.Unmarshal(w.Body.Bytes(), &response))
+	require.Len(suite.T(), response.Errors, 1)
+	assert.Equal(suite.T(), "locale", response.Errors[0].Field)
+	assert.Equal(suite.T(), "locale.unsupported", response.Errors[0].Code)
+	assert.Equal(suite.T(), "must be one of en, de, ru", response.Errors[0].Message)
+}
+
+func (suite *APITestSuite) TestSaveUser_EmailAndTimeZone() {
+	w := suite.makeRequest(http.MethodPut, "/v1/hello/john", map[string]string{
+		"dateOfBirth": "1990-01-01",
+		"email":       "john@example.com",
+		"timeZone":    "Europe/Berlin",
+	})
+	require.Equal(suite.T(), http.StatusNoContent, w.Code)
+
+	user, err := suite.app.models.Users.Get("john")
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "john@example.com", user.Email)
+	assert.Equal(suite.T(), "Europe/Berlin", user.TimeZone)
+
+	users, err := suite.app.models.Users.ListByBirthday([]time.Time{time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)})
+	require.NoError(suite.T(), err)
+	require.Len(suite.T(), users, 1)
+	assert.Equal(suite.T(), "john@example.com", users[0].Email)
+	assert.Equal(suite.T(), "Europe/Berlin", users[0].TimeZone)
+
+	users, err = suite.app.models.Users.ListByBirthday([]time.Time{time.Date(2026, time.January, 2, 0, 0, 0, 0, time.UTC)})
+	require.NoError(suite.T(), err)
+	assert.Empty(suite.T(), users)
+
+	// Leaving the fields out clears them, like the locale.
+	w = suite.makeRequest(http.MethodPut, "/v1/hello/john", map[string]string{"dateOfBirth": "1990-01-01"})
+	require.Equal(suite.T(), http.StatusNoContent, w.Code)
+
+	user, err = suite.app.models.Users.Get("john")
+	require.NoError(suite.T(), err)
+	assert.Empty(suite.T(), user.Email)
+	assert.Empty(suite.T(), user.TimeZone)
+
+	w = suite.makeRequest(http.MethodPut, "/v1/hello/john", map[string]string{
+		"dateOfBirth": "1990-01-01",
+		"email":       "john",
+		"timeZone":    "Mars/Olympus",
+	})
+	require.Equal(suite.T(), http.StatusUnprocessableEntity, w.Code)
+
+	var response struct {
+		Errors []fieldError `json:"errors"`
+	}
+	require.NoError(suite.T(), json.Unmarshal(w.Body.Bytes(), &response))
+
+	codes := make(map[string]string)
+	for _, e := range response.Errors {
+		codes[e.Field] = e.Code
+	}
+	assert.Equal(suite.T(), map[string]string{"email": "email.invalid", "timeZone": "timeZone.invalid"}, codes)
+}
+
+func (suite *APITestSuite) TestLegacyAlias() {
+	payload := map[string]string{
+		"dateOfBirth": "1990-01-01",
+	}
+
+	w := suite.makeRequest(http.MethodPut, "/hello/legacy", payload)
+	require.Equal(suite.T(), http.StatusNoContent, w.Code)
+	assert.Equal(suite.T(), "@1792281600", w.Header().Get("Deprecation"))
+	assert.Equal(suite.T(), "Sun, 18 Apr 2027 00:00:00 GMT", w.Header().Get("Sunset"))
+	assert.Equal(suite.T(), `</v1/hello/legacy>; rel="successor-version"`, w.Header().Get("Link"))
+
+	legacy := suite.makeRequest(http.MethodGet, "/hello/legacy", nil)
+	current := suite.makeRequest(http.MethodGet, "/v1/hello/legacy", nil)
+
+	assert.Equal(suite.T(), http.StatusOK, legacy.Code)
+	assert.Equal(suite.T(), current.Body.String(), legacy.Body.String())
+	assert.NotEmpty(suite.T(), legacy.Header().Get("Deprecation"))
+	assert.Empty(suite.T(), current.Header().Get("Deprecation"))
+	assert.Empty(suite.T(), current.Header().Get("Sunset"))
+}
+
+func (suite *APITestSuite) TestSaveUser_Authentication() {
+	readOnly, err := data.GenerateAPIKey("reader", []string{data.ScopeRead})
+	require.NoError(suite.T(), err)
+	require.NoError(suite.T(), suite.app.models.APIKeys.Insert(readOnly))
+
+	revoked, err := data.GenerateAPIKey("revoked", []string{data.ScopeWrite})
+	require.NoError(suite.T(), err)
+	require.NoError(suite.T(), suite.app.models.APIKeys.Insert(revoked))
+	require.NoError(suite.T(), suite.app.models.APIKeys.Revoke(revoked.ID))
+
+	tests := []struct {
+		name                string
+		authorization       string
+		expectCode          int
+		expectAuthenticate  string
+		expectErrorContains string
+	}{
+		{
+			name:                "no api key",
+			expectCode:          http.StatusUnauthorized,
+			expectAuthenticate:  "Bearer",
+			expectErrorContains: "must be authenticated",
+		},
+		{
+			name:                "not a bearer token",
+			authorization:       "Basic am9objpzZWNyZXQ=",
+			expectCode:          http.StatusUnauthorized,
+			expectAuthenticate:  `Bearer error="invalid_token"`,
+			expectErrorContains: "invalid, expired or revoked",
+		},
+		{
+			name:                "unknown api key",
+			authorization:       "Bearer hk_doesnotexist",
+			expectCode:          http.StatusUnauthorized,
+			expectAuthenticate:  `Bearer error="invalid_token"`,
+			expectErrorContains: "invalid, expired or revoked",
+		},
+		{
+			name:                "revoked api key",
+			authorization:       "Bearer " + revoked.Plaintext,
+			expectCode:          http.StatusUnauthorized,
+			expectAuthenticate:  `Bearer error="invalid_token"`,
+			expectErrorContains: "invalid, expired or revoked",
+		},
+		{
+			name:                "read-only api key",
+			authorization:       "Bearer " + readOnly.Plaintext,
+			expectCode:          http.StatusForbidden,
+			expectErrorContains: "do not have the write scope",
+		},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			r := httptest.NewRequest(http.MethodPut, "/v1/hello/mallory", strings.NewReader(`{"dateOfBirth": "1990-01-01"}`))
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+
+			w := httptest.NewRecorder()
+			suite.router.ServeHTTP(w, r)
+
+			require.Equal(suite.T(), tt.expectCode, w.Code)
+			assert.Equal(suite.T(), tt.expectAuthenticate, w.Header().Get("WWW-Authenticate"))
+
+			var response envelope
+			require.NoError(suite.T(), json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Contains(suite.T(), response["error"], tt.expectErrorContains)
+		})
+	}
+
+	_, err = suite.app.models.Users.Get("mallory")
+	assert.ErrorIs(suite.T(), err, data.ErrRecordNotFound, "no request should have saved the user")
+}
+
+func (suite *APITestSuite) signJWT(claims tokens.Claims) string {
+	if claims.ExpiresAt == nil {
+		claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Hour))
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(suite.jwtSecret)
+	require.NoError(suite.T(), err)
+
+	return token
+}
+
+func (suite *APITestSuite) TestSaveUser_JWTOwnership() {
+	user := func(sub string) tokens.Claims {
+		return tokens.Claims{RegisteredClaims: jwt.RegisteredClaims{Subject: sub}}
+	}
+
+	admin := user("root")
+	admin.Admin = true
+
+	expired := user("john")
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+
+	tests := []struct {
+		name       string
+		token      string
+		username   string
+		expectCode int
+	}{
+		{name: "own record", token: suite.signJWT(user("john")), username: "john", expectCode: http.StatusNoContent},
+		{name: "someone else's record", token: suite.signJWT(user("john")), username: "alice", expectCode: http.StatusForbidden},
+		{name: "subject differs in case", token: suite.signJWT(user("john")), username: "John", expectCode: http.StatusForbidden},
+		{name: "admin claim", token: suite.signJWT(admin), username: "alice", expectCode: http.StatusNoContent},
+		{name: "expired token", token: suite.signJWT(expired), username: "john", expectCode: http.StatusUnauthorized},
+		{name: "tampered token", token: suite.signJWT(user("john")) + "x", username: "john", expectCode: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			r := httptest.NewRequest(http.MethodPut, "/v1/hello/"+tt.username, strings.NewReader(`{"dateOfBirth": "1990-01-01"}`))
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+
+			w := httptest.NewRecorder()
+			suite.router.ServeHTTP(w, r)
+
+			require.Equal(suite.T(), tt.expectCode, w.Code, w.Body.String())
+
+			if tt.expectCode == http.StatusForbidden {
+				var response envelope
+				require.NoError(suite.T(), json.Unmarshal(w.Body.Bytes(), &response))
+				assert.Equal(suite.T(), "you can only change your own record", response["error"])
+			}
+		})
+	}
+
+	// Without the admin claim a JWT cannot manage API keys.
+	r := httptest.NewRequest(http.MethodGet, "/v1/api-keys", nil)
+	r.Header.Set("Authorization", "Bearer "+suite.signJWT(user("john")))
+
+	w := httptest.NewRecorder()
+	suite.router.ServeHTTP(w, r)
+	assert.Equal(suite.T(), http.StatusForbidden, w.Code)
+}
+
+func (suite *APITestSuite) TestAPIKeys_CreateAndRevoke() {
+	admin, err := data.GenerateAPIKey("admin", []string{data.ScopeAdmin})
+	require.NoError(suite.T(), err)
+	require.NoError(suite.T(), suite.app.models.APIKeys.Insert(admin))
+
+	adminRequest := func(method, path, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+admin.Plaintext)
+
+		w := httptest.NewRecorder()
+		suite.router.ServeHTTP(w, r)
+		return w
+	}
+
+	w := adminRequest(http.MethodPost, "/v1/api-keys", `{"name": "billing", "scopes": ["write"]}`)
+	require.Equal(suite.T(), http.StatusCreated, w.Code, w.Body.String())
+
+	var created struct {
+		APIKey struct {
+			ID     int64    `json:"id"`
+			Name   string   `json:"name"`
+			Scopes []string `json:"scopes"`
+			Key    string   `json:"key"`
+		} `json:"apiKey"`
+	}
+	require.NoError(suite.T(), json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Equal(suite.T(), "billing", created.APIKey.Name)
+	assert.Equal(suite.T(), []string{"write"}, created.APIKey.Scopes)
+	assert.True(suite.T(), strings.HasPrefix(created.APIKey.Key, data.APIKeyPrefix))
+	assert.Equal(suite.T(), fmt.Sprintf("/v1/api-keys/%d", created.APIKey.ID), w.Header().Get("Location"))
+
+	save := func() int {
+		r := httptest.NewRequest(http.MethodPut, "/v1/hello/billy", strings.NewReader(`{"dateOfBirth": "1990-01-01"}`))
+		r.Header.Set("Authorization", "Bearer "+created.APIKey.Key)
+
+		w := httptest.NewRecorder()
+		suite.router.ServeHTTP(w, r)
+		return w.Code
+	}
+	assert.Equal(suite.T(), http.StatusNoContent, save())
+
+	w = adminRequest(http.MethodGet, "/v1/api-keys", "")
+	require.Equal(suite.T(), http.StatusOK, w.Code)
+	assert.Contains(suite.T(), w.Body.String(), `"name":"billing"`)
+	assert.NotContains(suite.T(), w.Body.String(), created.APIKey.Key)
+
+	w = adminRequest(http.MethodDelete, fmt.Sprintf("/v1/api-keys/%d", created.APIKey.ID), "")
+	require.Equal(suite.T(), http.StatusNoContent, w.Code)
+	assert.Equal(suite.T(), http.StatusUnauthorized, save())
+
+	w = adminRequest(http.MethodDelete, fmt.Sprintf("/v1/api-keys/%d", created.APIKey.ID), "")
+	assert.Equal(suite.T(), http.StatusNotFound, w.Code)
+
+	w = adminRequest(http.MethodDelete, "/v1/api-keys/abc", "")
+	assert.Equal(suite.T(), http.StatusNotFound, w.Code)
+
+	// The suite's key can save users but not manage keys.
+	w = suite.makeRequest(http.MethodGet, "/v1/api-keys", nil)
+	assert.Equal(suite.T(), http.StatusForbidden, w.Code)
 }
 
 func (suite *APITestSuite) TestMethodNotAllowed() {
@@ -345,11 +877,11 @@ func (suite *APITestSuite) TestMethodNotAllowed() {
 		"dateOfBirth": "1990-01-01",
 	}
 
-	w := suite.makeRequest(http.MethodPut, "/hello/testuser", payload)
+	w := suite.makeRequest(http.MethodPut, "/v1/hello/testuser", payload)
 	require.Equal(suite.T(), http.StatusNoContent, w.Code)
 
 	// Test unsupported method
-	w = suite.makeRequest(http.MethodPost, "/hello/testuser", payload)
+	w = suite.makeRequest(http.MethodPost, "/v1/hello/testuser", payload)
 	assert.Equal(suite.T(), http.StatusMethodNotAllowed, w.Code)
 
 	var response envelope
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ab0utbla-k/rvt-hello-app/internal/backoff"
+	"github.com/ab0utbla-k/rvt-hello-app/internal/data"
+	"github.com/ab0utbla-k/rvt-hello-app/internal/validator"
+)
+
+const (
+	// webhookPollInterval is how often the queue is checked for
+	// deliveries that are due.
+	webhookPollInterval = 5 * time.Second
+	webhookBatchSize    = 20
+	// webhookLease is how long a claimed delivery is held before another
+	// instance may try it, and must be longer than webhookClient.Timeout.
+	webhookLease = time.Minute
+	// webhookDeliveriesLimit is how many deliveries GET
+	// /v1/webhooks/:id/deliveries returns.
+	webhookDeliveriesLimit = 50
+	// webhookShutdownGrace is how long deliveries in flight at shutdown
+	// have to finish before their requests are cancelled.
+	webhookShutdownGrace = 2 * time.Second
+)
+
+// webhookClient posts deliveries. Redirects are not followed, so a
+// webhook's URL is the only place its payloads go.
+var webhookClient = &http.Client{
+	Timeout: 10 * time.Second,
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// createWebhookHandler generates a secret when the request has none. The
+// secret is only ever returned here.
+func (app *application) createWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+		Secret string   `json:"secret"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	webhook := &data.Webhook{
+		URL:    input.URL,
+		Events: input.Events,
+		Secret: input.Secret,
+	}
+
+	if webhook.Secret == "" {
+		webhook.Secret, err = data.GenerateWebhookSecret()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	v := validator.New()
+	data.ValidateWebhook(v, webhook)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Webhooks.Insert(webhook)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/webhooks/%d", webhook.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"webhook": webhook}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := app.models.Webhooks.List()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"webhooks": webhooks}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(r, "id")
+	if !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err := app.models.Webhooks.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *application) listWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(r, "id")
+	if !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	deliveries, err := app.models.Webhooks.ListDeliveries(id, webhookDeliveriesLimit)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"deliveries": deliveries}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// redeliverWebhookHandler queues the delivery again, with the same event ID
+// and payload, for the dispatcher to pick up.
+func (app *application) redeliverWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(r, "id")
+	if !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	deliveryID, ok := idParam(r, "delivery")
+	if !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err := app.models.Webhooks.Redeliver(id, deliveryID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// deliverWebhooks delivers the webhook deliveries that are due every
+// interval until ctx is done. Runs before the database is ready are
+// skipped.
+func (app *application) deliverWebhooks(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !app.ready.Load() {
+				continue
+			}
+
+			app.deliverDueWebhooks(ctx)
+		}
+	}
+}
+
+// deliverDueWebhooks claims batches of due deliveries until there are none
+// left or ctx is done, and delivers each batch concurrently. Deliveries in
+// flight when ctx is done get webhookShutdownGrace to finish before they
+// are cancelled, and count as failed attempts if they do not.
+func (app *application) deliverDueWebhooks(ctx context.Context) {
+	sendCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stop := context.AfterFunc(ctx, func() {
+		time.AfterFunc(webhookShutdownGrace, cancel)
+	})
+	defer stop()
+
+	for ctx.Err() == nil {
+		deliveries, err := app.models.Webhooks.ClaimDue(webhookBatchSize, webhookLease)
+		if err != nil {
+			app.logger.Error("claiming webhook deliveries", "error", err.Error())
+			return
+		}
+
+		if len(deliveries) == 0 {
+			return
+		}
+
+		var wg sync.WaitGroup
+		for _, d := range deliveries {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				app.deliverWebhook(sendCtx, d)
+			}()
+		}
+		wg.Wait()
+	}
+}
+
+// deliverWebhook makes one attempt at d and records it. A failed attempt
+// is retried with exponential backoff until the delivery runs out of
+// attempts.
+func (app *application) deliverWebhook(ctx context.Context, d *data.WebhookDelivery) {
+	logger := app.logger.With("webhook_id", d.WebhookID, "delivery_id", d.ID, "event", d.Event)
+
+	start := time.Now()
+	status, err := postWebhook(ctx, d, start)
+
+	attempt := &data.WebhookAttempt{
+		AttemptedAt: start,
+		StatusCode:  status,
+		DurationMS:  time.Since(start).Milliseconds(),
+	}
+
+	d.Tries++
+
+	switch {
+	case err == nil:
+		d.Status = data.DeliveryDelivered
+		deliveredAt := time.Now()
+		d.DeliveredAt = &deliveredAt
+	case d.Tries >= app.config.webhooks.maxAttempts:
+		attempt.Error = err.Error()
+		d.Status = data.DeliveryFailed
+		logger.Warn("webhook delivery failed", "tries", d.Tries, "error", err.Error())
+	default:
+		attempt.Error = err.Error()
+		b := backoff.Backoff{Initial: app.config.webhooks.backoff, Max: app.config.webhooks.maxBackoff}
+		next := time.Now().Add(b.Delay(d.Tries))
+		d.NextAttemptAt = &next
+	}
+
+	err = app.models.Webhooks.RecordAttempt(d, attempt)
+	if err != nil {
+		logger.Error("recording webhook attempt", "error", err.Error())
+	}
+}
+
+// postWebhook sends d's payload, and returns the status code of the
+// response, if any. Only a 2xx response counts as delivered.
+func postWebhook(ctx context.Context, d *data.WebhookDelivery, now time.Time) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := now.Unix()
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "rvt-hello-app/"+version)
+	req.Header.Set("Webhook-Id", d.EventID)
+	req.Header.Set("Webhook-Event", d.Event)
+	req.Header.Set("Webhook-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("Webhook-Signature", "sha256="+signWebhook(d.Secret, d.EventID, timestamp, d.Payload))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// Reading some of the body lets the connection be reused.
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// signWebhook returns the hex HMAC-SHA256, keyed with secret, of the event
+// ID, timestamp and body joined with dots. Signing the timestamp lets
+// receivers reject old payloads that are replayed to them.
+func signWebhook(secret, eventID string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s.%d.", eventID, timestamp)
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
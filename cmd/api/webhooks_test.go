@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ab0utbla-k/rvt-hello-app/internal/data"
+	"github.com/ab0utbla-k/rvt-hello-app/internal/outbox"
+	"github.com/ab0utbla-k/rvt-hello-app/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testWebhookSecret = "whsec_0123456789abcdef"
+
+// webhookReceiver is a webhook endpoint that answers with status, 200 by
+// default, and keeps what it was sent.
+type webhookReceiver struct {
+	*httptest.Server
+	status atomic.Int64
+
+	mu       sync.Mutex
+	requests []receivedWebhook
+}
+
+type receivedWebhook struct {
+	header http.Header
+	body   []byte
+}
+
+func newWebhookReceiver(t *testing.T) *webhookReceiver {
+	t.Helper()
+
+	rcv := &webhookReceiver{}
+	rcv.status.Store(http.StatusOK)
+	rcv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		rcv.mu.Lock()
+		rcv.requests = append(rcv.requests, receivedWebhook{header: r.Header.Clone(), body: body})
+		rcv.mu.Unlock()
+
+		w.WriteHeader(int(rcv.status.Load()))
+	}))
+	t.Cleanup(rcv.Close)
+
+	return rcv
+}
+
+func (rcv *webhookReceiver) received() []receivedWebhook {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+
+	return append([]receivedWebhook(nil), rcv.requests...)
+}
+
+func newWebhookTestApp(t *testing.T) *application {
+	t.Helper()
+
+	app := &application{
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		models: data.NewSQLiteModels(testutils.SetupSQLiteTestDB(t)),
+	}
+	app.config.webhooks.maxAttempts = 3
+	app.config.webhooks.backoff = time.Hour
+	app.config.webhooks.maxBackoff = 24 * time.Hour
+	app.sinks = []outbox.Sink{outbox.WebhookSink{Webhooks: app.models.Webhooks}}
+
+	return app
+}
+
+func subscribe(t *testing.T, app *application, url string, events ...string) *data.Webhook {
+	t.Helper()
+
+	webhook := &data.Webhook{URL: url, Events: events, Secret: testWebhookSecret}
+	require.NoError(t, app.models.Webhooks.Insert(webhook))
+
+	return webhook
+}
+
+// saveUser saves user and queues the event it records for the webhooks.
+func saveUser(t *testing.T, app *application, user *data.User) {
+	t.Helper()
+
+	_, err := app.models.Users.Insert(user)
+	require.NoError(t, err)
+
+	app.flushOutbox(context.Background())
+}
+
+func deliveries(t *testing.T, app *application, webhookID int64) []*data.WebhookDelivery {
+	t.Helper()
+
+	list, err := app.models.Webhooks.ListDeliveries(webhookID, webhookDeliveriesLimit)
+	require.NoError(t, err)
+
+	return list
+}
+
+func TestDeliverWebhooks(t *testing.T) {
+	t.Parallel()
+
+	rcv := newWebhookReceiver(t)
+	app := newWebhookTestApp(t)
+
+	created := subscribe(t, app, rcv.URL, data.EventUserCreated)
+	other := subscribe(t, app, rcv.URL, data.EventUserUpdated)
+
+	user := &data.User{Username: "john", DateOfBirth: time.Date(1990, time.May, 10, 0, 0, 0, 0, time.UTC), Email: "john@example.com", Locale: "de"}
+	saveUser(t, app, user)
+
+	app.deliverDueWebhooks(context.Background())
+
+	received := rcv.received()
+	require.Len(t, received, 1)
+	header, body := received[0].header, received[0].body
+
+	assert.Equal(t, "application/json", header.Get("Content-Type"))
+	assert.Equal(t, data.EventUserCreated, header.Get("Webhook-Event"))
+
+	timestamp, err := strconv.ParseInt(header.Get("Webhook-Timestamp"), 10, 64)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), time.Unix(timestamp, 0), time.Minute)
+
+	mac := hmac.New(sha256.New, []byte(testWebhookSecret))
+	mac.Write([]byte(header.Get("Webhook-Id") + "." + header.Get("Webhook-Timestamp") + "."))
+	mac.Write(body)
+	assert.Equal(t, "sha256="+hex.EncodeToString(mac.Sum(nil)), header.Get("Webhook-Signature"))
+
+	var payload map[string]any
+	require.NoError(t, json.Unmarshal(body, &payload))
+	assert.Equal(t, header.Get("Webhook-Id"), payload["id"])
+	assert.Equal(t, data.EventUserCreated, payload["type"])
+	assert.Equal(t, map[string]any{"username": "john", "dateOfBirth": "1990-05-10", "locale": "de"}, payload["data"])
+
+	list := deliveries(t, app, created.ID)
+	require.Len(t, list, 1)
+	assert.Equal(t, data.DeliveryDelivered, list[0].Status)
+	assert.NotNil(t, list[0].DeliveredAt)
+	assert.Nil(t, list[0].NextAttemptAt)
+	require.Len(t, list[0].Attempts, 1)
+	assert.Equal(t, http.StatusOK, list[0].Attempts[0].StatusCode)
+	assert.Empty(t, list[0].Attempts[0].Error)
+
+	assert.Empty(t, deliveries(t, app, other.ID), "webhooks only get the events they subscribed to")
+}
+
+func TestDeliverWebhooks_Retries(t *testing.T) {
+	t.Parallel()
+
+	rcv := newWebhookReceiver(t)
+	rcv.status.Store(http.StatusServiceUnavailable)
+
+	app := newWebhookTestApp(t)
+	webhook := subscribe(t, app, rcv.URL, data.EventUserCreated)
+
+	saveUser(t, app, &data.User{Username: "john", DateOfBirth: time.Now().AddDate(-30, 0, 0)})
+
+	app.deliverDueWebhooks(context.Background())
+
+	list := deliveries(t, app, webhook.ID)
+	require.Len(t, list, 1)
+	assert.Equal(t, data.DeliveryPending, list[0].Status)
+	require.Len(t, list[0].Attempts, 1)
+	assert.Equal(t, http.StatusServiceUnavailable, list[0].Attempts[0].StatusCode)
+	assert.Equal(t, "unexpected status 503", list[0].Attempts[0].Error)
+
+	// The first retry waits between half and all of WEBHOOK_BACKOFF.
+	require.NotNil(t, list[0].NextAttemptAt)
+	assert.WithinRange(t, *list[0].NextAttemptAt, time.Now().Add(29*time.Minute), time.Now().Add(time.Hour))
+
+	// Nothing is due until then.
+	app.deliverDueWebhooks(context.Background())
+	assert.Len(t, rcv.received(), 1)
+
+	// Without backoff every attempt is due at once, so the delivery runs
+	// out of them.
+	app.config.webhooks.backoff = 0
+	require.NoError(t, app.models.Webhooks.Redeliver(webhook.ID, list[0].ID))
+	app.deliverDueWebhooks(context.Background())
+
+	list = deliveries(t, app, webhook.ID)
+	assert.Equal(t, data.DeliveryFailed, list[0].Status)
+	assert.Len(t, list[0].Attempts, 4)
+	assert.Len(t, rcv.received(), 4)
+
+	// The same event ID is sent with every attempt, so receivers can tell
+	// retries apart from new events.
+	for _, r := range rcv.received() {
+		assert.Equal(t, list[0].EventID, r.header.Get("Webhook-Id"))
+	}
+
+	rcv.status.Store(http.StatusNoContent)
+	require.NoError(t, app.models.Webhooks.Redeliver(webhook.ID, list[0].ID))
+	app.deliverDueWebhooks(context.Background())
+
+	list = deliveries(t, app, webhook.ID)
+	assert.Equal(t, data.DeliveryDelivered, list[0].Status)
+	assert.Len(t, list[0].Attempts, 5)
+}
+
+func TestDeliverWebhooks_Unreachable(t *testing.T) {
+	t.Parallel()
+
+	rcv := newWebhookReceiver(t)
+	rcv.Close()
+
+	app := newWebhookTestApp(t)
+	webhook := subscribe(t, app, rcv.URL, data.EventUserCreated)
+
+	saveUser(t, app, &data.User{Username: "john", DateOfBirth: time.Now().AddDate(-30, 0, 0)})
+
+	app.deliverDueWebhooks(context.Background())
+
+	list := deliveries(t, app, webhook.ID)
+	require.Len(t, list[0].Attempts, 1)
+	assert.Zero(t, list[0].Attempts[0].StatusCode)
+	assert.NotEmpty(t, list[0].Attempts[0].Error)
+}
+
+func TestDeliverWebhooks_Shutdown(t *testing.T) {
+	t.Parallel()
+
+	// The receiver never answers, so only cancelling the request ends it.
+	received := make(chan struct{}, 1)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+		<-release
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(release) })
+
+	app := newWebhookTestApp(t)
+	webhook := subscribe(t, app, srv.URL, data.EventUserCreated)
+	saveUser(t, app, &data.User{Username: "john", DateOfBirth: time.Now().AddDate(-30, 0, 0)})
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		app.deliverDueWebhooks(ctx)
+	}()
+
+	<-received
+	start := time.Now()
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(webhookClient.Timeout):
+		t.Fatal("deliverDueWebhooks waited out the client timeout after shutdown")
+	}
+	assert.GreaterOrEqual(t, time.Since(start), webhookShutdownGrace, "deliveries in flight get a grace period")
+
+	list := deliveries(t, app, webhook.ID)
+	require.Len(t, list, 1)
+	assert.Equal(t, data.DeliveryPending, list[0].Status, "a cancelled delivery is retried")
+	require.Len(t, list[0].Attempts, 1)
+	assert.Contains(t, list[0].Attempts[0].Error, "context canceled")
+}
+
+func TestDeleteWebhook_RemovesDeliveries(t *testing.T) {
+	t.Parallel()
+
+	rcv := newWebhookReceiver(t)
+
+	db := testutils.SetupSQLiteTestDB(t)
+	app := newWebhookTestApp(t)
+	app.models = data.NewSQLiteModels(db)
+	app.sinks = []outbox.Sink{outbox.WebhookSink{Webhooks: app.models.Webhooks}}
+
+	webhook := subscribe(t, app, rcv.URL, data.EventUserCreated)
+	saveUser(t, app, &data.User{Username: "john", DateOfBirth: time.Now().AddDate(-30, 0, 0)})
+	app.deliverDueWebhooks(context.Background())
+
+	require.NoError(t, app.models.Webhooks.Delete(webhook.ID))
+
+	// The deliveries and their attempts go with the webhook through the
+	// foreign keys.
+	for _, table := range []string{"webhook_deliveries", "webhook_attempts"} {
+		var count int
+		require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM "+table).Scan(&count))
+		assert.Zero(t, count, table)
+	}
+}
+
+func TestNotifyBirthdays_PublishesBirthdayEvents(t *testing.T) {
+	t.Parallel()
+
+	app := newWebhookTestApp(t)
+	webhook := subscribe(t, app, "https://hooks.example.com", data.EventUserBirthday)
+
+	for _, user := range []*data.User{
+		// Just after midnight on May 10th in Tokyo, still May 9th in UTC.
+		{Username: "john", DateOfBirth: time.Date(1990, time.May, 10, 0, 0, 0, 0, time.UTC), TimeZone: "Asia/Tokyo"},
+		{Username: "alice", DateOfBirth: time.Date(1990, time.May, 10, 0, 0, 0, 0, time.UTC), TimeZone: "Europe/Berlin"},
+	} {
+		_, err := app.models.Users.Insert(user)
+		require.NoError(t, err)
+	}
+
+	now := time.Date(2026, time.May, 9, 15, 30, 0, 0, time.UTC)
+
+	app.notifyBirthdays(context.Background(), now)
+	app.notifyBirthdays(context.Background(), now.Add(time.Hour))
+	app.flushOutbox(context.Background())
+
+	list := deliveries(t, app, webhook.ID)
+	require.Len(t, list, 1)
+	assert.Equal(t, "birthday:john:2026-05-10", list[0].EventID)
+
+	var payload data.EventPayload
+	require.NoError(t, json.Unmarshal(list[0].Payload, &payload))
+	assert.Equal(t, "2026-05-10", payload.Data.Birthday)
+	assert.Equal(t, 36, payload.Data.AgeTurning)
+
+	// Midnight reaches Berlin nine hours after the first run.
+	app.notifyBirthdays(context.Background(), now.Add(9*time.Hour))
+	app.flushOutbox(context.Background())
+	assert.Len(t, deliveries(t, app, webhook.ID), 2)
+}
+
+func TestSaveUser_PublishesUserEvents(t *testing.T) {
+	t.Parallel()
+
+	app := newWebhookTestApp(t)
+	app.ready.Store(true)
+	webhook := subscribe(t, app, "https://hooks.example.com", data.EventUserCreated, data.EventUserUpdated)
+
+	key, err := data.GenerateAPIKey("writer", []string{data.ScopeWrite})
+	require.NoError(t, err)
+	require.NoError(t, app.models.APIKeys.Insert(key))
+
+	router := app.newRouter(app.apiRoutes())
+
+	for range 2 {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPut, "/v1/hello/john", strings.NewReader(`{"dateOfBirth": "1990-01-01"}`))
+		r.Header.Set("Authorization", "Bearer "+key.Plaintext)
+		router.ServeHTTP(w, r)
+		require.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+	}
+
+	app.flushOutbox(context.Background())
+
+	list := deliveries(t, app, webhook.ID)
+	require.Len(t, list, 2)
+	assert.Equal(t, data.EventUserUpdated, list[0].Event)
+	assert.Equal(t, data.EventUserCreated, list[1].Event)
+}
@@ -0,0 +1,31 @@
+package backoff
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// Backoff computes exponentially growing retry delays with jitter.
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+}
+
+// Delay returns how long to wait before retry number attempt (starting at
+// 1). The base delay doubles with every attempt up to Max, and the result
+// is drawn uniformly from [base/2, base] so that clients started together
+// do not retry in lockstep.
+func (b Backoff) Delay(attempt int) time.Duration {
+	base := b.Initial
+	for i := 1; i < attempt && base < b.Max; i++ {
+		base *= 2
+	}
+	base = min(base, b.Max)
+
+	if base <= 0 {
+		return 0
+	}
+
+	half := base / 2
+	return half + rand.N(base-half+1)
+}
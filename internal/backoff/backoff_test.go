@@ -0,0 +1,40 @@
+package backoff
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff_Delay(t *testing.T) {
+	t.Parallel()
+
+	b := Backoff{Initial: 100 * time.Millisecond, Max: time.Second}
+
+	tests := []struct {
+		attempt int
+		base    time.Duration
+	}{
+		{attempt: 1, base: 100 * time.Millisecond},
+		{attempt: 2, base: 200 * time.Millisecond},
+		{attempt: 3, base: 400 * time.Millisecond},
+		{attempt: 4, base: 800 * time.Millisecond},
+		{attempt: 5, base: time.Second},
+		{attempt: 50, base: time.Second},
+	}
+
+	for _, tt := range tests {
+		for range 100 {
+			delay := b.Delay(tt.attempt)
+			assert.GreaterOrEqual(t, delay, tt.base/2, "attempt %d", tt.attempt)
+			assert.LessOrEqual(t, delay, tt.base, "attempt %d", tt.attempt)
+		}
+	}
+}
+
+func TestBackoff_Delay_Zero(t *testing.T) {
+	t.Parallel()
+
+	assert.Zero(t, Backoff{}.Delay(3))
+}
@@ -0,0 +1,16 @@
+package cache
+
+import (
+	"errors"
+)
+
+var ErrCacheMiss = errors.New("cache miss")
+
+// Cache stores opaque values by key. Entries expire after the TTL the
+// backend was created with. Get returns ErrCacheMiss for absent or expired
+// keys.
+type Cache interface {
+	Get(key string) ([]byte, error)
+	Set(key string, value []byte) error
+	Delete(key string) error
+}
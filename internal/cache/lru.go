@@ -0,0 +1,109 @@
+package cache
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type lruEntry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time
+}
+
+// LRU is an in-process cache that evicts the least recently used entry once
+// it holds more than size entries.
+type LRU struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries *list.List
+	index   map[string]*list.Element
+	now     func() time.Time
+}
+
+// NewLRU returns an LRU that holds at most size entries, each for ttl.
+func NewLRU(size int, ttl time.Duration) (*LRU, error) {
+	if size < 1 {
+		return nil, fmt.Errorf("cache size must be at least 1, got %d", size)
+	}
+	if ttl <= 0 {
+		return nil, fmt.Errorf("cache TTL must be positive, got %s", ttl)
+	}
+
+	return &LRU{
+		size:    size,
+		ttl:     ttl,
+		entries: list.New(),
+		index:   make(map[string]*list.Element),
+		now:     time.Now,
+	}, nil
+}
+
+func (c *LRU) Get(key string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.index[key]
+	if !ok {
+		return nil, ErrCacheMiss
+	}
+
+	entry := elem.Value.(*lruEntry)
+	if !c.now().Before(entry.expiresAt) {
+		c.remove(elem)
+		return nil, ErrCacheMiss
+	}
+
+	c.entries.MoveToFront(elem)
+
+	return entry.value, nil
+}
+
+func (c *LRU) Set(key string, value []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expiresAt := c.now().Add(c.ttl)
+
+	if elem, ok := c.index[key]; ok {
+		entry := elem.Value.(*lruEntry)
+		entry.value = value
+		entry.expiresAt = expiresAt
+		c.entries.MoveToFront(elem)
+		return nil
+	}
+
+	c.index[key] = c.entries.PushFront(&lruEntry{key: key, value: value, expiresAt: expiresAt})
+
+	for c.entries.Len() > c.size {
+		c.remove(c.entries.Back())
+	}
+
+	return nil
+}
+
+func (c *LRU) Delete(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.index[key]; ok {
+		c.remove(elem)
+	}
+
+	return nil
+}
+
+func (c *LRU) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.entries.Len()
+}
+
+func (c *LRU) remove(elem *list.Element) {
+	c.entries.Remove(elem)
+	delete(c.index, elem.Value.(*lruEntry).key)
+}
@@ -0,0 +1,110 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLRU(t *testing.T, size int) *LRU {
+	t.Helper()
+
+	c, err := NewLRU(size, time.Minute)
+	require.NoError(t, err)
+
+	return c
+}
+
+func TestNewLRU_Invalid(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewLRU(0, time.Minute)
+	assert.Error(t, err, "a cache that holds nothing would evict every entry on Set")
+
+	_, err = NewLRU(-1, time.Minute)
+	assert.Error(t, err)
+
+	_, err = NewLRU(10, 0)
+	assert.Error(t, err)
+}
+
+func TestLRU_GetSet(t *testing.T) {
+	t.Parallel()
+
+	c := newTestLRU(t, 10)
+
+	_, err := c.Get("missing")
+	assert.ErrorIs(t, err, ErrCacheMiss)
+
+	require.NoError(t, c.Set("john", []byte("1990-01-01")))
+
+	value, err := c.Get("john")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("1990-01-01"), value)
+
+	require.NoError(t, c.Set("john", []byte("1995-06-15")))
+
+	value, err = c.Get("john")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("1995-06-15"), value)
+	assert.Equal(t, 1, c.Len())
+}
+
+func TestLRU_Expiry(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	c := newTestLRU(t, 10)
+	c.now = func() time.Time { return now }
+
+	require.NoError(t, c.Set("john", []byte("value")))
+
+	now = now.Add(59 * time.Second)
+	_, err := c.Get("john")
+	require.NoError(t, err)
+
+	now = now.Add(time.Second)
+	_, err = c.Get("john")
+	assert.ErrorIs(t, err, ErrCacheMiss)
+	assert.Equal(t, 0, c.Len(), "expired entries should be removed on access")
+}
+
+func TestLRU_EvictsLeastRecentlyUsed(t *testing.T) {
+	t.Parallel()
+
+	c := newTestLRU(t, 2)
+
+	require.NoError(t, c.Set("a", []byte("a")))
+	require.NoError(t, c.Set("b", []byte("b")))
+
+	// Touch "a" so that "b" becomes the least recently used entry.
+	_, err := c.Get("a")
+	require.NoError(t, err)
+
+	require.NoError(t, c.Set("c", []byte("c")))
+
+	_, err = c.Get("b")
+	assert.ErrorIs(t, err, ErrCacheMiss)
+
+	_, err = c.Get("a")
+	assert.NoError(t, err)
+	_, err = c.Get("c")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, c.Len())
+}
+
+func TestLRU_Delete(t *testing.T) {
+	t.Parallel()
+
+	c := newTestLRU(t, 10)
+
+	require.NoError(t, c.Set("john", []byte("value")))
+	require.NoError(t, c.Delete("john"))
+	require.NoError(t, c.Delete("unknown"))
+
+	_, err := c.Get("john")
+	assert.ErrorIs(t, err, ErrCacheMiss)
+}
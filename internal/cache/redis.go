@@ -0,0 +1,72 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis stores entries in any server speaking the Redis protocol.
+type Redis struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+// NewRedis connects to the server at url (redis://[user:password@]host:port/db)
+// and verifies the connection with a PING.
+func NewRedis(url string, ttl time.Duration) (*Redis, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+
+	client := redis.NewClient(opts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = client.Ping(ctx).Err()
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return &Redis{client: client, ttl: ttl}, nil
+}
+
+func (c *Redis) Get(key string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	value, err := c.client.Get(ctx, key).Bytes()
+	if err != nil {
+		switch {
+		case errors.Is(err, redis.Nil):
+			return nil, ErrCacheMiss
+		default:
+			return nil, err
+		}
+	}
+
+	return value, nil
+}
+
+func (c *Redis) Set(key string, value []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	return c.client.Set(ctx, key, value, c.ttl).Err()
+}
+
+func (c *Redis) Delete(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	return c.client.Del(ctx, key).Err()
+}
+
+func (c *Redis) Close() error {
+	return c.client.Close()
+}
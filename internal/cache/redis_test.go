@@ -0,0 +1,52 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedis(t *testing.T) {
+	t.Parallel()
+
+	srv := miniredis.RunT(t)
+
+	c, err := NewRedis("redis://"+srv.Addr()+"/0", time.Minute)
+	require.NoError(t, err)
+	t.Cleanup(func() { c.Close() })
+
+	_, err = c.Get("john")
+	assert.ErrorIs(t, err, ErrCacheMiss)
+
+	require.NoError(t, c.Set("john", []byte("value")))
+
+	value, err := c.Get("john")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("value"), value)
+	assert.Equal(t, time.Minute, srv.TTL("john"))
+
+	srv.FastForward(time.Minute)
+
+	_, err = c.Get("john")
+	assert.ErrorIs(t, err, ErrCacheMiss)
+
+	require.NoError(t, c.Set("john", []byte("value")))
+	require.NoError(t, c.Delete("john"))
+
+	_, err = c.Get("john")
+	assert.ErrorIs(t, err, ErrCacheMiss)
+}
+
+func TestNewRedis_Unreachable(t *testing.T) {
+	t.Parallel()
+
+	srv := miniredis.RunT(t)
+	addr := srv.Addr()
+	srv.Close()
+
+	_, err := NewRedis("redis://"+addr+"/0", time.Minute)
+	assert.Error(t, err)
+}
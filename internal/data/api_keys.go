@@ -0,0 +1,200 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ab0utbla-k/rvt-hello-app/internal/validator"
+	"github.com/lib/pq"
+)
+
+// Scopes an API key can be granted. Admin implies the other two.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+	ScopeAdmin = "admin"
+)
+
+var Scopes = []string{ScopeRead, ScopeWrite, ScopeAdmin}
+
+// APIKeyPrefix starts every plaintext key, so that leaked keys are easy to
+// recognise in logs and by secret scanners.
+const APIKeyPrefix = "hk_"
+
+// APIKey is a credential for services calling the write and admin
+// endpoints. Only a SHA-256 hash of the key is stored; Plaintext is set on a
+// freshly generated key so it can be shown once.
+type APIKey struct {
+	ID        int64      `json:"id"`
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt time.Time  `json:"createdAt"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
+	Plaintext string     `json:"key,omitempty"`
+	Hash      []byte     `json:"-"`
+}
+
+// GenerateAPIKey returns a new key with 128 bits of randomness. It is not
+// stored until passed to APIKeyStore.Insert.
+func GenerateAPIKey(name string, scopes []string) (*APIKey, error) {
+	b := make([]byte, 16)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &APIKey{
+		Name:      name,
+		Scopes:    scopes,
+		Plaintext: APIKeyPrefix + strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b)),
+	}
+	key.Hash = HashAPIKey(key.Plaintext)
+
+	return key, nil
+}
+
+func HashAPIKey(plaintext string) []byte {
+	hash := sha256.Sum256([]byte(plaintext))
+	return hash[:]
+}
+
+// HasScope reports whether the key was granted scope, directly or through
+// the admin scope.
+func (k *APIKey) HasScope(scope string) bool {
+	return GrantsScope(k.Scopes, scope)
+}
+
+// GrantsScope reports whether scopes include scope or the admin scope.
+func GrantsScope(scopes []string, scope string) bool {
+	return slices.Contains(scopes, scope) || slices.Contains(scopes, ScopeAdmin)
+}
+
+func ValidateAPIKey(v *validator.Validator, key *APIKey) {
+	v.Check(strings.TrimSpace(key.Name) != "", "name", validator.FieldError{
+		Code:    "name.required",
+		Message: "must be provided",
+	})
+	v.Check(len(key.Name) <= 100, "name", validator.FieldError{
+		Code:    "name.too_long",
+		Message: "must not be more than 100 bytes long",
+		Params:  map[string]any{"max": 100},
+	})
+
+	v.Check(len(key.Scopes) > 0, "scopes", validator.FieldError{
+		Code:    "scopes.required",
+		Message: "must contain at least one scope",
+	})
+	for _, scope := range key.Scopes {
+		v.Check(slices.Contains(Scopes, scope), "scopes", validator.FieldError{
+			Code:    "scopes.unsupported",
+			Message: "must only contain " + strings.Join(Scopes, ", "),
+			Params:  map[string]any{"supported": strings.Join(Scopes, ", ")},
+		})
+	}
+}
+
+type APIKeyModel struct {
+	DB *sql.DB
+}
+
+func (m APIKeyModel) Insert(key *APIKey) error {
+	query := `
+        INSERT INTO api_keys (name, hash, scopes)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, key.Name, key.Hash, pq.Array(key.Scopes)).Scan(&key.ID, &key.CreatedAt)
+}
+
+// GetByPlaintext returns the key matching plaintext, or ErrRecordNotFound
+// when there is none or it was revoked.
+func (m APIKeyModel) GetByPlaintext(plaintext string) (*APIKey, error) {
+	query := `
+        SELECT id, name, hash, scopes, created_at
+		FROM api_keys
+		WHERE hash = $1 AND revoked_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var key APIKey
+	err := m.DB.QueryRowContext(ctx, query, HashAPIKey(plaintext)).Scan(
+		&key.ID,
+		&key.Name,
+		&key.Hash,
+		pq.Array(&key.Scopes),
+		&key.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &key, nil
+}
+
+// List returns every key, revoked ones included, oldest first.
+func (m APIKeyModel) List() ([]*APIKey, error) {
+	query := "SELECT id, name, scopes, created_at, revoked_at FROM api_keys ORDER BY id"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		var key APIKey
+		err := rows.Scan(&key.ID, &key.Name, pq.Array(&key.Scopes), &key.CreatedAt, &key.RevokedAt)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, &key)
+	}
+
+	return keys, rows.Err()
+}
+
+// Revoke disables the key with id. Revoking a key twice returns
+// ErrRecordNotFound the second time.
+func (m APIKeyModel) Revoke(id int64) error {
+	query := "UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
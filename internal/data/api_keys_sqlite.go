@@ -0,0 +1,124 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// SQLiteAPIKeyModel stores scopes as a space-separated list, as SQLite has
+// no array type.
+type SQLiteAPIKeyModel struct {
+	DB *sql.DB
+}
+
+func (m SQLiteAPIKeyModel) Insert(key *APIKey) error {
+	query := `
+        INSERT INTO api_keys (name, hash, scopes, created_at)
+		VALUES (?, ?, ?, ?)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	createdAt := time.Now().UTC().Truncate(time.Second)
+
+	result, err := m.DB.ExecContext(ctx, query, key.Name, key.Hash, strings.Join(key.Scopes, " "), createdAt)
+	if err != nil {
+		return err
+	}
+
+	key.ID, err = result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	key.CreatedAt = createdAt
+
+	return nil
+}
+
+func (m SQLiteAPIKeyModel) GetByPlaintext(plaintext string) (*APIKey, error) {
+	query := `
+        SELECT id, name, hash, scopes, created_at
+		FROM api_keys
+		WHERE hash = ? AND revoked_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var (
+		key    APIKey
+		scopes string
+	)
+	err := m.DB.QueryRowContext(ctx, query, HashAPIKey(plaintext)).Scan(
+		&key.ID,
+		&key.Name,
+		&key.Hash,
+		&scopes,
+		&key.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	key.Scopes = strings.Fields(scopes)
+
+	return &key, nil
+}
+
+func (m SQLiteAPIKeyModel) List() ([]*APIKey, error) {
+	query := "SELECT id, name, scopes, created_at, revoked_at FROM api_keys ORDER BY id"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		var (
+			key    APIKey
+			scopes string
+		)
+		err := rows.Scan(&key.ID, &key.Name, &scopes, &key.CreatedAt, &key.RevokedAt)
+		if err != nil {
+			return nil, err
+		}
+		key.Scopes = strings.Fields(scopes)
+		keys = append(keys, &key)
+	}
+
+	return keys, rows.Err()
+}
+
+func (m SQLiteAPIKeyModel) Revoke(id int64) error {
+	query := "UPDATE api_keys SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, time.Now().UTC().Truncate(time.Second), id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
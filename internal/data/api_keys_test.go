@@ -0,0 +1,79 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/ab0utbla-k/rvt-hello-app/internal/validator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateAPIKey(t *testing.T) {
+	t.Parallel()
+
+	a, err := GenerateAPIKey("a", []string{ScopeRead})
+	require.NoError(t, err)
+	b, err := GenerateAPIKey("b", []string{ScopeRead})
+	require.NoError(t, err)
+
+	assert.Regexp(t, `^hk_[a-z2-7]{26}$`, a.Plaintext)
+	assert.NotEqual(t, a.Plaintext, b.Plaintext)
+	assert.Equal(t, HashAPIKey(a.Plaintext), a.Hash)
+	assert.Len(t, a.Hash, 32)
+}
+
+func TestAPIKey_HasScope(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		scopes []string
+		scope  string
+		expect bool
+	}{
+		{name: "granted", scopes: []string{ScopeRead, ScopeWrite}, scope: ScopeWrite, expect: true},
+		{name: "not granted", scopes: []string{ScopeRead}, scope: ScopeWrite, expect: false},
+		{name: "write does not imply read", scopes: []string{ScopeWrite}, scope: ScopeRead, expect: false},
+		{name: "admin implies write", scopes: []string{ScopeAdmin}, scope: ScopeWrite, expect: true},
+		{name: "admin implies read", scopes: []string{ScopeAdmin}, scope: ScopeRead, expect: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			key := &APIKey{Scopes: tt.scopes}
+			assert.Equal(t, tt.expect, key.HasScope(tt.scope))
+		})
+	}
+}
+
+func TestValidateAPIKey(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		key         APIKey
+		expectCodes map[string]string
+	}{
+		{name: "valid", key: APIKey{Name: "ops", Scopes: []string{ScopeAdmin}}, expectCodes: map[string]string{}},
+		{name: "missing name", key: APIKey{Name: " ", Scopes: []string{ScopeRead}}, expectCodes: map[string]string{"name": "name.required"}},
+		{name: "no scopes", key: APIKey{Name: "ops"}, expectCodes: map[string]string{"scopes": "scopes.required"}},
+		{name: "unknown scope", key: APIKey{Name: "ops", Scopes: []string{ScopeRead, "delete"}}, expectCodes: map[string]string{"scopes": "scopes.unsupported"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			v := validator.New()
+			ValidateAPIKey(v, &tt.key)
+
+			codes := make(map[string]string)
+			for field, err := range v.Errors {
+				codes[field] = err.Code
+			}
+			assert.Equal(t, tt.expectCodes, codes)
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ErrDuplicateIdempotencyKey is returned by IdempotencyStore.Insert when the
+// caller already used the key and it has not expired.
+var ErrDuplicateIdempotencyKey = errors.New("duplicate idempotency key")
+
+// ErrIdempotencyKeyLost is returned by IdempotencyStore.Complete and Delete
+// when the request no longer holds its key: the claim expired and a retry
+// took the key over.
+var ErrIdempotencyKeyLost = errors.New("idempotency key claim lost")
+
+// IdempotencyKey records a write request sent with an Idempotency-Key
+// header. Keys belong to the credential in Caller, so callers cannot see
+// each other's responses. Status is zero while the first request with the
+// key is being handled; after that Status, Header and Body hold its
+// response, to be replayed to retries until ExpiresAt.
+type IdempotencyKey struct {
+	Caller      string
+	Key         string
+	Fingerprint []byte
+	Status      int
+	Header      map[string][]string
+	Body        []byte
+	ExpiresAt   time.Time
+}
+
+type IdempotencyModel struct {
+	DB *sql.DB
+}
+
+// Insert claims the key for a request that is about to be handled. An
+// expired key is claimed again as if it was new.
+func (m IdempotencyModel) Insert(k *IdempotencyKey) error {
+	query := `
+        INSERT INTO idempotency_keys (caller, key, fingerprint, expires_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (caller, key) DO UPDATE
+		SET fingerprint = EXCLUDED.fingerprint, status = 0, headers = '{}', body = NULL, expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at <= NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, k.Caller, k.Key, k.Fingerprint, k.ExpiresAt)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrDuplicateIdempotencyKey
+	}
+
+	return nil
+}
+
+// Get returns the caller's unexpired key, or ErrRecordNotFound.
+func (m IdempotencyModel) Get(caller, key string) (*IdempotencyKey, error) {
+	query := `
+        SELECT fingerprint, status, headers, body, expires_at
+		FROM idempotency_keys
+		WHERE caller = $1 AND key = $2 AND expires_at > NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	k := IdempotencyKey{Caller: caller, Key: key}
+
+	var headers []byte
+	err := m.DB.QueryRowContext(ctx, query, caller, key).Scan(&k.Fingerprint, &k.Status, &headers, &k.Body, &k.ExpiresAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	err = json.Unmarshal(headers, &k.Header)
+	if err != nil {
+		return nil, err
+	}
+
+	return &k, nil
+}
+
+// Complete stores the response to k's request and keeps it until
+// k.ExpiresAt. It fails with ErrIdempotencyKeyLost unless the key is still
+// claimed for k's request.
+func (m IdempotencyModel) Complete(k *IdempotencyKey) error {
+	query := `
+        UPDATE idempotency_keys
+		SET status = $3, headers = $4, body = $5, expires_at = $6
+		WHERE caller = $1 AND key = $2 AND status = 0 AND fingerprint = $7`
+
+	headers, err := json.Marshal(k.Header)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, k.Caller, k.Key, k.Status, string(headers), k.Body, k.ExpiresAt, k.Fingerprint)
+	if err != nil {
+		return err
+	}
+
+	return requireClaim(result)
+}
+
+// Delete releases a key whose request failed, so that a retry is handled
+// again. Like Complete, it leaves a key that a retry took over alone.
+func (m IdempotencyModel) Delete(k *IdempotencyKey) error {
+	query := "DELETE FROM idempotency_keys WHERE caller = $1 AND key = $2 AND status = 0 AND fingerprint = $3"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, k.Caller, k.Key, k.Fingerprint)
+	if err != nil {
+		return err
+	}
+
+	return requireClaim(result)
+}
+
+// requireClaim returns ErrIdempotencyKeyLost when result affected no rows.
+func requireClaim(result sql.Result) error {
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrIdempotencyKeyLost
+	}
+
+	return nil
+}
+
+// DeleteExpired removes expired keys and returns how many there were.
+func (m IdempotencyModel) DeleteExpired() (int64, error) {
+	query := "DELETE FROM idempotency_keys WHERE expires_at <= NOW()"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,127 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// SQLiteIdempotencyModel stores expiry times as Unix seconds, so that they
+// compare as numbers.
+type SQLiteIdempotencyModel struct {
+	DB *sql.DB
+}
+
+func (m SQLiteIdempotencyModel) Insert(k *IdempotencyKey) error {
+	query := `
+        INSERT INTO idempotency_keys (caller, key, fingerprint, expires_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (caller, key) DO UPDATE
+		SET fingerprint = excluded.fingerprint, status = 0, headers = '{}', body = NULL, expires_at = excluded.expires_at
+		WHERE idempotency_keys.expires_at <= ?`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, k.Caller, k.Key, k.Fingerprint, k.ExpiresAt.Unix(), time.Now().Unix())
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrDuplicateIdempotencyKey
+	}
+
+	return nil
+}
+
+func (m SQLiteIdempotencyModel) Get(caller, key string) (*IdempotencyKey, error) {
+	query := `
+        SELECT fingerprint, status, headers, body, expires_at
+		FROM idempotency_keys
+		WHERE caller = ? AND key = ? AND expires_at > ?`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	k := IdempotencyKey{Caller: caller, Key: key}
+
+	var (
+		headers   string
+		expiresAt int64
+	)
+	err := m.DB.QueryRowContext(ctx, query, caller, key, time.Now().Unix()).Scan(&k.Fingerprint, &k.Status, &headers, &k.Body, &expiresAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	k.ExpiresAt = time.Unix(expiresAt, 0).UTC()
+
+	err = json.Unmarshal([]byte(headers), &k.Header)
+	if err != nil {
+		return nil, err
+	}
+
+	return &k, nil
+}
+
+func (m SQLiteIdempotencyModel) Complete(k *IdempotencyKey) error {
+	query := `
+        UPDATE idempotency_keys
+		SET status = ?, headers = ?, body = ?, expires_at = ?
+		WHERE caller = ? AND key = ? AND status = 0 AND fingerprint = ?`
+
+	headers, err := json.Marshal(k.Header)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, k.Status, string(headers), k.Body, k.ExpiresAt.Unix(), k.Caller, k.Key, k.Fingerprint)
+	if err != nil {
+		return err
+	}
+
+	return requireClaim(result)
+}
+
+func (m SQLiteIdempotencyModel) Delete(k *IdempotencyKey) error {
+	query := "DELETE FROM idempotency_keys WHERE caller = ? AND key = ? AND status = 0 AND fingerprint = ?"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, k.Caller, k.Key, k.Fingerprint)
+	if err != nil {
+		return err
+	}
+
+	return requireClaim(result)
+}
+
+func (m SQLiteIdempotencyModel) DeleteExpired() (int64, error) {
+	query := "DELETE FROM idempotency_keys WHERE expires_at <= ?"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, time.Now().Unix())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
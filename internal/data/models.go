@@ -3,16 +3,122 @@ package data
 import (
 	"database/sql"
 	"errors"
+	"strings"
+	"time"
 )
 
 var ErrRecordNotFound = errors.New("record not found")
 
+// UserStore is implemented by every storage backend that can persist users.
+type UserStore interface {
+	Insert(user *User) (created bool, err error)
+	Get(username string) (*User, error)
+	ListByBirthday(dates []time.Time) ([]*User, error)
+}
+
+// APIKeyStore is implemented by every storage backend that can persist API
+// keys.
+type APIKeyStore interface {
+	Insert(key *APIKey) error
+	GetByPlaintext(plaintext string) (*APIKey, error)
+	List() ([]*APIKey, error)
+	Revoke(id int64) error
+}
+
+// IdempotencyStore is implemented by every storage backend that can
+// persist idempotency keys.
+type IdempotencyStore interface {
+	Insert(k *IdempotencyKey) error
+	Get(caller, key string) (*IdempotencyKey, error)
+	Complete(k *IdempotencyKey) error
+	Delete(k *IdempotencyKey) error
+	DeleteExpired() (int64, error)
+}
+
+// NotificationStore is implemented by every storage backend that can
+// record birthday notifications.
+type NotificationStore interface {
+	Claim(n *Notification) error
+	MarkSent(n *Notification) error
+	Release(n *Notification) error
+}
+
+// WebhookStore is implemented by every storage backend that can persist
+// webhooks and their deliveries.
+type WebhookStore interface {
+	Insert(w *Webhook) error
+	List() ([]*Webhook, error)
+	Delete(id int64) error
+	Enqueue(eventID, event string, payload []byte) (int64, error)
+	ClaimDue(limit int, lease time.Duration) ([]*WebhookDelivery, error)
+	RecordAttempt(d *WebhookDelivery, a *WebhookAttempt) error
+	ListDeliveries(webhookID int64, limit int) ([]*WebhookDelivery, error)
+	Redeliver(webhookID, deliveryID int64) error
+}
+
+// OutboxStore is implemented by every storage backend that can record
+// domain events for publishing.
+type OutboxStore interface {
+	Insert(e *OutboxEvent) error
+	Dispatch(limit int, publish func(*OutboxEvent) error) (int, error)
+	DeleteDispatched(before time.Time) (int64, error)
+}
+
 type Models struct {
-	Users UserModel
+	Users         UserStore
+	APIKeys       APIKeyStore
+	Idempotency   IdempotencyStore
+	Notifications NotificationStore
+	Webhooks      WebhookStore
+	Outbox        OutboxStore
 }
 
+// NewModels returns models backed by PostgreSQL.
 func NewModels(db *sql.DB) Models {
 	return Models{
-		Users: UserModel{DB: db},
+		Users:         UserModel{DB: db, Retrier: DefaultRetrier},
+		APIKeys:       APIKeyModel{DB: db},
+		Idempotency:   IdempotencyModel{DB: db},
+		Notifications: NotificationModel{DB: db},
+		Webhooks:      WebhookModel{DB: db},
+		Outbox:        OutboxModel{DB: db},
+	}
+}
+
+// NewReplicatedModels returns models backed by PostgreSQL that send reads
+// to replica when it is healthy.
+func NewReplicatedModels(db *sql.DB, replica *Replica) Models {
+	return Models{
+		Users: UserModel{DB: db, Replica: replica, Retrier: DefaultRetrier},
+		// Keys are checked on the primary so that a revocation takes
+		// effect without waiting for replication.
+		APIKeys:       APIKeyModel{DB: db},
+		Idempotency:   IdempotencyModel{DB: db},
+		Notifications: NotificationModel{DB: db},
+		Webhooks:      WebhookModel{DB: db},
+		Outbox:        OutboxModel{DB: db},
+	}
+}
+
+// SQLiteDSN returns the data source name that opens the SQLite database at
+// path with foreign keys enforced. SQLite leaves them off for every new
+// connection, and the models rely on ON DELETE CASCADE.
+func SQLiteDSN(path string) string {
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	return path + sep + "_pragma=foreign_keys(1)"
+}
+
+// NewSQLiteModels returns models backed by SQLite.
+func NewSQLiteModels(db *sql.DB) Models {
+	return Models{
+		Users:         SQLiteUserModel{DB: db},
+		APIKeys:       SQLiteAPIKeyModel{DB: db},
+		Idempotency:   SQLiteIdempotencyModel{DB: db},
+		Notifications: SQLiteNotificationModel{DB: db},
+		Webhooks:      SQLiteWebhookModel{DB: db},
+		Outbox:        SQLiteOutboxModel{DB: db},
 	}
 }
@@ -0,0 +1,90 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrDuplicateNotification is returned by NotificationStore.Claim when the
+// notification was already claimed, by this instance or another one.
+var ErrDuplicateNotification = errors.New("duplicate notification")
+
+// Kinds of birthday notification.
+const (
+	NotificationBirthday = "birthday"
+	NotificationReminder = "reminder"
+)
+
+// Notification is an email about a user's birthday. There is at most one of
+// each kind per user and birthday, so claiming it before sending is what
+// keeps it from being sent twice.
+type Notification struct {
+	Username string
+	Kind     string
+	// Birthday is the date of the birthday the notification is about.
+	Birthday time.Time
+}
+
+func (n *Notification) birthday() string {
+	return n.Birthday.Format("2006-01-02")
+}
+
+type NotificationModel struct {
+	DB *sql.DB
+}
+
+// Claim records that n is about to be sent.
+func (m NotificationModel) Claim(n *Notification) error {
+	query := `
+        INSERT INTO notifications (username, kind, birthday)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING`
+
+	return claimNotification(m.DB, query, n)
+}
+
+// MarkSent records that n was handed to the mail server.
+func (m NotificationModel) MarkSent(n *Notification) error {
+	query := "UPDATE notifications SET sent_at = NOW() WHERE username = $1 AND kind = $2 AND birthday = $3"
+
+	return execNotification(m.DB, query, n)
+}
+
+// Release gives up the claim on n, so that it is sent on a later run. Sent
+// notifications are kept.
+func (m NotificationModel) Release(n *Notification) error {
+	query := "DELETE FROM notifications WHERE username = $1 AND kind = $2 AND birthday = $3 AND sent_at IS NULL"
+
+	return execNotification(m.DB, query, n)
+}
+
+func claimNotification(db *sql.DB, query string, n *Notification) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := db.ExecContext(ctx, query, n.Username, n.Kind, n.birthday())
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrDuplicateNotification
+	}
+
+	return nil
+}
+
+func execNotification(db *sql.DB, query string, n *Notification) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, query, n.Username, n.Kind, n.birthday())
+	return err
+}
@@ -0,0 +1,31 @@
+package data
+
+import (
+	"database/sql"
+)
+
+// SQLiteNotificationModel stores birthdays as YYYY-MM-DD text.
+type SQLiteNotificationModel struct {
+	DB *sql.DB
+}
+
+func (m SQLiteNotificationModel) Claim(n *Notification) error {
+	query := `
+        INSERT INTO notifications (username, kind, birthday)
+		VALUES (?, ?, ?)
+		ON CONFLICT DO NOTHING`
+
+	return claimNotification(m.DB, query, n)
+}
+
+func (m SQLiteNotificationModel) MarkSent(n *Notification) error {
+	query := "UPDATE notifications SET sent_at = CURRENT_TIMESTAMP WHERE username = ? AND kind = ? AND birthday = ?"
+
+	return execNotification(m.DB, query, n)
+}
+
+func (m SQLiteNotificationModel) Release(n *Notification) error {
+	query := "DELETE FROM notifications WHERE username = ? AND kind = ? AND birthday = ? AND sent_at IS NULL"
+
+	return execNotification(m.DB, query, n)
+}
@@ -0,0 +1,221 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Domain events recorded in the outbox. Webhooks can subscribe to those in
+// WebhookEvents.
+const (
+	EventUserCreated  = "user.created"
+	EventUserUpdated  = "user.updated"
+	EventUserBirthday = "user.birthday"
+)
+
+// OutboxEvent is a domain event waiting in the outbox to be published.
+// Payload is an EventPayload encoded as JSON.
+type OutboxEvent struct {
+	ID        int64
+	EventID   string
+	Type      string
+	Payload   json.RawMessage
+	CreatedAt time.Time
+}
+
+// EventPayload is the JSON form of a domain event, and the body of webhook
+// deliveries.
+type EventPayload struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"createdAt"`
+	Data      UserData  `json:"data"`
+}
+
+// UserData describes the user an event is about. Email addresses are left
+// out, as events leave the service. Birthday and AgeTurning are only set
+// for user.birthday.
+type UserData struct {
+	Username    string `json:"username"`
+	DateOfBirth string `json:"dateOfBirth"`
+	Locale      string `json:"locale,omitempty"`
+	TimeZone    string `json:"timeZone,omitempty"`
+	Birthday    string `json:"birthday,omitempty"`
+	AgeTurning  int    `json:"ageTurning,omitempty"`
+}
+
+func newUserData(user *User) UserData {
+	return UserData{
+		Username:    user.Username,
+		DateOfBirth: user.DateOfBirth.Format("2006-01-02"),
+		Locale:      user.Locale,
+		TimeZone:    user.TimeZone,
+	}
+}
+
+// NewBirthdayEvent returns user.birthday for user. Its event ID names the
+// user and the date, so the outbox records it once however often the
+// scheduler runs.
+func NewBirthdayEvent(user *User, birthday Birthday) (*OutboxEvent, error) {
+	date := birthday.Next.Format("2006-01-02")
+
+	d := newUserData(user)
+	d.Birthday = date
+	d.AgeTurning = birthday.AgeTurning
+
+	return newEvent("birthday:"+user.Username+":"+date, EventUserBirthday, d)
+}
+
+func newEvent(eventID, event string, d UserData) (*OutboxEvent, error) {
+	createdAt := time.Now().UTC().Truncate(time.Second)
+
+	payload, err := json.Marshal(EventPayload{
+		ID:        eventID,
+		Type:      event,
+		CreatedAt: createdAt,
+		Data:      d,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &OutboxEvent{EventID: eventID, Type: event, Payload: payload, CreatedAt: createdAt}, nil
+}
+
+func newEventID() string {
+	return "evt_" + strings.ToLower(rand.Text())
+}
+
+// execer is a *sql.DB or a *sql.Tx, so events can be written on their own
+// or in the transaction that changes the data they describe.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// insertEvent records e unless the outbox already has an event with its
+// event ID.
+func insertEvent(ctx context.Context, db execer, query string, e *OutboxEvent) error {
+	_, err := db.ExecContext(ctx, query, e.EventID, e.Type, []byte(e.Payload), e.CreatedAt)
+	return err
+}
+
+// publishEvents passes events to publish in order, stops at the first one
+// it fails on, and returns the IDs of those it accepted.
+func publishEvents(events []*OutboxEvent, publish func(*OutboxEvent) error) ([]int64, error) {
+	var ids []int64
+
+	for _, e := range events {
+		err := publish(e)
+		if err != nil {
+			return ids, err
+		}
+		ids = append(ids, e.ID)
+	}
+
+	return ids, nil
+}
+
+func scanEvents(rows *sql.Rows) ([]*OutboxEvent, error) {
+	var events []*OutboxEvent
+
+	for rows.Next() {
+		var e OutboxEvent
+
+		err := rows.Scan(&e.ID, &e.EventID, &e.Type, &e.Payload, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, &e)
+	}
+
+	return events, rows.Err()
+}
+
+type OutboxModel struct {
+	DB *sql.DB
+}
+
+const outboxInsertQuery = `
+        INSERT INTO outbox (event_id, event, payload, created_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (event_id) DO NOTHING`
+
+func (m OutboxModel) Insert(e *OutboxEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return insertEvent(ctx, m.DB, outboxInsertQuery, e)
+}
+
+// Dispatch locks up to limit of the oldest undispatched events, skipping
+// those another instance holds, and passes them to publish in order. The
+// events publish accepts before it fails, if it does, are marked
+// dispatched in the same transaction.
+func (m OutboxModel) Dispatch(limit int, publish func(*OutboxEvent) error) (int, error) {
+	query := `
+        SELECT id, event_id, event, payload, created_at
+		FROM outbox
+		WHERE dispatched_at IS NULL
+		ORDER BY id
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, query, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	events, err := scanEvents(rows)
+	rows.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	ids, publishErr := publishEvents(events, publish)
+	if len(ids) == 0 {
+		return 0, publishErr
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE outbox SET dispatched_at = NOW() WHERE id = ANY($1)", pq.Array(ids))
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return len(ids), publishErr
+}
+
+// DeleteDispatched removes the events dispatched before the given time.
+// Until they are removed, they keep birthday events from being recorded
+// twice.
+func (m OutboxModel) DeleteDispatched(before time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, "DELETE FROM outbox WHERE dispatched_at < $1", before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
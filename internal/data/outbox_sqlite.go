@@ -0,0 +1,77 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// SQLiteOutboxModel stores when events were dispatched in Unix seconds.
+type SQLiteOutboxModel struct {
+	DB *sql.DB
+}
+
+const sqliteOutboxInsertQuery = `
+        INSERT INTO outbox (event_id, event, payload, created_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (event_id) DO NOTHING`
+
+func (m SQLiteOutboxModel) Insert(e *OutboxEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return insertEvent(ctx, m.DB, sqliteOutboxInsertQuery, e)
+}
+
+// Dispatch does without a transaction, as SQLite allows a single writer
+// and the sinks may write to the same database. The accepted events are
+// marked dispatched after publish returns.
+func (m SQLiteOutboxModel) Dispatch(limit int, publish func(*OutboxEvent) error) (int, error) {
+	query := `
+        SELECT id, event_id, event, payload, created_at
+		FROM outbox
+		WHERE dispatched_at IS NULL
+		ORDER BY id
+		LIMIT ?`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	events, err := scanEvents(rows)
+	rows.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	ids, publishErr := publishEvents(events, publish)
+	if len(ids) == 0 {
+		return 0, publishErr
+	}
+
+	// The accepted events are the oldest undispatched ones, as publish is
+	// given them in order.
+	_, err = m.DB.ExecContext(ctx, "UPDATE outbox SET dispatched_at = ? WHERE dispatched_at IS NULL AND id <= ?",
+		time.Now().Unix(), ids[len(ids)-1])
+	if err != nil {
+		return 0, err
+	}
+
+	return len(ids), publishErr
+}
+
+func (m SQLiteOutboxModel) DeleteDispatched(before time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, "DELETE FROM outbox WHERE dispatched_at < ?", before.Unix())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,110 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"sync/atomic"
+	"time"
+)
+
+// Replica routes reads to a read replica while it is reachable and its
+// replication lag is within MaxLag, and to the primary otherwise.
+type Replica struct {
+	Primary *sql.DB
+	DB      *sql.DB
+	MaxLag  time.Duration
+
+	healthy atomic.Bool
+	lag     atomic.Int64
+}
+
+type ReplicaStats struct {
+	sql.DBStats
+	Healthy    bool
+	LagSeconds float64
+}
+
+// NewReplica returns a Replica that starts out unhealthy, so reads go to the
+// primary until the first successful Check.
+func NewReplica(primary, replica *sql.DB, maxLag time.Duration) *Replica {
+	return &Replica{
+		Primary: primary,
+		DB:      replica,
+		MaxLag:  maxLag,
+	}
+}
+
+// Reader returns the pool that reads should use.
+func (r *Replica) Reader() *sql.DB {
+	if r.healthy.Load() {
+		return r.DB
+	}
+	return r.Primary
+}
+
+// MarkUnhealthy sends reads to the primary until the next successful Check.
+func (r *Replica) MarkUnhealthy() {
+	r.healthy.Store(false)
+}
+
+// Check measures the replication lag and updates the replica's health.
+func (r *Replica) Check() error {
+	// Replay timestamps stop advancing while the primary is idle, so a
+	// replica that has replayed everything it received counts as caught up.
+	query := `
+		SELECT CASE
+			WHEN NOT pg_is_in_recovery() THEN 0
+			WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+			ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+		END`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var seconds float64
+	err := r.DB.QueryRowContext(ctx, query).Scan(&seconds)
+
+	r.record(time.Duration(seconds*float64(time.Second)), err)
+	return err
+}
+
+func (r *Replica) record(lag time.Duration, err error) {
+	if err != nil {
+		r.healthy.Store(false)
+		return
+	}
+
+	r.lag.Store(int64(lag))
+	r.healthy.Store(lag <= r.MaxLag)
+}
+
+// Monitor runs Check every interval until ctx is cancelled.
+func (r *Replica) Monitor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.Check()
+		}
+	}
+}
+
+func (r *Replica) Healthy() bool {
+	return r.healthy.Load()
+}
+
+func (r *Replica) Lag() time.Duration {
+	return time.Duration(r.lag.Load())
+}
+
+func (r *Replica) Stats() ReplicaStats {
+	return ReplicaStats{
+		DBStats:    r.DB.Stats(),
+		Healthy:    r.Healthy(),
+		LagSeconds: r.Lag().Seconds(),
+	}
+}
@@ -0,0 +1,96 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+)
+
+func openSQLite(t *testing.T, name string) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), name))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func TestReplica_Reader(t *testing.T) {
+	t.Parallel()
+
+	primary := openSQLite(t, "primary.db")
+	replicaDB := openSQLite(t, "replica.db")
+
+	tests := []struct {
+		name       string
+		lag        time.Duration
+		err        error
+		wantReader *sql.DB
+	}{
+		{
+			name:       "caught up",
+			lag:        0,
+			wantReader: replicaDB,
+		},
+		{
+			name:       "lag at threshold",
+			lag:        10 * time.Second,
+			wantReader: replicaDB,
+		},
+		{
+			name:       "lagging beyond threshold",
+			lag:        11 * time.Second,
+			wantReader: primary,
+		},
+		{
+			name:       "unreachable",
+			err:        errors.New("connection refused"),
+			wantReader: primary,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			replica := NewReplica(primary, replicaDB, 10*time.Second)
+			assert.Same(t, primary, replica.Reader(), "new replicas should start unhealthy")
+
+			replica.record(tt.lag, tt.err)
+			assert.Same(t, tt.wantReader, replica.Reader())
+		})
+	}
+}
+
+func TestUserModel_Get_FallsBackToPrimary(t *testing.T) {
+	t.Parallel()
+
+	primary := openSQLite(t, "primary.db")
+	_, err := primary.Exec(`CREATE TABLE users (username TEXT PRIMARY KEY, date_of_birth TIMESTAMP NOT NULL, locale TEXT, email TEXT, time_zone TEXT)`)
+	require.NoError(t, err)
+
+	dob := time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC)
+	_, err = primary.Exec(`INSERT INTO users (username, date_of_birth) VALUES ($1, $2)`, "john", dob)
+	require.NoError(t, err)
+
+	// The replica has no users table, so every read against it fails.
+	replica := NewReplica(primary, openSQLite(t, "replica.db"), 10*time.Second)
+	replica.record(0, nil)
+
+	users := UserModel{DB: primary, Replica: replica}
+
+	user, err := users.Get("john")
+	require.NoError(t, err)
+	assert.Equal(t, "john", user.Username)
+	assert.False(t, replica.Healthy(), "a failing read should take the replica out of rotation")
+
+	_, err = users.Get("jane")
+	assert.ErrorIs(t, err, ErrRecordNotFound)
+}
@@ -0,0 +1,117 @@
+package data
+
+import (
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/ab0utbla-k/rvt-hello-app/internal/backoff"
+	"github.com/lib/pq"
+)
+
+// DefaultRetrier is used by the PostgreSQL models. Its stats are published
+// by the API under expvar.
+var DefaultRetrier = NewRetrier(3, backoff.Backoff{
+	Initial: 50 * time.Millisecond,
+	Max:     time.Second,
+})
+
+// Retrier re-runs database operations that fail with transient errors, such
+// as the connection resets and serialization failures seen during an RDS
+// failover. A nil *Retrier runs every operation exactly once.
+type Retrier struct {
+	MaxAttempts int
+	Backoff     backoff.Backoff
+
+	retries   atomic.Int64
+	recovered atomic.Int64
+	exhausted atomic.Int64
+	sleep     func(time.Duration)
+}
+
+type RetryStats struct {
+	Retries   int64 `json:"retries"`
+	Recovered int64 `json:"recovered"`
+	Exhausted int64 `json:"exhausted"`
+}
+
+func NewRetrier(maxAttempts int, b backoff.Backoff) *Retrier {
+	return &Retrier{
+		MaxAttempts: maxAttempts,
+		Backoff:     b,
+		sleep:       time.Sleep,
+	}
+}
+
+// Do calls fn until it succeeds, fails with an error that IsTransient does
+// not accept, or MaxAttempts is reached. Only idempotent operations should
+// be run through Do, since a connection reset may hide a committed write.
+func (r *Retrier) Do(fn func() error) error {
+	if r == nil {
+		return fn()
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			if attempt > 1 {
+				r.recovered.Add(1)
+			}
+			return nil
+		}
+
+		if !IsTransient(err) {
+			return err
+		}
+
+		if attempt >= r.MaxAttempts {
+			r.exhausted.Add(1)
+			return err
+		}
+
+		r.retries.Add(1)
+		r.sleep(r.Backoff.Delay(attempt))
+	}
+}
+
+func (r *Retrier) Stats() RetryStats {
+	return RetryStats{
+		Retries:   r.retries.Load(),
+		Recovered: r.recovered.Load(),
+		Exhausted: r.exhausted.Load(),
+	}
+}
+
+// IsTransient reports whether err is likely to go away if the operation is
+// retried: PostgreSQL connection exceptions (class 08), serialization
+// failures (40001), deadlocks (40P01), server shutdowns during failover
+// (57P01-57P03) and network-level connection errors.
+func IsTransient(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case "40001", "40P01", "57P01", "57P02", "57P03":
+			return true
+		}
+		return pqErr.Code.Class() == "08"
+	}
+
+	var netErr *net.OpError
+
+	switch {
+	case errors.Is(err, driver.ErrBadConn),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.EPIPE):
+		return true
+	case errors.As(err, &netErr):
+		return !netErr.Timeout()
+	default:
+		return false
+	}
+}
@@ -0,0 +1,192 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ab0utbla-k/rvt-hello-app/internal/backoff"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"modernc.org/sqlite"
+)
+
+// faultyConnector opens SQLite connections whose Exec and Query calls first
+// return any errors queued with fail, simulating a flaky PostgreSQL server.
+type faultyConnector struct {
+	dsn    string
+	driver *sqlite.Driver
+
+	mu     sync.Mutex
+	faults []error
+}
+
+func (c *faultyConnector) Connect(context.Context) (driver.Conn, error) {
+	conn, err := c.driver.Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &faultyConn{Conn: conn, connector: c}, nil
+}
+
+func (c *faultyConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+func (c *faultyConnector) fail(errs ...error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.faults = append(c.faults, errs...)
+}
+
+func (c *faultyConnector) next() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.faults) == 0 {
+		return nil
+	}
+
+	err := c.faults[0]
+	c.faults = c.faults[1:]
+	return err
+}
+
+type faultyConn struct {
+	driver.Conn
+	connector *faultyConnector
+}
+
+func (c *faultyConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if err := c.connector.next(); err != nil {
+		return nil, err
+	}
+	return c.Conn.(driver.ExecerContext).ExecContext(ctx, query, args)
+}
+
+func (c *faultyConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if err := c.connector.next(); err != nil {
+		return nil, err
+	}
+	return c.Conn.(driver.QueryerContext).QueryContext(ctx, query, args)
+}
+
+func newFaultyUserModel(t *testing.T) (UserModel, *faultyConnector, *Retrier) {
+	t.Helper()
+
+	connector := &faultyConnector{
+		dsn:    filepath.Join(t.TempDir(), "users.db"),
+		driver: &sqlite.Driver{},
+	}
+
+	db := sql.OpenDB(connector)
+	t.Cleanup(func() { db.Close() })
+
+	// xmax stands in for the PostgreSQL system column that Insert reads to
+	// tell an insert from an update.
+	_, err := db.Exec(`CREATE TABLE users (username TEXT PRIMARY KEY, date_of_birth TIMESTAMP NOT NULL, locale TEXT, email TEXT, time_zone TEXT, xmax INTEGER NOT NULL DEFAULT 0)`)
+	require.NoError(t, err)
+
+	_, err = db.Exec(`CREATE TABLE outbox (id INTEGER PRIMARY KEY, event_id TEXT NOT NULL UNIQUE, event TEXT NOT NULL, payload BLOB NOT NULL, created_at TIMESTAMP NOT NULL, dispatched_at TIMESTAMP)`)
+	require.NoError(t, err)
+
+	retrier := NewRetrier(3, backoff.Backoff{})
+
+	return UserModel{DB: db, Retrier: retrier}, connector, retrier
+}
+
+func TestIsTransient(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "serialization failure", err: &pq.Error{Code: "40001"}, want: true},
+		{name: "deadlock detected", err: &pq.Error{Code: "40P01"}, want: true},
+		{name: "connection failure", err: &pq.Error{Code: "08006"}, want: true},
+		{name: "admin shutdown", err: &pq.Error{Code: "57P01"}, want: true},
+		{name: "wrapped pq error", err: fmt.Errorf("insert: %w", &pq.Error{Code: "40001"}), want: true},
+		{name: "connection reset", err: syscall.ECONNRESET, want: true},
+		{name: "bad connection", err: driver.ErrBadConn, want: true},
+		{name: "unique violation", err: &pq.Error{Code: "23505"}, want: false},
+		{name: "no rows", err: sql.ErrNoRows, want: false},
+		{name: "deadline exceeded", err: context.DeadlineExceeded, want: false},
+		{name: "other error", err: errors.New("boom"), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.want, IsTransient(tt.err))
+		})
+	}
+}
+
+func TestUserModel_RetriesTransientErrors(t *testing.T) {
+	t.Parallel()
+
+	users, connector, retrier := newFaultyUserModel(t)
+
+	dob := time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	connector.fail(&pq.Error{Code: "40001"}, syscall.ECONNRESET)
+	created, err := users.Insert(&User{Username: "john", DateOfBirth: dob})
+	require.NoError(t, err)
+	assert.True(t, created)
+
+	connector.fail(&pq.Error{Code: "40P01"})
+	user, err := users.Get("john")
+	require.NoError(t, err)
+	assert.True(t, dob.Equal(user.DateOfBirth))
+
+	connector.fail(&pq.Error{Code: "08006"})
+	_, err = users.Insert(&User{Username: "john", DateOfBirth: dob})
+	require.NoError(t, err)
+
+	assert.Equal(t, RetryStats{Retries: 4, Recovered: 3}, retrier.Stats())
+
+	var events int
+	require.NoError(t, users.DB.QueryRow("SELECT COUNT(*) FROM outbox").Scan(&events))
+	assert.Equal(t, 2, events, "retries must not record events twice")
+}
+
+func TestUserModel_GivesUpAfterMaxAttempts(t *testing.T) {
+	t.Parallel()
+
+	users, connector, retrier := newFaultyUserModel(t)
+
+	failover := &pq.Error{Code: "57P01"}
+	connector.fail(failover, failover, failover)
+
+	_, err := users.Insert(&User{Username: "john", DateOfBirth: time.Now().AddDate(-30, 0, 0)})
+	assert.ErrorIs(t, err, failover)
+	assert.Equal(t, RetryStats{Retries: 2, Exhausted: 1}, retrier.Stats())
+}
+
+func TestUserModel_DoesNotRetryPermanentErrors(t *testing.T) {
+	t.Parallel()
+
+	users, connector, retrier := newFaultyUserModel(t)
+
+	violation := &pq.Error{Code: "23505"}
+	connector.fail(violation)
+
+	_, err := users.Insert(&User{Username: "john", DateOfBirth: time.Now().AddDate(-30, 0, 0)})
+	assert.ErrorIs(t, err, violation)
+	assert.Equal(t, RetryStats{}, retrier.Stats())
+
+	_, err = users.Get("jane")
+	assert.ErrorIs(t, err, ErrRecordNotFound)
+	assert.Equal(t, RetryStats{}, retrier.Stats())
+}
@@ -4,51 +4,189 @@ import (
 	"context"
 	"database/sql"
 	"errors"
-	"fmt"
+	"strings"
 	"time"
+	// Alpine images ship without a time zone database.
+	_ "time/tzdata"
 
+	"github.com/ab0utbla-k/rvt-hello-app/internal/i18n"
 	"github.com/ab0utbla-k/rvt-hello-app/internal/validator"
+	"github.com/lib/pq"
 )
 
 type User struct {
 	Username    string    `json:"username"`
 	DateOfBirth time.Time `json:"dateOfBirth"`
+
+	// Locale is the user's preferred language for their birthday message,
+	// empty when they have not chosen one.
+	Locale string `json:"locale,omitempty"`
+
+	// Email is where birthday emails are sent, empty when the user does
+	// not want them.
+	Email string `json:"email,omitempty"`
+
+	// TimeZone is the IANA name of the user's time zone, which decides
+	// when their birthday starts. Empty means the server's time zone.
+	TimeZone string `json:"timeZone,omitempty"`
 }
 
 func ValidateUser(v *validator.Validator, user *User) {
-	v.Check(validator.Matches(user.Username, validator.UserRX), "username", "must contain only letters")
-	v.Check(!user.DateOfBirth.IsZero(), "dateOfBirth", "must be provided")
-	v.Check(user.DateOfBirth.Before(time.Now()), "dateOfBirth", "must be in the past")
+	v.Check(validator.Matches(user.Username, validator.UserRX), "username", validator.FieldError{
+		Code:    "username.invalid_chars",
+		Message: "must contain only letters",
+		Params:  map[string]any{"pattern": validator.UserRX.String()},
+	})
+	v.Check(!user.DateOfBirth.IsZero(), "dateOfBirth", validator.FieldError{
+		Code:    "dateOfBirth.required",
+		Message: "must be provided",
+	})
+
+	now := time.Now()
+	v.Check(user.DateOfBirth.Before(now), "dateOfBirth", validator.FieldError{
+		Code:    "dateOfBirth.in_future",
+		Message: "must be in the past",
+		Params:  map[string]any{"max": now.UTC().Format("2006-01-02")},
+	})
+
+	v.Check(user.Locale == "" || i18n.Supports(user.Locale), "locale", validator.FieldError{
+		Code:    "locale.unsupported",
+		Message: "must be one of " + strings.Join(i18n.Supported, ", "),
+		Params:  map[string]any{"supported": strings.Join(i18n.Supported, ", ")},
+	})
+
+	v.Check(user.Email == "" || (len(user.Email) <= 254 && validator.Matches(user.Email, validator.EmailRX)), "email", validator.FieldError{
+		Code:    "email.invalid",
+		Message: "must be a valid email address",
+	})
+
+	v.Check(user.TimeZone == "" || validTimeZone(user.TimeZone), "timeZone", validator.FieldError{
+		Code:    "timeZone.invalid",
+		Message: "must be an IANA time zone, such as Europe/Berlin",
+	})
+}
+
+// validTimeZone reports whether name is in the time zone database. "Local"
+// is rejected, as it means whatever zone the server runs in.
+func validTimeZone(name string) bool {
+	if name == "Local" {
+		return false
+	}
+
+	_, err := time.LoadLocation(name)
+	return err == nil
 }
 
 type UserModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Replica *Replica
+	Retrier *Retrier
 }
 
-func (u UserModel) Insert(user *User) error {
+// reader returns the pool for read queries: the replica when one is
+// configured and healthy, the primary otherwise.
+func (u UserModel) reader() *sql.DB {
+	if u.Replica != nil {
+		return u.Replica.Reader()
+	}
+	return u.DB
+}
+
+// Insert creates the user or replaces the one with the same username, and
+// reports which it did. user.created or user.updated is recorded in the
+// outbox in the same transaction.
+func (u UserModel) Insert(user *User) (bool, error) {
+	// xmax is zero for a row version no transaction has replaced, which is
+	// only the case when the row was inserted.
 	query := `
-        INSERT INTO users (username, date_of_birth)
-		VALUES ($1, $2)
-		ON CONFLICT (username) DO UPDATE SET date_of_birth = EXCLUDED.date_of_birth`
+        INSERT INTO users (username, date_of_birth, locale, email, time_zone)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), NULLIF($5, ''))
+		ON CONFLICT (username) DO UPDATE
+		SET date_of_birth = EXCLUDED.date_of_birth, locale = EXCLUDED.locale, email = EXCLUDED.email, time_zone = EXCLUDED.time_zone
+		RETURNING xmax = 0`
+
+	// The upsert is idempotent, so it is safe to retry after a connection
+	// reset even if the first attempt was committed. The retry then reports
+	// an update, but keeps the event ID, so the outbox keeps the event the
+	// first attempt recorded.
+	eventID := newEventID()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	var created bool
+	err := u.Retrier.Do(func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
 
-	_, err := u.DB.ExecContext(ctx, query, user.Username, user.DateOfBirth)
-	return err
+		tx, err := u.DB.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		err = tx.QueryRowContext(ctx, query, user.Username, user.DateOfBirth, user.Locale, user.Email, user.TimeZone).Scan(&created)
+		if err != nil {
+			return err
+		}
+
+		event, err := newUserEvent(eventID, created, user)
+		if err != nil {
+			return err
+		}
+
+		err = insertEvent(ctx, tx, outboxInsertQuery, event)
+		if err != nil {
+			return err
+		}
+
+		return tx.Commit()
+	})
+
+	return created, err
+}
+
+// newUserEvent returns user.created or user.updated for user.
+func newUserEvent(eventID string, created bool, user *User) (*OutboxEvent, error) {
+	event := EventUserUpdated
+	if created {
+		event = EventUserCreated
+	}
+
+	return newEvent(eventID, event, newUserData(user))
 }
 
 func (u UserModel) Get(username string) (*User, error) {
-	query := "SELECT username, date_of_birth FROM users WHERE username = $1"
+	// A failing replica is taken out of rotation without retries and the
+	// read goes to the primary instead.
+	if db := u.reader(); db != u.DB {
+		user, err := u.get(db, nil, username)
+		if err == nil || errors.Is(err, ErrRecordNotFound) {
+			return user, err
+		}
+
+		u.Replica.MarkUnhealthy()
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	return u.get(u.DB, u.Retrier, username)
+}
+
+func (u UserModel) get(db *sql.DB, retrier *Retrier, username string) (*User, error) {
+	query := `
+        SELECT username, date_of_birth, COALESCE(locale, ''), COALESCE(email, ''), COALESCE(time_zone, '')
+		FROM users
+		WHERE username = $1`
 
 	var user User
-	err := u.DB.QueryRowContext(ctx, query, username).Scan(
-		&user.Username,
-		&user.DateOfBirth,
-	)
+	err := retrier.Do(func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		return db.QueryRowContext(ctx, query, username).Scan(
+			&user.Username,
+			&user.DateOfBirth,
+			&user.Locale,
+			&user.Email,
+			&user.TimeZone,
+		)
+	})
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -61,24 +199,145 @@ func (u UserModel) Get(username string) (*User, error) {
 	return &user, nil
 }
 
-func (u *User) GetBirthdayMessage() string {
-	now := time.Now()
-	location := now.Location()
+// ListByBirthday returns the users whose birthday falls on one of dates.
+// It reads from the primary, as the scheduler that calls it is not latency
+// bound.
+func (u UserModel) ListByBirthday(dates []time.Time) ([]*User, error) {
+	query := `
+        SELECT username, date_of_birth, COALESCE(locale, ''), COALESCE(email, ''), COALESCE(time_zone, '')
+		FROM users
+		WHERE to_char(date_of_birth AT TIME ZONE 'UTC', 'MM-DD') = ANY($1)
+		ORDER BY username`
 
-	// Today's date at midnight in local timezone
-	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, location)
+	var users []*User
+	err := u.Retrier.Do(func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
 
-	thisYearBirthday := time.Date(today.Year(), u.DateOfBirth.Month(), u.DateOfBirth.Day(), 0, 0, 0, 0, time.UTC)
+		rows, err := u.DB.QueryContext(ctx, query, pq.Array(birthdayKeys(dates)))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
 
-	if thisYearBirthday.Before(today) {
-		thisYearBirthday = thisYearBirthday.AddDate(1, 0, 0)
+		users, err = scanUsers(rows)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	daysUntilBirthday := int(thisYearBirthday.Sub(today).Hours() / 24)
+	return users, nil
+}
+
+// birthdayKeys returns the month and day, as MM-DD, of the dates of birth
+// whose birthday falls on one of dates. People born on February 29th
+// celebrate on March 1st when it is not a leap year.
+func birthdayKeys(dates []time.Time) []string {
+	var keys []string
+
+	for _, date := range dates {
+		keys = append(keys, date.Format("01-02"))
+
+		if date.Month() == time.March && date.Day() == 1 && date.AddDate(0, 0, -1).Day() == 28 {
+			keys = append(keys, "02-29")
+		}
+	}
+
+	return keys
+}
+
+func scanUsers(rows *sql.Rows) ([]*User, error) {
+	users := []*User{}
+
+	for rows.Next() {
+		var user User
+
+		err := rows.Scan(
+			&user.Username,
+			&user.DateOfBirth,
+			&user.Locale,
+			&user.Email,
+			&user.TimeZone,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		users = append(users, &user)
+	}
+
+	return users, rows.Err()
+}
+
+// Birthday describes a user's next birthday.
+type Birthday struct {
+	// Next is the date of the next birthday, which is today on the day.
+	Next       time.Time
+	DaysUntil  int
+	AgeTurning int
+	IsToday    bool
+}
+
+// Birthday returns the user's next birthday as of now.
+func (u *User) Birthday() Birthday {
+	return u.BirthdayAt(time.Now())
+}
+
+// BirthdayAt returns the user's next birthday as of t, taking the date of t
+// in the user's time zone.
+func (u *User) BirthdayAt(t time.Time) Birthday {
+	return u.birthdayAt(t.In(u.Location()))
+}
+
+// Location returns the user's time zone, or the server's when they have
+// none or it is no longer in the time zone database.
+func (u *User) Location() *time.Location {
+	if u.TimeZone == "" {
+		return time.Local
+	}
+
+	loc, err := time.LoadLocation(u.TimeZone)
+	if err != nil {
+		return time.Local
+	}
+
+	return loc
+}
+
+func (u *User) birthdayAt(now time.Time) Birthday {
+	// Dates of birth are stored as midnight UTC, so today's local date is
+	// compared as midnight UTC too.
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	next := time.Date(today.Year(), u.DateOfBirth.Month(), u.DateOfBirth.Day(), 0, 0, 0, 0, time.UTC)
+
+	if next.Before(today) {
+		next = next.AddDate(1, 0, 0)
+	}
+
+	daysUntil := int(next.Sub(today).Hours() / 24)
+
+	return Birthday{
+		Next:       next,
+		DaysUntil:  daysUntil,
+		AgeTurning: next.Year() - u.DateOfBirth.Year(),
+		IsToday:    daysUntil == 0,
+	}
+}
+
+// GetBirthdayMessage greets the user in locale.
+func (u *User) GetBirthdayMessage(locale string) string {
+	return u.Birthday().Message(u.Username, locale)
+}
+
+// Message greets username in locale about this birthday.
+func (b Birthday) Message(username, locale string) string {
+	args := map[string]any{"username": username}
 
-	if daysUntilBirthday == 0 {
-		return fmt.Sprintf("Hello, %s! Happy birthday!", u.Username)
+	if b.IsToday {
+		return i18n.T(locale, "birthday.today", args)
 	}
 
-	return fmt.Sprintf("Hello, %s! Your birthday is in %d day(s)", u.Username, daysUntilBirthday)
+	return i18n.N(locale, "birthday.in_days", b.DaysUntil, args)
 }
@@ -0,0 +1,140 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/ab0utbla-k/rvt-hello-app/internal/cache"
+	"golang.org/x/sync/singleflight"
+)
+
+// CachedUserModel is a read-through cache in front of another UserStore.
+// Concurrent misses for the same username are collapsed into a single
+// lookup against the wrapped store.
+type CachedUserModel struct {
+	Store UserStore
+	Cache cache.Cache
+
+	group singleflight.Group
+	// generation is bumped by every invalidation, so a lookup that raced
+	// with a write can tell that the user it read may be stale.
+	generation atomic.Uint64
+	hits       atomic.Int64
+	misses     atomic.Int64
+	errors     atomic.Int64
+}
+
+type CacheStats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+	Errors int64 `json:"errors"`
+}
+
+func NewCachedUserModel(store UserStore, c cache.Cache) *CachedUserModel {
+	return &CachedUserModel{
+		Store: store,
+		Cache: c,
+	}
+}
+
+func userCacheKey(username string) string {
+	return "user:" + username
+}
+
+func (u *CachedUserModel) Insert(user *User) (bool, error) {
+	created, err := u.Store.Insert(user)
+	if err != nil {
+		return false, err
+	}
+
+	u.invalidate(user.Username)
+	return created, nil
+}
+
+func (u *CachedUserModel) Get(username string) (*User, error) {
+	key := userCacheKey(username)
+
+	cached, err := u.Cache.Get(key)
+	if err == nil {
+		var user User
+		err = json.Unmarshal(cached, &user)
+		if err == nil {
+			u.hits.Add(1)
+			return &user, nil
+		}
+	}
+
+	// A broken cache must not take reads down with it, so any error other
+	// than a plain miss is counted and the lookup falls through to the store.
+	if !errors.Is(err, cache.ErrCacheMiss) {
+		u.errors.Add(1)
+	}
+	u.misses.Add(1)
+
+	v, err, _ := u.group.Do(key, func() (any, error) {
+		generation := u.generation.Load()
+
+		user, err := u.Store.Get(username)
+		if err != nil {
+			return nil, err
+		}
+
+		js, err := json.Marshal(user)
+		if err == nil {
+			err = u.Cache.Set(key, js)
+		}
+		if err != nil {
+			u.errors.Add(1)
+		}
+
+		// A write that invalidated the cache while the user was read may
+		// have deleted the key before the Set above, which would keep the
+		// old user cached until the TTL expires. The copy is dropped again
+		// instead, at the cost of a miss when the write was for another user.
+		if u.generation.Load() != generation {
+			err = u.Cache.Delete(key)
+			if err != nil {
+				u.errors.Add(1)
+			}
+		}
+
+		return user, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Callers that shared a flight must not share the same *User.
+	user := *v.(*User)
+	return &user, nil
+}
+
+// ListByBirthday is not cached; it goes straight to the wrapped store.
+func (u *CachedUserModel) ListByBirthday(dates []time.Time) ([]*User, error) {
+	return u.Store.ListByBirthday(dates)
+}
+
+func (u *CachedUserModel) Stats() CacheStats {
+	return CacheStats{
+		Hits:   u.hits.Load(),
+		Misses: u.misses.Load(),
+		Errors: u.errors.Load(),
+	}
+}
+
+// invalidate drops the cached copy of username after a write. The write has
+// already succeeded at this point, so a failing cache is only counted; the
+// stale entry then lives until its TTL expires.
+func (u *CachedUserModel) invalidate(username string) {
+	key := userCacheKey(username)
+
+	u.generation.Add(1)
+	u.group.Forget(key)
+
+	err := u.Cache.Delete(key)
+	if err != nil {
+		u.errors.Add(1)
+	}
+}
@@ -0,0 +1,245 @@
+package data
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ab0utbla-k/rvt-hello-app/internal/cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeUserStore struct {
+	mu    sync.Mutex
+	users map[string]User
+	gets  atomic.Int64
+
+	// release, when set, blocks Get after it has read the user until it
+	// is closed. gets is counted once the user has been read.
+	release chan struct{}
+}
+
+func newFakeUserStore() *fakeUserStore {
+	return &fakeUserStore{users: make(map[string]User)}
+}
+
+func (s *fakeUserStore) Insert(user *User) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, exists := s.users[user.Username]
+	s.users[user.Username] = *user
+	return !exists, nil
+}
+
+func (s *fakeUserStore) Get(username string) (*User, error) {
+	s.mu.Lock()
+	user, ok := s.users[username]
+	s.mu.Unlock()
+
+	s.gets.Add(1)
+
+	if s.release != nil {
+		<-s.release
+	}
+
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+	return &user, nil
+}
+
+func (s *fakeUserStore) ListByBirthday(dates []time.Time) ([]*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := birthdayKeys(dates)
+
+	var users []*User
+	for _, user := range s.users {
+		if slices.Contains(keys, user.DateOfBirth.Format("01-02")) {
+			users = append(users, &user)
+		}
+	}
+	return users, nil
+}
+
+func newTestLRU(t *testing.T) *cache.LRU {
+	t.Helper()
+
+	c, err := cache.NewLRU(10, time.Minute)
+	require.NoError(t, err)
+
+	return c
+}
+
+type failingCache struct{}
+
+func (failingCache) Get(string) ([]byte, error) { return nil, errors.New("connection refused") }
+func (failingCache) Set(string, []byte) error   { return errors.New("connection refused") }
+func (failingCache) Delete(string) error        { return errors.New("connection refused") }
+
+func TestCachedUserModel_ReadThrough(t *testing.T) {
+	t.Parallel()
+
+	store := newFakeUserStore()
+	users := NewCachedUserModel(store, newTestLRU(t))
+
+	dob := time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC)
+	_, err := users.Insert(&User{Username: "john", DateOfBirth: dob})
+	require.NoError(t, err)
+
+	for range 3 {
+		user, err := users.Get("john")
+		require.NoError(t, err)
+		assert.Equal(t, "john", user.Username)
+		assert.True(t, dob.Equal(user.DateOfBirth))
+	}
+
+	assert.Equal(t, int64(1), store.gets.Load())
+	assert.Equal(t, CacheStats{Hits: 2, Misses: 1}, users.Stats())
+}
+
+func TestCachedUserModel_NotFoundIsNotCached(t *testing.T) {
+	t.Parallel()
+
+	store := newFakeUserStore()
+	users := NewCachedUserModel(store, newTestLRU(t))
+
+	_, err := users.Get("ghost")
+	assert.ErrorIs(t, err, ErrRecordNotFound)
+
+	_, err = users.Get("ghost")
+	assert.ErrorIs(t, err, ErrRecordNotFound)
+
+	assert.Equal(t, int64(2), store.gets.Load())
+}
+
+func TestCachedUserModel_Invalidation(t *testing.T) {
+	t.Parallel()
+
+	store := newFakeUserStore()
+	users := NewCachedUserModel(store, newTestLRU(t))
+
+	original := time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC)
+	updated := time.Date(1995, 6, 15, 0, 0, 0, 0, time.UTC)
+
+	created, err := users.Insert(&User{Username: "john", DateOfBirth: original})
+	require.NoError(t, err)
+	assert.True(t, created)
+	_, err = users.Get("john")
+	require.NoError(t, err)
+
+	created, err = users.Insert(&User{Username: "john", DateOfBirth: updated})
+	require.NoError(t, err)
+	assert.False(t, created)
+
+	user, err := users.Get("john")
+	require.NoError(t, err)
+	assert.True(t, updated.Equal(user.DateOfBirth), "insert must invalidate the cached user")
+}
+
+func TestCachedUserModel_WriteDuringMiss(t *testing.T) {
+	t.Parallel()
+
+	original := time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC)
+	updated := time.Date(1995, 6, 15, 0, 0, 0, 0, time.UTC)
+
+	store := newFakeUserStore()
+	_, err := store.Insert(&User{Username: "john", DateOfBirth: original})
+	require.NoError(t, err)
+	store.release = make(chan struct{})
+
+	users := NewCachedUserModel(store, newTestLRU(t))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := users.Get("john")
+		assert.NoError(t, err)
+	}()
+
+	// The miss has read the original user and is held before caching it
+	// while the update commits and invalidates the cache.
+	require.Eventually(t, func() bool {
+		return store.gets.Load() == 1
+	}, time.Second, time.Millisecond)
+
+	_, err = users.Insert(&User{Username: "john", DateOfBirth: updated})
+	require.NoError(t, err)
+
+	close(store.release)
+	<-done
+
+	user, err := users.Get("john")
+	require.NoError(t, err)
+	assert.True(t, updated.Equal(user.DateOfBirth), "a miss that raced with a write must not cache the old user")
+}
+
+func TestCachedUserModel_CollapsesConcurrentMisses(t *testing.T) {
+	t.Parallel()
+
+	store := newFakeUserStore()
+	_, err := store.Insert(&User{Username: "john", DateOfBirth: time.Now().AddDate(-30, 0, 0)})
+	require.NoError(t, err)
+	store.release = make(chan struct{})
+
+	users := NewCachedUserModel(store, newTestLRU(t))
+
+	const callers = 10
+
+	var wg sync.WaitGroup
+	results := make(chan *User, callers)
+
+	for range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			user, err := users.Get("john")
+			assert.NoError(t, err)
+			results <- user
+		}()
+	}
+
+	// Wait until every caller has missed the cache, then give them a moment
+	// to join the in-flight lookup before letting it complete.
+	require.Eventually(t, func() bool {
+		return users.Stats().Misses == callers
+	}, time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	close(store.release)
+
+	wg.Wait()
+	close(results)
+
+	assert.Equal(t, int64(1), store.gets.Load())
+
+	seen := make(map[*User]bool)
+	for user := range results {
+		assert.Equal(t, "john", user.Username)
+		assert.False(t, seen[user], "callers must not share a *User")
+		seen[user] = true
+	}
+}
+
+func TestCachedUserModel_FailingCacheFallsThrough(t *testing.T) {
+	t.Parallel()
+
+	store := newFakeUserStore()
+	users := NewCachedUserModel(store, failingCache{})
+
+	_, err := users.Insert(&User{Username: "john", DateOfBirth: time.Now().AddDate(-30, 0, 0)})
+	require.NoError(t, err)
+
+	user, err := users.Get("john")
+	require.NoError(t, err)
+	assert.Equal(t, "john", user.Username)
+
+	stats := users.Stats()
+	assert.Equal(t, int64(1), stats.Misses)
+	assert.Equal(t, int64(3), stats.Errors, "delete on insert, get and set should all be counted")
+}
@@ -0,0 +1,106 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+type SQLiteUserModel struct {
+	DB *sql.DB
+}
+
+// Insert looks the user up before the upsert, in the same transaction, as
+// SQLite cannot tell an insert from an update afterwards. The event is
+// recorded in that transaction too.
+func (u SQLiteUserModel) Insert(user *User) (bool, error) {
+	query := `
+        INSERT INTO users (username, date_of_birth, locale, email, time_zone)
+		VALUES (?, ?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''))
+		ON CONFLICT (username) DO UPDATE
+		SET date_of_birth = excluded.date_of_birth, locale = excluded.locale, email = excluded.email, time_zone = excluded.time_zone`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := u.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE username = ?)", user.Username).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = tx.ExecContext(ctx, query, user.Username, user.DateOfBirth.UTC(), user.Locale, user.Email, user.TimeZone)
+	if err != nil {
+		return false, err
+	}
+
+	event, err := newUserEvent(newEventID(), !exists, user)
+	if err != nil {
+		return false, err
+	}
+
+	err = insertEvent(ctx, tx, sqliteOutboxInsertQuery, event)
+	if err != nil {
+		return false, err
+	}
+
+	return !exists, tx.Commit()
+}
+
+func (u SQLiteUserModel) Get(username string) (*User, error) {
+	query := `
+        SELECT username, date_of_birth, COALESCE(locale, ''), COALESCE(email, ''), COALESCE(time_zone, '')
+		FROM users
+		WHERE username = ?`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var user User
+	err := u.DB.QueryRowContext(ctx, query, username).Scan(
+		&user.Username,
+		&user.DateOfBirth,
+		&user.Locale,
+		&user.Email,
+		&user.TimeZone,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+// ListByBirthday matches on the month and day in the stored date of birth,
+// which starts with YYYY-MM-DD in UTC.
+func (u SQLiteUserModel) ListByBirthday(dates []time.Time) ([]*User, error) {
+	query := `
+        SELECT username, date_of_birth, COALESCE(locale, ''), COALESCE(email, ''), COALESCE(time_zone, '')
+		FROM users
+		WHERE instr(?, substr(date_of_birth, 6, 5)) > 0
+		ORDER BY username`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := u.DB.QueryContext(ctx, query, strings.Join(birthdayKeys(dates), " "))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanUsers(rows)
+}
@@ -1,9 +1,11 @@
 package data
 
 import (
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/ab0utbla-k/rvt-hello-app/internal/validator"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -31,19 +33,19 @@ func TestUser_GetBirthdayMessage(t *testing.T) {
 			name:        "birthday tomorrow",
 			username:    "alice",
 			dateOfBirth: time.Date(1990, tomorrow.Month(), tomorrow.Day(), 0, 0, 0, 0, time.UTC),
-			expectMsg:   "Hello, alice! Your birthday is in 1 day(s)",
+			expectMsg:   "Hello, alice! Your birthday is in 1 day",
 		},
 		{
 			name:        "birthday yesterday (next year)",
 			username:    "bob",
 			dateOfBirth: time.Date(1990, yesterday.Month(), yesterday.Day(), 0, 0, 0, 0, time.UTC),
-			expectMsg:   "Hello, bob! Your birthday is in 364 day(s)",
+			expectMsg:   "Hello, bob! Your birthday is in 364 days",
 		},
 		{
 			name:        "birthday in 10 days",
 			username:    "carol",
 			dateOfBirth: time.Date(1990, today.Month(), today.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, 10),
-			expectMsg:   "Hello, carol! Your birthday is in 10 day(s)",
+			expectMsg:   "Hello, carol! Your birthday is in 10 days",
 		},
 		{
 			name:        "birthday in different month",
@@ -62,11 +64,11 @@ func TestUser_GetBirthdayMessage(t *testing.T) {
 				DateOfBirth: tt.dateOfBirth,
 			}
 
-			message := user.GetBirthdayMessage()
+			message := user.GetBirthdayMessage("en")
 
 			if tt.name == "birthday in different month" {
 				assert.Contains(t, message, tt.expectMsg)
-				assert.Contains(t, message, "day(s)")
+				assert.Contains(t, message, "days")
 			} else {
 				assert.Equal(t, tt.expectMsg, message)
 			}
@@ -83,8 +85,224 @@ func TestUser_GetBirthdayMessage_LeapYear(t *testing.T) {
 		DateOfBirth: time.Date(2000, 2, 29, 0, 0, 0, 0, time.UTC),
 	}
 
-	message := user.GetBirthdayMessage()
+	message := user.GetBirthdayMessage("en")
 
 	assert.Contains(t, message, "Hello, leapyear!")
 	assert.Contains(t, message, "birthday")
 }
+
+func TestUser_GetBirthdayMessage_Localized(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		locale    string
+		daysAhead int
+		expectMsg string
+	}{
+		{name: "german today", locale: "de", daysAhead: 0, expectMsg: "Hallo, john! Alles Gute zum Geburtstag!"},
+		{name: "german one day", locale: "de", daysAhead: 1, expectMsg: "Hallo, john! Dein Geburtstag ist in 1 Tag"},
+		{name: "german many days", locale: "de", daysAhead: 5, expectMsg: "Hallo, john! Dein Geburtstag ist in 5 Tagen"},
+		{name: "russian today", locale: "ru", daysAhead: 0, expectMsg: "Привет, john! С днём рождения!"},
+		{name: "russian one day", locale: "ru", daysAhead: 1, expectMsg: "Привет, john! До твоего дня рождения 1 день"},
+		{name: "russian few days", locale: "ru", daysAhead: 3, expectMsg: "Привет, john! До твоего дня рождения 3 дня"},
+		{name: "russian many days", locale: "ru", daysAhead: 11, expectMsg: "Привет, john! До твоего дня рождения 11 дней"},
+		{name: "unsupported locale", locale: "fr", daysAhead: 2, expectMsg: "Hello, john! Your birthday is in 2 days"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			user := &User{
+				Username:    "john",
+				DateOfBirth: time.Date(1990, today.Month(), today.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, tt.daysAhead),
+			}
+
+			assert.Equal(t, tt.expectMsg, user.GetBirthdayMessage(tt.locale))
+		})
+	}
+}
+
+func TestUser_Birthday(t *testing.T) {
+	t.Parallel()
+
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name        string
+		now         time.Time
+		dateOfBirth time.Time
+		expect      Birthday
+		weekday     time.Weekday
+	}{
+		{
+			name:        "birthday today",
+			now:         time.Date(2026, time.October, 18, 15, 30, 0, 0, time.UTC),
+			dateOfBirth: date(1996, time.October, 18),
+			expect:      Birthday{Next: date(2026, time.October, 18), DaysUntil: 0, AgeTurning: 30, IsToday: true},
+			weekday:     time.Sunday,
+		},
+		{
+			name:        "birthday tomorrow",
+			now:         date(2026, time.October, 18),
+			dateOfBirth: date(1996, time.October, 19),
+			expect:      Birthday{Next: date(2026, time.October, 19), DaysUntil: 1, AgeTurning: 30},
+			weekday:     time.Monday,
+		},
+		{
+			name:        "birthday yesterday rolls over to next year",
+			now:         date(2026, time.October, 18),
+			dateOfBirth: date(1996, time.October, 17),
+			expect:      Birthday{Next: date(2027, time.October, 17), DaysUntil: 364, AgeTurning: 31},
+			weekday:     time.Sunday,
+		},
+		{
+			name:        "rollover across a leap day",
+			now:         date(2027, time.March, 1),
+			dateOfBirth: date(1990, time.February, 28),
+			expect:      Birthday{Next: date(2028, time.February, 28), DaysUntil: 364, AgeTurning: 38},
+			weekday:     time.Monday,
+		},
+		{
+			name:        "new year's eve to new year's day",
+			now:         date(2026, time.December, 31),
+			dateOfBirth: date(2000, time.January, 1),
+			expect:      Birthday{Next: date(2027, time.January, 1), DaysUntil: 1, AgeTurning: 27},
+			weekday:     time.Friday,
+		},
+		{
+			name:        "leap day birthday in a leap year",
+			now:         date(2028, time.February, 1),
+			dateOfBirth: date(2000, time.February, 29),
+			expect:      Birthday{Next: date(2028, time.February, 29), DaysUntil: 28, AgeTurning: 28},
+			weekday:     time.Tuesday,
+		},
+		{
+			name:        "leap day birthday is celebrated on March 1st otherwise",
+			now:         date(2026, time.February, 1),
+			dateOfBirth: date(2000, time.February, 29),
+			expect:      Birthday{Next: date(2026, time.March, 1), DaysUntil: 28, AgeTurning: 26},
+			weekday:     time.Sunday,
+		},
+		{
+			name:        "first birthday",
+			now:         date(2026, time.October, 18),
+			dateOfBirth: date(2025, time.December, 25),
+			expect:      Birthday{Next: date(2026, time.December, 25), DaysUntil: 68, AgeTurning: 1},
+			weekday:     time.Friday,
+		},
+		{
+			name:        "local date is used west of UTC",
+			now:         time.Date(2026, time.October, 18, 22, 0, 0, 0, time.FixedZone("UTC-7", -7*60*60)),
+			dateOfBirth: date(1996, time.October, 18),
+			expect:      Birthday{Next: date(2026, time.October, 18), DaysUntil: 0, AgeTurning: 30, IsToday: true},
+			weekday:     time.Sunday,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			user := &User{Username: "john", DateOfBirth: tt.dateOfBirth}
+
+			birthday := user.birthdayAt(tt.now)
+			assert.Equal(t, tt.expect, birthday)
+			assert.Equal(t, tt.weekday, birthday.Next.Weekday())
+		})
+	}
+}
+
+func TestUser_BirthdayAt_TimeZone(t *testing.T) {
+	t.Parallel()
+
+	// 23:30 UTC on October 17th is already October 18th in Berlin, but
+	// still October 17th in New York.
+	now := time.Date(2026, time.October, 17, 23, 30, 0, 0, time.UTC)
+	dateOfBirth := time.Date(1996, time.October, 18, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		timeZone        string
+		expectDaysUntil int
+	}{
+		{timeZone: "Europe/Berlin", expectDaysUntil: 0},
+		{timeZone: "America/New_York", expectDaysUntil: 1},
+		{timeZone: "UTC", expectDaysUntil: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.timeZone, func(t *testing.T) {
+			t.Parallel()
+
+			user := &User{Username: "john", DateOfBirth: dateOfBirth, TimeZone: tt.timeZone}
+			assert.Equal(t, tt.expectDaysUntil, user.BirthdayAt(now).DaysUntil)
+		})
+	}
+}
+
+func TestUser_Location(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "Asia/Tokyo", (&User{TimeZone: "Asia/Tokyo"}).Location().String())
+	assert.Equal(t, time.Local, (&User{}).Location())
+	assert.Equal(t, time.Local, (&User{TimeZone: "Nowhere/Atlantis"}).Location(), "zones missing from the database fall back to the server's")
+}
+
+func TestValidateUser_EmailAndTimeZone(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		email       string
+		timeZone    string
+		expectField string
+	}{
+		{name: "neither", expectField: ""},
+		{name: "valid", email: "john@example.com", timeZone: "Europe/Berlin"},
+		{name: "email without domain", email: "john", expectField: "email"},
+		{name: "email with display name", email: "John <john@example.com>", expectField: "email"},
+		{name: "email too long", email: strings.Repeat("a", 250) + "@example.com", expectField: "email"},
+		{name: "unknown time zone", timeZone: "Mars/Olympus", expectField: "timeZone"},
+		{name: "server time zone", timeZone: "Local", expectField: "timeZone"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			v := validator.New()
+			ValidateUser(v, &User{
+				Username:    "john",
+				DateOfBirth: time.Date(1990, time.January, 1, 0, 0, 0, 0, time.UTC),
+				Email:       tt.email,
+				TimeZone:    tt.timeZone,
+			})
+
+			if tt.expectField == "" {
+				assert.True(t, v.Valid(), v.Errors)
+				return
+			}
+
+			assert.Len(t, v.Errors, 1)
+			assert.Contains(t, v.Errors, tt.expectField)
+		})
+	}
+}
+
+func TestBirthdayKeys(t *testing.T) {
+	t.Parallel()
+
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	}
+
+	assert.Equal(t, []string{"05-09", "05-10"}, birthdayKeys([]time.Time{date(2026, time.May, 9), date(2026, time.May, 10)}))
+	assert.Equal(t, []string{"03-01", "02-29"}, birthdayKeys([]time.Time{date(2026, time.March, 1)}), "February 29th birthdays fall on March 1st outside leap years")
+	assert.Equal(t, []string{"02-29", "03-01"}, birthdayKeys([]time.Time{date(2028, time.February, 29), date(2028, time.March, 1)}))
+}
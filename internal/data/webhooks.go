@@ -0,0 +1,422 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"encoding/json"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ab0utbla-k/rvt-hello-app/internal/validator"
+	"github.com/lib/pq"
+)
+
+// WebhookEvents are the events a webhook can subscribe to.
+var WebhookEvents = []string{EventUserCreated, EventUserUpdated, EventUserBirthday}
+
+// WebhookSecretPrefix starts every generated signing secret.
+const WebhookSecretPrefix = "whsec_"
+
+// Statuses of a webhook delivery. A pending delivery is retried until it
+// is delivered or runs out of attempts and fails.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook subscribes a URL to events. Payloads sent to it are signed with
+// Secret, which is only returned when the webhook is created.
+type Webhook struct {
+	ID        int64     `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"createdAt"`
+	Secret    string    `json:"secret,omitempty"`
+}
+
+// GenerateWebhookSecret returns a new signing secret with 160 bits of
+// randomness.
+func GenerateWebhookSecret() (string, error) {
+	b := make([]byte, 20)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return WebhookSecretPrefix + strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b)), nil
+}
+
+func ValidateWebhook(v *validator.Validator, w *Webhook) {
+	v.Check(validWebhookURL(w.URL), "url", validator.FieldError{
+		Code:    "url.invalid",
+		Message: "must be an absolute http or https URL",
+	})
+
+	v.Check(len(w.Secret) >= 16, "secret", validator.FieldError{
+		Code:    "secret.too_short",
+		Message: "must be at least 16 bytes long",
+		Params:  map[string]any{"min": 16},
+	})
+	v.Check(len(w.Secret) <= 255, "secret", validator.FieldError{
+		Code:    "secret.too_long",
+		Message: "must not be more than 255 bytes long",
+		Params:  map[string]any{"max": 255},
+	})
+
+	v.Check(len(w.Events) > 0, "events", validator.FieldError{
+		Code:    "events.required",
+		Message: "must contain at least one event",
+	})
+	for _, event := range w.Events {
+		v.Check(slices.Contains(WebhookEvents, event), "events", validator.FieldError{
+			Code:    "events.unsupported",
+			Message: "must only contain " + strings.Join(WebhookEvents, ", "),
+			Params:  map[string]any{"supported": strings.Join(WebhookEvents, ", ")},
+		})
+	}
+}
+
+func validWebhookURL(s string) bool {
+	if len(s) > 2048 {
+		return false
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && u.User == nil
+}
+
+// WebhookDelivery is an event queued for a webhook, kept with every attempt
+// made to deliver it.
+type WebhookDelivery struct {
+	ID        int64           `json:"id"`
+	WebhookID int64           `json:"webhookId"`
+	EventID   string          `json:"eventId"`
+	Event     string          `json:"event"`
+	Payload   json.RawMessage `json:"payload"`
+	Status    string          `json:"status"`
+
+	// NextAttemptAt is when a pending delivery is tried next.
+	NextAttemptAt *time.Time        `json:"nextAttemptAt,omitempty"`
+	CreatedAt     time.Time         `json:"createdAt"`
+	DeliveredAt   *time.Time        `json:"deliveredAt,omitempty"`
+	Attempts      []*WebhookAttempt `json:"attempts"`
+
+	// Tries counts the attempts since the delivery was last queued. URL and
+	// Secret are those of the webhook, set by ClaimDue.
+	Tries  int    `json:"-"`
+	URL    string `json:"-"`
+	Secret string `json:"-"`
+}
+
+// WebhookAttempt is one try at a delivery. StatusCode is zero when no
+// response was received.
+type WebhookAttempt struct {
+	AttemptedAt time.Time `json:"attemptedAt"`
+	StatusCode  int       `json:"statusCode,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	DurationMS  int64     `json:"durationMs"`
+}
+
+type WebhookModel struct {
+	DB *sql.DB
+}
+
+func (m WebhookModel) Insert(w *Webhook) error {
+	query := `
+        INSERT INTO webhooks (url, secret, events)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, w.URL, w.Secret, pq.Array(w.Events)).Scan(&w.ID, &w.CreatedAt)
+}
+
+// List returns every webhook, oldest first, without its secret.
+func (m WebhookModel) List() ([]*Webhook, error) {
+	query := "SELECT id, url, events, created_at FROM webhooks ORDER BY id"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []*Webhook{}
+	for rows.Next() {
+		var w Webhook
+		err := rows.Scan(&w.ID, &w.URL, pq.Array(&w.Events), &w.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, &w)
+	}
+
+	return webhooks, rows.Err()
+}
+
+// Delete removes the webhook with id along with its deliveries.
+func (m WebhookModel) Delete(id int64) error {
+	query := "DELETE FROM webhooks WHERE id = $1"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	return requireRowsAffected(result)
+}
+
+// Enqueue queues a delivery of the event to every webhook subscribed to
+// it, and returns how many were queued. An event ID is only queued once per
+// webhook, so publishing the same event again is harmless.
+func (m WebhookModel) Enqueue(eventID, event string, payload []byte) (int64, error) {
+	query := `
+        INSERT INTO webhook_deliveries (webhook_id, event_id, event, payload)
+		SELECT id, $1, $2, $3 FROM webhooks WHERE $2 = ANY(events)
+		ON CONFLICT (webhook_id, event_id) DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, eventID, event, payload)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// ClaimDue returns up to limit pending deliveries that are due, and leases
+// them for lease: they are not returned again until the lease runs out, so
+// a delivery is retried if the instance that claimed it stops before
+// recording an attempt.
+func (m WebhookModel) ClaimDue(limit int, lease time.Duration) ([]*WebhookDelivery, error) {
+	query := `
+        UPDATE webhook_deliveries d
+		SET next_attempt_at = $2
+		FROM webhooks w
+		WHERE w.id = d.webhook_id AND d.id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING d.id, d.webhook_id, d.event_id, d.event, d.payload, d.tries, d.created_at, w.url, w.secret`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, limit, time.Now().Add(lease))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanClaimedDeliveries(rows)
+}
+
+// RecordAttempt stores a and the outcome of d: its Status, Tries,
+// NextAttemptAt and DeliveredAt.
+func (m WebhookModel) RecordAttempt(d *WebhookDelivery, a *WebhookAttempt) error {
+	insert := `
+        INSERT INTO webhook_attempts (delivery_id, attempted_at, status_code, error, duration_ms)
+		VALUES ($1, $2, NULLIF($3, 0), NULLIF($4, ''), $5)`
+
+	update := `
+        UPDATE webhook_deliveries
+		SET status = $2, tries = $3, next_attempt_at = COALESCE($4, next_attempt_at), delivered_at = $5
+		WHERE id = $1`
+
+	return recordAttempt(m.DB, insert, update, d, a, d.NextAttemptAt)
+}
+
+// ListDeliveries returns the latest limit deliveries to the webhook with
+// id, newest first, or ErrRecordNotFound when there is no such webhook.
+func (m WebhookModel) ListDeliveries(webhookID int64, limit int) ([]*WebhookDelivery, error) {
+	deliveries := `
+        SELECT id, webhook_id, event_id, event, payload, status, next_attempt_at, created_at, delivered_at
+		FROM webhook_deliveries
+		WHERE webhook_id = $1
+		ORDER BY id DESC
+		LIMIT $2`
+
+	attempts := `
+        SELECT delivery_id, attempted_at, COALESCE(status_code, 0), COALESCE(error, ''), duration_ms
+		FROM webhook_attempts
+		WHERE delivery_id IN (SELECT id FROM webhook_deliveries WHERE webhook_id = $1 ORDER BY id DESC LIMIT $2)
+		ORDER BY id`
+
+	exists := "SELECT EXISTS (SELECT 1 FROM webhooks WHERE id = $1)"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, deliveries, webhookID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []*WebhookDelivery{}
+	for rows.Next() {
+		var d WebhookDelivery
+		err := rows.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.Event, &d.Payload, &d.Status, &d.NextAttemptAt, &d.CreatedAt, &d.DeliveredAt)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, &d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return attachAttempts(ctx, m.DB, exists, attempts, webhookID, limit, list)
+}
+
+// Redeliver queues the delivery again with a fresh set of attempts, whatever
+// its status. Earlier attempts are kept.
+func (m WebhookModel) Redeliver(webhookID, deliveryID int64) error {
+	query := `
+        UPDATE webhook_deliveries
+		SET status = 'pending', tries = 0, next_attempt_at = NOW(), delivered_at = NULL
+		WHERE id = $1 AND webhook_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, deliveryID, webhookID)
+	if err != nil {
+		return err
+	}
+
+	return requireRowsAffected(result)
+}
+
+func scanClaimedDeliveries(rows *sql.Rows) ([]*WebhookDelivery, error) {
+	deliveries := []*WebhookDelivery{}
+
+	for rows.Next() {
+		d := WebhookDelivery{Status: DeliveryPending}
+
+		err := rows.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.Event, &d.Payload, &d.Tries, &d.CreatedAt, &d.URL, &d.Secret)
+		if err != nil {
+			return nil, err
+		}
+
+		deliveries = append(deliveries, &d)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// recordAttempt runs insert and update in one transaction. next is the
+// delivery's next attempt time as the backend stores it.
+func recordAttempt(db *sql.DB, insert, update string, d *WebhookDelivery, a *WebhookAttempt, next any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, insert, d.ID, a.AttemptedAt.UTC(), a.StatusCode, a.Error, a.DurationMS)
+	if err != nil {
+		return err
+	}
+
+	var deliveredAt *time.Time
+	if d.DeliveredAt != nil {
+		t := d.DeliveredAt.UTC()
+		deliveredAt = &t
+	}
+
+	_, err = tx.ExecContext(ctx, update, d.ID, d.Status, d.Tries, next, deliveredAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// attachAttempts adds their attempts to deliveries, which were listed with
+// the same webhook ID and limit. An empty list is only returned when the
+// webhook exists.
+func attachAttempts(ctx context.Context, db *sql.DB, exists, query string, webhookID int64, limit int, deliveries []*WebhookDelivery) ([]*WebhookDelivery, error) {
+	if len(deliveries) == 0 {
+		var found bool
+		err := db.QueryRowContext(ctx, exists, webhookID).Scan(&found)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return nil, ErrRecordNotFound
+		}
+		return deliveries, nil
+	}
+
+	byID := make(map[int64]*WebhookDelivery, len(deliveries))
+	for _, d := range deliveries {
+		d.Attempts = []*WebhookAttempt{}
+		if d.Status != DeliveryPending {
+			d.NextAttemptAt = nil
+		}
+		byID[d.ID] = d
+	}
+
+	rows, err := db.QueryContext(ctx, query, webhookID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			deliveryID int64
+			a          WebhookAttempt
+		)
+		err := rows.Scan(&deliveryID, &a.AttemptedAt, &a.StatusCode, &a.Error, &a.DurationMS)
+		if err != nil {
+			return nil, err
+		}
+
+		if d, ok := byID[deliveryID]; ok {
+			d.Attempts = append(d.Attempts, &a)
+		}
+	}
+
+	return deliveries, rows.Err()
+}
+
+func requireRowsAffected(result sql.Result) error {
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,212 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// SQLiteWebhookModel stores events as a space-separated list and the next
+// attempt time in Unix milliseconds, so that due deliveries can be found by
+// comparing integers.
+type SQLiteWebhookModel struct {
+	DB *sql.DB
+}
+
+func (m SQLiteWebhookModel) Insert(w *Webhook) error {
+	query := `
+        INSERT INTO webhooks (url, secret, events, created_at)
+		VALUES (?, ?, ?, ?)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	createdAt := time.Now().UTC().Truncate(time.Second)
+
+	result, err := m.DB.ExecContext(ctx, query, w.URL, w.Secret, strings.Join(w.Events, " "), createdAt)
+	if err != nil {
+		return err
+	}
+
+	w.ID, err = result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	w.CreatedAt = createdAt
+
+	return nil
+}
+
+func (m SQLiteWebhookModel) List() ([]*Webhook, error) {
+	query := "SELECT id, url, events, created_at FROM webhooks ORDER BY id"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []*Webhook{}
+	for rows.Next() {
+		var (
+			w      Webhook
+			events string
+		)
+		err := rows.Scan(&w.ID, &w.URL, &events, &w.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		w.Events = strings.Fields(events)
+		webhooks = append(webhooks, &w)
+	}
+
+	return webhooks, rows.Err()
+}
+
+func (m SQLiteWebhookModel) Delete(id int64) error {
+	query := "DELETE FROM webhooks WHERE id = ?"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	return requireRowsAffected(result)
+}
+
+func (m SQLiteWebhookModel) Enqueue(eventID, event string, payload []byte) (int64, error) {
+	query := `
+        INSERT INTO webhook_deliveries (webhook_id, event_id, event, payload, next_attempt_at, created_at)
+		SELECT id, ?, ?, ?, ?, ? FROM webhooks WHERE instr(' ' || events || ' ', ' ' || ? || ' ') > 0
+		ON CONFLICT (webhook_id, event_id) DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	now := time.Now().UTC()
+
+	result, err := m.DB.ExecContext(ctx, query, eventID, event, payload, now.UnixMilli(), now.Truncate(time.Second), event)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// ClaimDue does without row locks, as SQLite allows a single writer.
+func (m SQLiteWebhookModel) ClaimDue(limit int, lease time.Duration) ([]*WebhookDelivery, error) {
+	query := `
+        UPDATE webhook_deliveries
+		SET next_attempt_at = ?
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= ?
+			ORDER BY next_attempt_at
+			LIMIT ?
+		)
+		RETURNING id, webhook_id, event_id, event, payload, tries, created_at,
+			(SELECT url FROM webhooks w WHERE w.id = webhook_deliveries.webhook_id),
+			(SELECT secret FROM webhooks w WHERE w.id = webhook_deliveries.webhook_id)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	now := time.Now()
+
+	rows, err := m.DB.QueryContext(ctx, query, now.Add(lease).UnixMilli(), now.UnixMilli(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanClaimedDeliveries(rows)
+}
+
+func (m SQLiteWebhookModel) RecordAttempt(d *WebhookDelivery, a *WebhookAttempt) error {
+	insert := `
+        INSERT INTO webhook_attempts (delivery_id, attempted_at, status_code, error, duration_ms)
+		VALUES (?, ?, NULLIF(?, 0), NULLIF(?, ''), ?)`
+
+	update := `
+        UPDATE webhook_deliveries
+		SET status = ?2, tries = ?3, next_attempt_at = COALESCE(?4, next_attempt_at), delivered_at = ?5
+		WHERE id = ?1`
+
+	var next *int64
+	if d.NextAttemptAt != nil {
+		ms := d.NextAttemptAt.UnixMilli()
+		next = &ms
+	}
+
+	return recordAttempt(m.DB, insert, update, d, a, next)
+}
+
+func (m SQLiteWebhookModel) ListDeliveries(webhookID int64, limit int) ([]*WebhookDelivery, error) {
+	deliveries := `
+        SELECT id, webhook_id, event_id, event, payload, status, next_attempt_at, created_at, delivered_at
+		FROM webhook_deliveries
+		WHERE webhook_id = ?
+		ORDER BY id DESC
+		LIMIT ?`
+
+	attempts := `
+        SELECT delivery_id, attempted_at, COALESCE(status_code, 0), COALESCE(error, ''), duration_ms
+		FROM webhook_attempts
+		WHERE delivery_id IN (SELECT id FROM webhook_deliveries WHERE webhook_id = ? ORDER BY id DESC LIMIT ?)
+		ORDER BY id`
+
+	exists := "SELECT EXISTS (SELECT 1 FROM webhooks WHERE id = ?)"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, deliveries, webhookID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []*WebhookDelivery{}
+	for rows.Next() {
+		var (
+			d    WebhookDelivery
+			next int64
+		)
+		err := rows.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.Event, &d.Payload, &d.Status, &next, &d.CreatedAt, &d.DeliveredAt)
+		if err != nil {
+			return nil, err
+		}
+		nextAttemptAt := time.UnixMilli(next).UTC()
+		d.NextAttemptAt = &nextAttemptAt
+		list = append(list, &d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return attachAttempts(ctx, m.DB, exists, attempts, webhookID, limit, list)
+}
+
+func (m SQLiteWebhookModel) Redeliver(webhookID, deliveryID int64) error {
+	query := `
+        UPDATE webhook_deliveries
+		SET status = 'pending', tries = 0, next_attempt_at = ?, delivered_at = NULL
+		WHERE id = ? AND webhook_id = ?`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, time.Now().UnixMilli(), deliveryID, webhookID)
+	if err != nil {
+		return err
+	}
+
+	return requireRowsAffected(result)
+}
@@ -0,0 +1,182 @@
+// Package i18n holds the message catalogs for the languages the API speaks
+// and picks one for a request from its Accept-Language header.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// Default is the locale used when nothing better matches, and the fallback
+// for keys missing from another catalog.
+const Default = "en"
+
+// Supported lists the locales that have a catalog, Default first.
+var Supported = []string{"en", "de", "ru"}
+
+//go:embed locales/*.json
+var localesFS embed.FS
+
+// message is one catalog entry, keyed by CLDR plural category. Messages
+// that do not depend on a count only have the "other" form.
+type message map[string]string
+
+func (m *message) UnmarshalJSON(b []byte) error {
+	var text string
+	if err := json.Unmarshal(b, &text); err == nil {
+		*m = message{"other": text}
+		return nil
+	}
+
+	var forms map[string]string
+	if err := json.Unmarshal(b, &forms); err != nil {
+		return err
+	}
+
+	*m = forms
+	return nil
+}
+
+var (
+	catalogs = make(map[string]map[string]message)
+	matcher  language.Matcher
+
+	placeholderRX = regexp.MustCompile(`\{(\w+)\}`)
+)
+
+func init() {
+	tags := make([]language.Tag, 0, len(Supported))
+
+	for _, locale := range Supported {
+		b, err := localesFS.ReadFile(path.Join("locales", locale+".json"))
+		if err != nil {
+			panic(err)
+		}
+
+		var catalog map[string]message
+		if err := json.Unmarshal(b, &catalog); err != nil {
+			panic(fmt.Sprintf("i18n: parsing %s catalog: %v", locale, err))
+		}
+
+		catalogs[locale] = catalog
+		tags = append(tags, language.MustParse(locale))
+	}
+
+	matcher = language.NewMatcher(tags)
+}
+
+// Match returns the supported locale that best fits an Accept-Language
+// header value, or Default when none does.
+func Match(acceptLanguage string) string {
+	if strings.TrimSpace(acceptLanguage) == "" {
+		return Default
+	}
+
+	_, index := language.MatchStrings(matcher, acceptLanguage)
+	return Supported[index]
+}
+
+// Supports reports whether locale has a catalog.
+func Supports(locale string) bool {
+	_, ok := catalogs[locale]
+	return ok
+}
+
+// Has reports whether key exists in the Default catalog.
+func Has(key string) bool {
+	_, ok := catalogs[Default][key]
+	return ok
+}
+
+// T returns the message for key in locale, with {name} placeholders
+// replaced from args. Keys missing from locale fall back to Default, and
+// unknown keys are returned as is.
+func T(locale, key string, args map[string]any) string {
+	return render(lookup(locale, key)["other"], key, args)
+}
+
+// N is T for messages that vary by count, picking the plural form that the
+// locale's grammar uses for count. The count is available as {count}.
+func N(locale, key string, count int, args map[string]any) string {
+	forms := lookup(locale, key)
+
+	text, ok := forms[pluralCategory(locale, count)]
+	if !ok {
+		text = forms["other"]
+	}
+
+	withCount := map[string]any{"count": count}
+	for name, value := range args {
+		withCount[name] = value
+	}
+
+	return render(text, key, withCount)
+}
+
+func lookup(locale, key string) message {
+	if msg, ok := catalogs[locale][key]; ok {
+		return msg
+	}
+
+	return catalogs[Default][key]
+}
+
+func render(text, key string, args map[string]any) string {
+	if text == "" {
+		return key
+	}
+
+	return placeholderRX.ReplaceAllStringFunc(text, func(placeholder string) string {
+		value, ok := args[placeholder[1:len(placeholder)-1]]
+		if !ok {
+			return placeholder
+		}
+		return fmt.Sprint(value)
+	})
+}
+
+// pluralCategory returns the CLDR plural category of n for locale.
+func pluralCategory(locale string, n int) string {
+	if n < 0 {
+		n = -n
+	}
+
+	switch locale {
+	case "ru":
+		switch {
+		case n%10 == 1 && n%100 != 11:
+			return "one"
+		case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+			return "few"
+		default:
+			return "many"
+		}
+	default:
+		if n == 1 {
+			return "one"
+		}
+		return "other"
+	}
+}
+
+// Error is an error whose text comes from the catalog, so that it can be
+// shown to clients in their own language. Error() renders it in Default.
+type Error struct {
+	Key  string
+	Args map[string]any
+}
+
+func (e *Error) Error() string {
+	return e.Localize(Default)
+}
+
+// Localize renders the error in locale.
+func (e *Error) Localize(locale string) string {
+	return T(locale, e.Key, e.Args)
+}
@@ -0,0 +1,117 @@
+package i18n
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCatalogsHaveEveryKey(t *testing.T) {
+	t.Parallel()
+
+	for _, locale := range Supported {
+		for key := range catalogs[Default] {
+			_, ok := catalogs[locale][key]
+			assert.True(t, ok, "%s catalog is missing %q", locale, key)
+		}
+
+		for key := range catalogs[locale] {
+			_, ok := catalogs[Default][key]
+			assert.True(t, ok, "%s catalog has %q, which %s does not", locale, key, Default)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		acceptLanguage string
+		expect         string
+	}{
+		{"", "en"},
+		{"de", "de"},
+		{"de-AT", "de"},
+		{"ru-RU,ru;q=0.9,en-US;q=0.8", "ru"},
+		{"fr-FR, de;q=0.5", "de"},
+		{"en;q=0.4, de;q=0.8", "de"},
+		{"fr", "en"},
+		{"*", "en"},
+		{"not a tag", "en"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.acceptLanguage, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tt.expect, Match(tt.acceptLanguage))
+		})
+	}
+}
+
+func TestPluralCategory(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		locale string
+		n      int
+		expect string
+	}{
+		{"en", 0, "other"},
+		{"en", 1, "one"},
+		{"en", 2, "other"},
+		{"de", 1, "one"},
+		{"de", 21, "other"},
+		{"ru", 1, "one"},
+		{"ru", 2, "few"},
+		{"ru", 4, "few"},
+		{"ru", 5, "many"},
+		{"ru", 11, "many"},
+		{"ru", 12, "many"},
+		{"ru", 21, "one"},
+		{"ru", 22, "few"},
+		{"ru", 111, "many"},
+		{"ru", 364, "few"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expect, pluralCategory(tt.locale, tt.n), "%s %d", tt.locale, tt.n)
+	}
+}
+
+func TestN(t *testing.T) {
+	t.Parallel()
+
+	args := map[string]any{"username": "john"}
+
+	assert.Equal(t, "Hello, john! Your birthday is in 1 day", N("en", "birthday.in_days", 1, args))
+	assert.Equal(t, "Hello, john! Your birthday is in 3 days", N("en", "birthday.in_days", 3, args))
+	assert.Equal(t, "Hallo, john! Dein Geburtstag ist in 3 Tagen", N("de", "birthday.in_days", 3, args))
+	assert.Equal(t, "Привет, john! До твоего дня рождения 21 день", N("ru", "birthday.in_days", 21, args))
+	assert.Equal(t, "Привет, john! До твоего дня рождения 3 дня", N("ru", "birthday.in_days", 3, args))
+	assert.Equal(t, "Привет, john! До твоего дня рождения 5 дней", N("ru", "birthday.in_days", 5, args))
+}
+
+func TestT(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "the PATCH method is not supported for this resource",
+		T("en", "error.method_not_allowed", map[string]any{"method": "PATCH"}))
+	assert.Equal(t, "die Methode PATCH wird für diese Ressource nicht unterstützt",
+		T("de", "error.method_not_allowed", map[string]any{"method": "PATCH"}))
+
+	// Unknown locales fall back to Default, unknown keys to the key itself,
+	// and placeholders without an argument are left in place.
+	assert.Equal(t, "the requested resource could not be found", T("fr", "error.not_found", nil))
+	assert.Equal(t, "no.such.key", T("de", "no.such.key", nil))
+	assert.Equal(t, "the {method} method is not supported for this resource", T("en", "error.method_not_allowed", nil))
+}
+
+func TestError(t *testing.T) {
+	t.Parallel()
+
+	err := &Error{Key: "request.too_large", Args: map[string]any{"limit": 1024}}
+
+	assert.Equal(t, "body must not be larger than 1024 bytes", err.Error())
+	assert.Equal(t, "тело запроса не должно превышать 1024 байт", err.Localize("ru"))
+}
@@ -0,0 +1,262 @@
+// Package mailer sends plain text emails through an SMTP server.
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrMaybeSent is returned by Send when the connection failed after the
+// whole message was sent, but before the server confirmed it. The server
+// may have accepted it, so sending it again risks a duplicate.
+var ErrMaybeSent = errors.New("mail server did not confirm the message")
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+	// Language is sent as Content-Language when set.
+	Language string
+}
+
+type Mailer struct {
+	addr string
+	host string
+	// implicitTLS is set for smtps:// URLs. Otherwise STARTTLS is used
+	// when the server offers it.
+	implicitTLS bool
+	auth        smtp.Auth
+	from        *mail.Address
+	timeout     time.Duration
+}
+
+// New returns a Mailer for smtpURL, which is smtp://[user:password@]host[:port]
+// or smtps:// for a server that expects TLS from the start. The default
+// ports are 587 and 465. from is the sender, such as
+// "Birthdays <birthdays@example.com>".
+func New(smtpURL, from string) (*Mailer, error) {
+	u, err := url.Parse(smtpURL)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Mailer{host: u.Hostname(), timeout: 30 * time.Second}
+
+	port := u.Port()
+	switch u.Scheme {
+	case "smtp":
+		if port == "" {
+			port = "587"
+		}
+	case "smtps":
+		m.implicitTLS = true
+		if port == "" {
+			port = "465"
+		}
+	default:
+		return nil, fmt.Errorf("unsupported SMTP URL scheme %q, use smtp or smtps", u.Scheme)
+	}
+
+	if m.host == "" {
+		return nil, errors.New("SMTP URL has no host")
+	}
+	m.addr = net.JoinHostPort(m.host, port)
+
+	// PlainAuth refuses to send the password unencrypted, except to
+	// localhost.
+	if u.User != nil {
+		password, _ := u.User.Password()
+		m.auth = smtp.PlainAuth("", u.User.Username(), password, m.host)
+	}
+
+	m.from, err = mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("sender %q: %w", from, err)
+	}
+
+	return m, nil
+}
+
+// Send delivers msg to the server. Unless the error is ErrMaybeSent, a
+// failed message was not accepted and can be sent again. Cancelling ctx
+// abandons the conversation with the server.
+func (m *Mailer) Send(ctx context.Context, msg Message) error {
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return fmt.Errorf("recipient %q: %w", msg.To, err)
+	}
+
+	body, err := m.message(to, msg)
+	if err != nil {
+		return err
+	}
+
+	conn, err := m.dial(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Expiring the deadline wakes up whatever read or write is waiting on
+	// the server.
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	c, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if !m.implicitTLS {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			err = c.StartTLS(&tls.Config{ServerName: m.host})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if m.auth != nil {
+		err = c.Auth(m.auth)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = c.Mail(m.from.Address)
+	if err != nil {
+		return err
+	}
+
+	err = c.Rcpt(to.Address)
+	if err != nil {
+		return err
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(body)
+	if err != nil {
+		return err
+	}
+
+	// Close sends the end of the message and waits for the server's
+	// verdict. Only a reply that is missing leaves the outcome unknown.
+	err = w.Close()
+	if err != nil {
+		var reply *textproto.Error
+		if errors.As(err, &reply) {
+			return err
+		}
+		return fmt.Errorf("%w: %w", ErrMaybeSent, err)
+	}
+
+	// The message is accepted; a failing QUIT changes nothing.
+	_ = c.Quit()
+
+	return nil
+}
+
+func (m *Mailer) dial(ctx context.Context) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+
+	dialer := &net.Dialer{}
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	if m.implicitTLS {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: m.host}}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", m.addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", m.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The deadline covers the whole conversation, so a stalled server
+	// cannot hold up the caller.
+	err = conn.SetDeadline(time.Now().Add(m.timeout))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// message formats msg as a MIME message with a quoted-printable UTF-8 body.
+// The quoted-printable writer turns line breaks in the body into CRLF.
+func (m *Mailer) message(to *mail.Address, msg Message) ([]byte, error) {
+	id, err := m.messageID()
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+
+	header := func(name, value string) {
+		b.WriteString(name + ": " + value + "\r\n")
+	}
+
+	header("From", m.from.String())
+	header("To", to.String())
+	header("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", id)
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	header("Content-Transfer-Encoding", "quoted-printable")
+	if msg.Language != "" {
+		header("Content-Language", msg.Language)
+	}
+	b.WriteString("\r\n")
+
+	qp := quotedprintable.NewWriter(&b)
+	_, err = qp.Write([]byte(msg.Body))
+	if err != nil {
+		return nil, err
+	}
+
+	err = qp.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+func (m *Mailer) messageID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	_, domain, _ := strings.Cut(m.from.Address, "@")
+	return "<" + hex.EncodeToString(b) + "@" + domain + ">", nil
+}
@@ -0,0 +1,89 @@
+// Package outbox provides the sinks that domain events recorded in the
+// outbox are published to.
+package outbox
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+
+	"github.com/ab0utbla-k/rvt-hello-app/internal/data"
+)
+
+// Sink publishes domain events. An event is published again when the
+// dispatcher cannot mark it dispatched, so sinks must tolerate duplicates,
+// which share an event ID.
+type Sink interface {
+	Publish(e *data.OutboxEvent) error
+}
+
+// LogSink writes events to a logger.
+type LogSink struct {
+	Logger *slog.Logger
+}
+
+func (s LogSink) Publish(e *data.OutboxEvent) error {
+	s.Logger.Info("domain event", "event_id", e.EventID, "event", e.Type, "payload", string(e.Payload))
+	return nil
+}
+
+// WebhookSink queues events for delivery to the webhooks subscribed to
+// them. Queueing an event twice is a no-op.
+type WebhookSink struct {
+	Webhooks data.WebhookStore
+}
+
+func (s WebhookSink) Publish(e *data.OutboxEvent) error {
+	_, err := s.Webhooks.Enqueue(e.EventID, e.Type, e.Payload)
+	if err != nil {
+		return fmt.Errorf("webhook sink: %w", err)
+	}
+	return nil
+}
+
+// FileSink appends events to a file as JSON lines, one payload per line.
+type FileSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// OpenFileSink opens path for appending, creating it if needed.
+func OpenFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileSink{file: f}, nil
+}
+
+// Publish syncs the file after each event, so an event marked dispatched
+// is on disk.
+func (s *FileSink) Publish(e *data.OutboxEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	line := make([]byte, 0, len(e.Payload)+1)
+	line = append(line, e.Payload...)
+	line = append(line, '\n')
+
+	_, err := s.file.Write(line)
+	if err != nil {
+		return fmt.Errorf("file sink: %w", err)
+	}
+
+	err = s.file.Sync()
+	if err != nil {
+		return fmt.Errorf("file sink: %w", err)
+	}
+
+	return nil
+}
+
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.file.Close()
+}
@@ -0,0 +1,77 @@
+package outbox
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ab0utbla-k/rvt-hello-app/internal/data"
+	"github.com/ab0utbla-k/rvt-hello-app/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testEvent(eventID string) *data.OutboxEvent {
+	return &data.OutboxEvent{
+		EventID: eventID,
+		Type:    data.EventUserCreated,
+		Payload: []byte(`{"id":"` + eventID + `","type":"user.created"}`),
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+
+	sink, err := OpenFileSink(path)
+	require.NoError(t, err)
+	require.NoError(t, sink.Publish(testEvent("evt_1")))
+	require.NoError(t, sink.Close())
+
+	// Reopening appends rather than truncating.
+	sink, err = OpenFileSink(path)
+	require.NoError(t, err)
+	require.NoError(t, sink.Publish(testEvent("evt_2")))
+	require.NoError(t, sink.Close())
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, `{"id":"evt_1","type":"user.created"}`+"\n"+`{"id":"evt_2","type":"user.created"}`+"\n", string(b))
+
+	assert.Error(t, sink.Publish(testEvent("evt_3")), "publishing to a closed sink must fail")
+}
+
+func TestLogSink(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	sink := LogSink{Logger: slog.New(slog.NewTextHandler(&buf, nil))}
+
+	require.NoError(t, sink.Publish(testEvent("evt_1")))
+	assert.Contains(t, buf.String(), "event_id=evt_1")
+	assert.Contains(t, buf.String(), "event=user.created")
+}
+
+func TestWebhookSink(t *testing.T) {
+	t.Parallel()
+
+	models := data.NewSQLiteModels(testutils.SetupSQLiteTestDB(t))
+
+	webhook := &data.Webhook{URL: "https://hooks.example.com", Events: []string{data.EventUserCreated}, Secret: "whsec_0123456789abcdef"}
+	require.NoError(t, models.Webhooks.Insert(webhook))
+
+	sink := WebhookSink{Webhooks: models.Webhooks}
+
+	// A duplicate is queued once.
+	require.NoError(t, sink.Publish(testEvent("evt_1")))
+	require.NoError(t, sink.Publish(testEvent("evt_1")))
+
+	deliveries, err := models.Webhooks.ListDeliveries(webhook.ID, 10)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, "evt_1", deliveries[0].EventID)
+	assert.JSONEq(t, `{"id":"evt_1","type":"user.created"}`, string(deliveries[0].Payload))
+}
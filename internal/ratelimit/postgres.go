@@ -0,0 +1,134 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// Postgres keeps sliding window counters in the rate_limits table, shared by
+// every instance of the API. Expired counters are deleted by Run.
+type Postgres struct {
+	db    *sql.DB
+	rates map[string]Rate
+
+	// now is replaced in tests.
+	now func() time.Time
+}
+
+func NewPostgres(db *sql.DB, rates map[string]Rate) *Postgres {
+	return &Postgres{db: db, rates: rates, now: time.Now}
+}
+
+func (l *Postgres) Limited(group string) bool {
+	return l.rates[group].RPS > 0
+}
+
+func (l *Postgres) Allow(group, key string) (Result, error) {
+	r := l.rates[group]
+	if r.RPS <= 0 {
+		return Result{Allowed: true}, nil
+	}
+
+	w := newWindow(r)
+	now := l.now()
+	start := w.start(now).UTC()
+	previous := start.Add(-w.size)
+	key = group + ":" + key
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	query := "SELECT window_start, count FROM rate_limits WHERE key = $1 AND window_start IN ($2, $3)"
+
+	rows, err := l.db.QueryContext(ctx, query, key, previous, start)
+	if err != nil {
+		return Result{}, err
+	}
+	defer rows.Close()
+
+	var prev, curr int
+	for rows.Next() {
+		var (
+			windowStart time.Time
+			count       int
+		)
+
+		err := rows.Scan(&windowStart, &count)
+		if err != nil {
+			return Result{}, err
+		}
+
+		if windowStart.Equal(start) {
+			curr = count
+		} else {
+			prev = count
+		}
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return Result{}, err
+	}
+
+	// Nothing is counted in the previous window any more, so only the
+	// current one has to be checked and counted atomically. The upsert
+	// writes no row when the window is full.
+	query = `
+        INSERT INTO rate_limits AS r (key, window_start, count, expires_at)
+		SELECT $1::text, $2::timestamptz, 1, $3::timestamptz
+		WHERE 1 <= $4::float8
+		ON CONFLICT (key, window_start) DO UPDATE SET count = r.count + 1
+		WHERE r.count + 1 <= $4::float8
+		RETURNING count`
+
+	err = l.db.QueryRowContext(ctx, query, key, start, start.Add(2*w.size), w.room(now, prev)).Scan(&curr)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return w.result(now, false, prev, curr), nil
+		default:
+			return Result{}, err
+		}
+	}
+
+	return w.result(now, true, prev, curr), nil
+}
+
+// Run deletes expired counters every interval until ctx is done. A failed
+// delete is logged and retried on the next tick.
+func (l *Postgres) Run(ctx context.Context, interval time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := l.deleteExpired(ctx)
+			if err != nil {
+				logger.Error("deleting expired rate limits", "error", err.Error())
+				continue
+			}
+
+			if n > 0 {
+				logger.Info("deleted expired rate limits", "count", n)
+			}
+		}
+	}
+}
+
+func (l *Postgres) deleteExpired(ctx context.Context) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := l.db.ExecContext(ctx, "DELETE FROM rate_limits WHERE expires_at < NOW()")
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,85 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/ab0utbla-k/rvt-hello-app/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostgres(t *testing.T) {
+	db := testutils.SetupTestDB(t)
+
+	rates := map[string]Rate{"write": {RPS: 0.5, Burst: 3}}
+
+	// Two instances share the counters. Their clock runs ahead of the
+	// database's, so no counter expires before the test expires it.
+	now := time.Now().Add(time.Hour).Truncate(time.Minute)
+	first, second := NewPostgres(db, rates), NewPostgres(db, rates)
+	first.now = func() time.Time { return now }
+	second.now = func() time.Time { return now }
+
+	assert.True(t, allow(t, first, "write", "1.2.3.4").Allowed)
+	assert.True(t, allow(t, second, "write", "1.2.3.4").Allowed)
+
+	result := allow(t, first, "write", "1.2.3.4")
+	require.True(t, result.Allowed)
+	assert.Equal(t, 3, result.Limit)
+	assert.Equal(t, 0, result.Remaining)
+
+	result = allow(t, second, "write", "1.2.3.4")
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 8*time.Second, result.RetryAfter)
+
+	assert.True(t, allow(t, second, "write", "5.6.7.8").Allowed, "other clients have their own counters")
+	assert.True(t, allow(t, second, "admin", "1.2.3.4").Allowed, "groups without a rate are unlimited")
+
+	now = now.Add(6 * time.Second)
+	assert.False(t, allow(t, first, "write", "1.2.3.4").Allowed, "the previous window still counts")
+
+	now = now.Add(2 * time.Second)
+	assert.True(t, allow(t, first, "write", "1.2.3.4").Allowed)
+	assert.False(t, allow(t, first, "write", "1.2.3.4").Allowed)
+
+	_, err := db.Exec("UPDATE rate_limits SET expires_at = NOW() - interval '1 second' WHERE window_start < $1", now.Add(-6*time.Second))
+	require.NoError(t, err)
+	n, err := first.deleteExpired(context.Background())
+	require.NoError(t, err)
+	assert.Positive(t, n)
+
+	var count int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM rate_limits").Scan(&count))
+	assert.Equal(t, 1, count)
+}
+
+func TestPostgres_RunLogsFailures(t *testing.T) {
+	t.Parallel()
+
+	// A closed pool fails every delete without needing a database.
+	db, err := sql.Open("sqlite", ":memory:")
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		NewPostgres(db, nil).Run(ctx, time.Millisecond, logger)
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	<-done
+
+	assert.Contains(t, buf.String(), "deleting expired rate limits")
+	assert.Contains(t, buf.String(), "database is closed")
+}
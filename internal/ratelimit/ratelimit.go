@@ -0,0 +1,148 @@
+// Package ratelimit limits requests per client and route group, either in
+// process or in a store shared by every instance of the API.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Rate is a token bucket: RPS tokens are added per second, up to Burst. A
+// zero RPS leaves the group unlimited.
+type Rate struct {
+	RPS   float64
+	Burst int
+}
+
+// Result describes the bucket after a request was counted.
+type Result struct {
+	Allowed bool
+	// Limit is the bucket size and Remaining the whole tokens left in it.
+	Limit     int
+	Remaining int
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is how long until the next request would be allowed,
+	// zero when Allowed.
+	RetryAfter time.Duration
+}
+
+// Limiter counts requests per route group and client key. Allow returns an
+// error only when a shared store cannot be reached.
+type Limiter interface {
+	Limited(group string) bool
+	Allow(group, key string) (Result, error)
+}
+
+type client struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// Memory holds one token bucket per group and client key in memory, so
+// every instance of the API limits on its own.
+type Memory struct {
+	rates map[string]Rate
+
+	mu      sync.Mutex
+	clients map[string]*client
+
+	// now is replaced in tests.
+	now func() time.Time
+}
+
+// NewMemory returns a Memory limiter with the given rate for each route
+// group.
+func NewMemory(rates map[string]Rate) *Memory {
+	return &Memory{
+		rates:   rates,
+		clients: make(map[string]*client),
+		now:     time.Now,
+	}
+}
+
+// Limited reports whether requests in group are limited at all.
+func (l *Memory) Limited(group string) bool {
+	return l.rates[group].RPS > 0
+}
+
+// Allow takes a token from key's bucket in group. It never fails.
+func (l *Memory) Allow(group, key string) (Result, error) {
+	r := l.rates[group]
+	if r.RPS <= 0 {
+		return Result{Allowed: true}, nil
+	}
+
+	now := l.now()
+
+	l.mu.Lock()
+	c, ok := l.clients[group+"\x00"+key]
+	if !ok {
+		c = &client{limiter: rate.NewLimiter(rate.Limit(r.RPS), r.Burst)}
+		l.clients[group+"\x00"+key] = c
+	}
+	c.lastSeen = now
+	l.mu.Unlock()
+
+	allowed := c.limiter.AllowN(now, 1)
+	tokens := c.limiter.TokensAt(now)
+
+	result := Result{
+		Allowed:   allowed,
+		Limit:     r.Burst,
+		Remaining: max(0, int(math.Floor(tokens))),
+		Reset:     seconds((float64(r.Burst) - tokens) / r.RPS),
+	}
+	if !allowed {
+		result.RetryAfter = seconds((1 - tokens) / r.RPS)
+	}
+
+	return result, nil
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(max(0, s) * float64(time.Second))
+}
+
+// Cleanup forgets clients not seen for maxIdle whose bucket has refilled,
+// so forgetting them does not change what they are allowed.
+func (l *Memory) Cleanup(maxIdle time.Duration) {
+	now := l.now()
+	cutoff := now.Add(-maxIdle)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key, c := range l.clients {
+		if c.lastSeen.Before(cutoff) && c.limiter.TokensAt(now) >= float64(c.limiter.Burst()) {
+			delete(l.clients, key)
+		}
+	}
+}
+
+// Run calls Cleanup every interval until ctx is done.
+func (l *Memory) Run(ctx context.Context, interval, maxIdle time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			l.Cleanup(maxIdle)
+		}
+	}
+}
+
+// Len returns the number of clients being tracked.
+func (l *Memory) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return len(l.clients)
+}
@@ -0,0 +1,116 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestMemory(rates map[string]Rate) (*Memory, *time.Time) {
+	now := time.Date(2026, time.October, 18, 12, 0, 0, 0, time.UTC)
+
+	l := NewMemory(rates)
+	l.now = func() time.Time { return now }
+
+	return l, &now
+}
+
+// allow calls l.Allow and fails the test if it returns an error.
+func allow(t *testing.T, l Limiter, group, key string) Result {
+	t.Helper()
+
+	result, err := l.Allow(group, key)
+	require.NoError(t, err)
+
+	return result
+}
+
+func TestMemory_Allow(t *testing.T) {
+	t.Parallel()
+
+	l, now := newTestMemory(map[string]Rate{"write": {RPS: 0.5, Burst: 3}})
+
+	for remaining := 2; remaining >= 0; remaining-- {
+		result := allow(t, l, "write", "1.2.3.4")
+		require.True(t, result.Allowed)
+		assert.Equal(t, 3, result.Limit)
+		assert.Equal(t, remaining, result.Remaining)
+		assert.Equal(t, time.Duration(3-remaining)*2*time.Second, result.Reset)
+	}
+
+	result := allow(t, l, "write", "1.2.3.4")
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+	assert.Equal(t, 2*time.Second, result.RetryAfter)
+
+	assert.True(t, allow(t, l, "write", "5.6.7.8").Allowed, "other clients have their own bucket")
+
+	*now = now.Add(2 * time.Second)
+	assert.True(t, allow(t, l, "write", "1.2.3.4").Allowed, "a token is added every 2s")
+	assert.False(t, allow(t, l, "write", "1.2.3.4").Allowed)
+}
+
+func TestMemory_Groups(t *testing.T) {
+	t.Parallel()
+
+	l, _ := newTestMemory(map[string]Rate{
+		"read":  {RPS: 1, Burst: 1},
+		"write": {RPS: 1, Burst: 1},
+	})
+
+	assert.True(t, allow(t, l, "read", "client").Allowed)
+	assert.False(t, allow(t, l, "read", "client").Allowed)
+	assert.True(t, allow(t, l, "write", "client").Allowed, "groups have separate buckets")
+
+	assert.False(t, l.Limited("admin"))
+	for range 100 {
+		assert.True(t, allow(t, l, "admin", "client").Allowed, "groups without a rate are unlimited")
+	}
+}
+
+func TestMemory_Cleanup(t *testing.T) {
+	t.Parallel()
+
+	l, now := newTestMemory(map[string]Rate{"write": {RPS: 0.01, Burst: 2}})
+
+	allow(t, l, "write", "idle")
+	allow(t, l, "write", "idle")
+	allow(t, l, "write", "recent")
+	require.Equal(t, 2, l.Len())
+
+	*now = now.Add(time.Minute)
+	allow(t, l, "write", "recent")
+
+	l.Cleanup(30 * time.Second)
+	assert.Equal(t, 2, l.Len(), "an idle client whose bucket is not full yet must be kept")
+
+	*now = now.Add(10 * time.Minute)
+	l.Cleanup(30 * time.Second)
+	assert.Equal(t, 0, l.Len())
+}
+
+func TestMemory_Run(t *testing.T) {
+	t.Parallel()
+
+	l := NewMemory(map[string]Rate{"write": {RPS: 1000, Burst: 1}})
+	allow(t, l, "write", "client")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		l.Run(ctx, time.Millisecond, 0)
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool { return l.Len() == 0 }, time.Second, time.Millisecond)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after ctx was cancelled")
+	}
+}
@@ -0,0 +1,95 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// allowScript checks and counts a request in one step, so concurrent
+// instances cannot both take the last slot. KEYS are the previous and
+// current window's counters, ARGV the weight of the previous window, the
+// limit and the counter's TTL in milliseconds. It returns whether the
+// request was allowed and both counts.
+var allowScript = redis.NewScript(`
+local prev = tonumber(redis.call('GET', KEYS[1]) or '0')
+local curr = tonumber(redis.call('GET', KEYS[2]) or '0')
+
+if prev * tonumber(ARGV[1]) + curr + 1 > tonumber(ARGV[2]) then
+	return {0, prev, curr}
+end
+
+curr = redis.call('INCR', KEYS[2])
+redis.call('PEXPIRE', KEYS[2], ARGV[3])
+
+return {1, prev, curr}
+`)
+
+// Redis keeps sliding window counters in any server speaking the Redis
+// protocol, shared by every instance of the API.
+type Redis struct {
+	client *redis.Client
+	rates  map[string]Rate
+
+	// now is replaced in tests.
+	now func() time.Time
+}
+
+// NewRedis connects to the server at url (redis://[user:password@]host:port/db)
+// and verifies the connection with a PING.
+func NewRedis(url string, rates map[string]Rate) (*Redis, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+
+	client := redis.NewClient(opts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = client.Ping(ctx).Err()
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return &Redis{client: client, rates: rates, now: time.Now}, nil
+}
+
+func (l *Redis) Limited(group string) bool {
+	return l.rates[group].RPS > 0
+}
+
+func (l *Redis) Allow(group, key string) (Result, error) {
+	r := l.rates[group]
+	if r.RPS <= 0 {
+		return Result{Allowed: true}, nil
+	}
+
+	w := newWindow(r)
+	now := l.now()
+	start := w.start(now)
+
+	// The braces put both counters of a client in the same cluster slot.
+	keys := []string{
+		fmt.Sprintf("ratelimit:{%s:%s}:%d", group, key, start.Add(-w.size).UnixMilli()),
+		fmt.Sprintf("ratelimit:{%s:%s}:%d", group, key, start.UnixMilli()),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	counts, err := allowScript.Run(ctx, l.client, keys, w.weight(now), w.limit, (2 * w.size).Milliseconds()).Int64Slice()
+	if err != nil {
+		return Result{}, err
+	}
+
+	return w.result(now, counts[0] == 1, int(counts[1]), int(counts[2])), nil
+}
+
+func (l *Redis) Close() error {
+	return l.client.Close()
+}
@@ -0,0 +1,103 @@
+package ratelimit
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedis(t *testing.T) {
+	t.Parallel()
+
+	srv := miniredis.RunT(t)
+
+	newLimiter := func() *Redis {
+		l, err := NewRedis("redis://"+srv.Addr()+"/0", map[string]Rate{"write": {RPS: 0.5, Burst: 3}})
+		require.NoError(t, err)
+		t.Cleanup(func() { l.Close() })
+
+		return l
+	}
+
+	// Two instances share the counters.
+	now := time.Date(2026, time.October, 18, 12, 0, 0, 0, time.UTC)
+	first, second := newLimiter(), newLimiter()
+	first.now = func() time.Time { return now }
+	second.now = func() time.Time { return now }
+
+	assert.True(t, allow(t, first, "write", "1.2.3.4").Allowed)
+	assert.True(t, allow(t, second, "write", "1.2.3.4").Allowed)
+
+	result := allow(t, first, "write", "1.2.3.4")
+	require.True(t, result.Allowed)
+	assert.Equal(t, 3, result.Limit)
+	assert.Equal(t, 0, result.Remaining)
+
+	result = allow(t, second, "write", "1.2.3.4")
+	assert.False(t, result.Allowed)
+	// The window is 6s, and 3 requests weigh 2 after a third of the next.
+	assert.Equal(t, 6*time.Second+2*time.Second, result.RetryAfter)
+
+	assert.True(t, allow(t, second, "write", "5.6.7.8").Allowed, "other clients have their own counters")
+	assert.True(t, allow(t, second, "admin", "1.2.3.4").Allowed, "groups without a rate are unlimited")
+
+	ttl := srv.TTL(fmt.Sprintf("ratelimit:{write:1.2.3.4}:%d", now.UnixMilli()))
+	assert.Equal(t, 12*time.Second, ttl)
+
+	now = now.Add(6 * time.Second)
+	assert.False(t, allow(t, first, "write", "1.2.3.4").Allowed, "the previous window still counts")
+
+	now = now.Add(2 * time.Second)
+	assert.True(t, allow(t, first, "write", "1.2.3.4").Allowed)
+	assert.False(t, allow(t, first, "write", "1.2.3.4").Allowed)
+}
+
+func TestRedis_Concurrent(t *testing.T) {
+	t.Parallel()
+
+	srv := miniredis.RunT(t)
+
+	l, err := NewRedis("redis://"+srv.Addr()+"/0", map[string]Rate{"write": {RPS: 0.001, Burst: 10}})
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		allowed int
+	)
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			result, err := l.Allow("write", "client")
+			assert.NoError(t, err)
+
+			if result.Allowed {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 10, allowed)
+}
+
+func TestNewRedis_Unreachable(t *testing.T) {
+	t.Parallel()
+
+	srv := miniredis.RunT(t)
+	addr := srv.Addr()
+	srv.Close()
+
+	_, err := NewRedis("redis://"+addr+"/0", nil)
+	assert.Error(t, err)
+}
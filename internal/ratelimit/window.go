@@ -0,0 +1,87 @@
+package ratelimit
+
+import (
+	"math"
+	"time"
+)
+
+// window is the sliding window counter the shared stores use in place of a
+// token bucket. It allows Burst requests in any Burst/RPS seconds, which
+// averages out to RPS like the bucket does. Requests are counted per fixed
+// window, and the previous window's count is weighted by how much of it the
+// sliding window still covers.
+type window struct {
+	limit int
+	size  time.Duration
+}
+
+// newWindow rounds the window to whole milliseconds, so that window starts
+// survive being stored with millisecond precision.
+func newWindow(r Rate) window {
+	size := seconds(float64(r.Burst) / r.RPS).Round(time.Millisecond)
+	return window{limit: r.Burst, size: max(time.Millisecond, size)}
+}
+
+// start returns the start of the fixed window now falls in. Instances agree
+// on it as long as their clocks do.
+func (w window) start(now time.Time) time.Time {
+	return now.Truncate(w.size)
+}
+
+// room returns how many requests the current fixed window may hold, given
+// prev requests in the previous one.
+func (w window) room(now time.Time, prev int) float64 {
+	return float64(w.limit) - float64(prev)*w.weight(now)
+}
+
+func (w window) weight(now time.Time) float64 {
+	return 1 - float64(now.Sub(w.start(now)))/float64(w.size)
+}
+
+// result describes the window once a request was counted, or turned away
+// when allowed is false. prev and curr are the counts of the previous and
+// current fixed windows.
+func (w window) result(now time.Time, allowed bool, prev, curr int) Result {
+	elapsed := now.Sub(w.start(now))
+	used := float64(prev)*w.weight(now) + float64(curr)
+
+	result := Result{
+		Allowed:   allowed,
+		Limit:     w.limit,
+		Remaining: max(0, int(math.Floor(float64(w.limit)-used))),
+	}
+
+	switch {
+	case curr > 0:
+		result.Reset = 2*w.size - elapsed
+	case prev > 0:
+		result.Reset = w.size - elapsed
+	}
+
+	if allowed {
+		return result
+	}
+
+	if curr < w.limit {
+		// Only the previous window is in the way; wait until enough of it
+		// has slid out.
+		until := w.fraction(w.limit-curr-1, prev)
+		result.RetryAfter = max(0, until-elapsed)
+	} else {
+		// The current window is full. In the next one it becomes the
+		// previous window and has to slide out far enough in turn.
+		result.RetryAfter = w.size - elapsed + w.fraction(w.limit-1, curr)
+	}
+
+	return result
+}
+
+// fraction returns how far into a fixed window count requests from the
+// window before are weighted down to at most allowed.
+func (w window) fraction(allowed, count int) time.Duration {
+	if count == 0 {
+		return 0
+	}
+
+	return seconds(w.size.Seconds() * (1 - float64(allowed)/float64(count)))
+}
@@ -0,0 +1,78 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWindow(t *testing.T) {
+	t.Parallel()
+
+	// 5 requests in any 10 seconds.
+	w := newWindow(Rate{RPS: 0.5, Burst: 5})
+	assert.Equal(t, 10*time.Second, w.size)
+
+	start := time.Date(2026, time.October, 18, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, start, w.start(start.Add(9*time.Second)))
+
+	tests := []struct {
+		name       string
+		elapsed    time.Duration
+		allowed    bool
+		prev, curr int
+		expectRoom float64
+		expect     Result
+	}{
+		{
+			name:       "first request",
+			allowed:    true,
+			curr:       1,
+			expectRoom: 5,
+			expect:     Result{Allowed: true, Limit: 5, Remaining: 4, Reset: 20 * time.Second},
+		},
+		{
+			name:       "half of the previous window counts",
+			elapsed:    5 * time.Second,
+			allowed:    true,
+			prev:       4,
+			curr:       2,
+			expectRoom: 3,
+			expect:     Result{Allowed: true, Limit: 5, Remaining: 1, Reset: 15 * time.Second},
+		},
+		{
+			name:       "previous window in the way",
+			elapsed:    2 * time.Second,
+			prev:       5,
+			curr:       1,
+			expectRoom: 1,
+			// 5 * (1 - 4s/10s) + 1 leaves room for one more.
+			expect: Result{Limit: 5, Reset: 18 * time.Second, RetryAfter: 2 * time.Second},
+		},
+		{
+			name:       "current window full",
+			elapsed:    6 * time.Second,
+			curr:       5,
+			expectRoom: 5,
+			// 5 * (1 - 2s/10s) leaves room for one more 2s into the next window.
+			expect: Result{Limit: 5, Reset: 14 * time.Second, RetryAfter: 6 * time.Second},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			now := start.Add(tt.elapsed)
+			assert.InDelta(t, tt.expectRoom, w.room(now, tt.prev), 1e-9)
+
+			result := w.result(now, tt.allowed, tt.prev, tt.curr)
+			assert.Equal(t, tt.expect.Allowed, result.Allowed)
+			assert.Equal(t, tt.expect.Limit, result.Limit)
+			assert.Equal(t, tt.expect.Remaining, result.Remaining)
+			assert.Equal(t, tt.expect.Reset, result.Reset)
+			assert.InDelta(t, tt.expect.RetryAfter, result.RetryAfter, float64(time.Millisecond))
+		})
+	}
+}
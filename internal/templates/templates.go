@@ -0,0 +1,146 @@
+// Package templates loads operator-defined birthday message templates from
+// a directory. Each file is a Go text/template named after the file:
+// short.tmpl defines "short", and short.de.tmpl is its German variant.
+package templates
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// ErrNotFound is returned by Render for a template name that was not loaded.
+var ErrNotFound = errors.New("message template not found")
+
+// Data is what a template can refer to.
+type Data struct {
+	Username string
+	// DaysUntil is the number of days until the next birthday, 0 on the day.
+	DaysUntil int
+	// Age is the age the user turns on their next birthday, or turns today.
+	Age     int
+	IsToday bool
+	// Locale is the language the message should be in.
+	Locale string
+}
+
+// Set is a collection of named templates with optional per-locale variants.
+type Set struct {
+	// templates maps a name to its variants keyed by locale, with "" for
+	// the variant that has no locale in its file name.
+	templates map[string]map[string]*template.Template
+}
+
+// Load parses every *.tmpl file in dir and renders each with sample data,
+// so that templates referring to unknown fields fail at startup instead of
+// on the first request.
+func Load(dir string) (*Set, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.tmpl"))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no *.tmpl files in %s", dir)
+	}
+
+	s := &Set{templates: make(map[string]map[string]*template.Template)}
+
+	for _, path := range paths {
+		name, locale, _ := strings.Cut(strings.TrimSuffix(filepath.Base(path), ".tmpl"), ".")
+
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		tmpl, err := template.New(name).Parse(strings.TrimRight(string(b), "\n"))
+		if err != nil {
+			return nil, fmt.Errorf("message template %s: %w", filepath.Base(path), err)
+		}
+
+		for _, sample := range []Data{
+			{Username: "john", DaysUntil: 0, Age: 30, IsToday: true, Locale: locale},
+			{Username: "john", DaysUntil: 12, Age: 31, IsToday: false, Locale: locale},
+		} {
+			err = tmpl.Execute(new(bytes.Buffer), sample)
+			if err != nil {
+				return nil, fmt.Errorf("message template %s: %w", filepath.Base(path), err)
+			}
+		}
+
+		if s.templates[name] == nil {
+			s.templates[name] = make(map[string]*template.Template)
+		}
+		s.templates[name][locale] = tmpl
+	}
+
+	for name, variants := range s.templates {
+		if _, ok := variants[""]; !ok {
+			return nil, fmt.Errorf("message template %q has locale variants but no %s.tmpl to fall back to", name, name)
+		}
+	}
+
+	return s, nil
+}
+
+// Has reports whether a template called name was loaded.
+func (s *Set) Has(name string) bool {
+	if s == nil {
+		return false
+	}
+
+	_, ok := s.templates[name]
+	return ok
+}
+
+// Names returns the loaded template names.
+func (s *Set) Names() []string {
+	if s == nil {
+		return nil
+	}
+
+	names := make([]string, 0, len(s.templates))
+	for name := range s.templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Variant returns the locale of the variant of name that Render uses for
+// locale, or "" when it falls back to the variant without one.
+func (s *Set) Variant(name, locale string) string {
+	if !s.Has(name) {
+		return ""
+	}
+
+	if _, ok := s.templates[name][locale]; ok {
+		return locale
+	}
+	return ""
+}
+
+// Render executes the variant of name for data.Locale, falling back to the
+// variant without a locale, which Load makes sure exists.
+func (s *Set) Render(name string, data Data) (string, error) {
+	if !s.Has(name) {
+		return "", fmt.Errorf("%w: %q", ErrNotFound, name)
+	}
+
+	tmpl := s.templates[name][s.Variant(name, data.Locale)]
+
+	var buf bytes.Buffer
+	err := tmpl.Execute(&buf, data)
+	if err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
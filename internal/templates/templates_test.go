@@ -0,0 +1,141 @@
+package templates
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTemplates(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+
+	return dir
+}
+
+func TestLoadAndRender(t *testing.T) {
+	t.Parallel()
+
+	dir := writeTemplates(t, map[string]string{
+		"short.tmpl":    "{{if .IsToday}}🎂 {{.Username}} turns {{.Age}}!{{else}}{{.DaysUntil}}d to go, {{.Username}}{{end}}\n",
+		"short.de.tmpl": "{{if .IsToday}}🎂 {{.Username}} wird {{.Age}}!{{else}}Noch {{.DaysUntil}} Tage, {{.Username}}{{end}}",
+		"formal.tmpl":   "Dear {{.Username}}, {{.DaysUntil}} days remain.",
+		"README.md":     "not a template",
+	})
+
+	set, err := Load(dir)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"formal", "short"}, set.Names())
+	assert.True(t, set.Has("short"))
+	assert.False(t, set.Has("README"))
+
+	tests := []struct {
+		name     string
+		template string
+		data     Data
+		expect   string
+	}{
+		{
+			name:     "today",
+			template: "short",
+			data:     Data{Username: "john", Age: 30, IsToday: true, Locale: "en"},
+			expect:   "🎂 john turns 30!",
+		},
+		{
+			name:     "days until",
+			template: "short",
+			data:     Data{Username: "john", DaysUntil: 5, Age: 31, Locale: "en"},
+			expect:   "5d to go, john",
+		},
+		{
+			name:     "locale variant",
+			template: "short",
+			data:     Data{Username: "hans", DaysUntil: 5, Age: 31, Locale: "de"},
+			expect:   "Noch 5 Tage, hans",
+		},
+		{
+			name:     "no variant for locale",
+			template: "formal",
+			data:     Data{Username: "ivan", DaysUntil: 3, Locale: "ru"},
+			expect:   "Dear ivan, 3 days remain.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			message, err := set.Render(tt.template, tt.data)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expect, message)
+		})
+	}
+
+	_, err = set.Render("missing", Data{})
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	assert.Equal(t, "de", set.Variant("short", "de"))
+	assert.Empty(t, set.Variant("short", "ru"), "falling back to short.tmpl")
+	assert.Empty(t, set.Variant("missing", "de"))
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		files       map[string]string
+		expectError string
+	}{
+		{
+			name:        "empty directory",
+			files:       map[string]string{},
+			expectError: "no *.tmpl files",
+		},
+		{
+			name:        "syntax error",
+			files:       map[string]string{"broken.tmpl": "Hello {{.Username"},
+			expectError: "broken.tmpl",
+		},
+		{
+			name:        "unknown field",
+			files:       map[string]string{"typo.tmpl": "Hello {{.UserName}}"},
+			expectError: "can't evaluate field UserName",
+		},
+		{
+			name:        "locale variant without default",
+			files:       map[string]string{"short.de.tmpl": "Hallo {{.Username}}"},
+			expectError: "no short.tmpl",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := Load(writeTemplates(t, tt.files))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expectError)
+		})
+	}
+}
+
+func TestNilSet(t *testing.T) {
+	t.Parallel()
+
+	var set *Set
+
+	assert.False(t, set.Has("short"))
+	assert.Empty(t, set.Names())
+
+	_, err := set.Render("short", Data{})
+	assert.ErrorIs(t, err, ErrNotFound)
+}
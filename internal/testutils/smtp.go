@@ -0,0 +1,157 @@
+package testutils
+
+import (
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// SMTPServer is a local stand-in for a mail server. It accepts any sender
+// and recipient without authentication or TLS, and records every message
+// it receives.
+type SMTPServer struct {
+	// URL is the smtp:// URL to send to.
+	URL string
+
+	// Reject makes the server refuse recipients with 550.
+	Reject atomic.Bool
+	// Hangup makes the server close the connection once it received a
+	// message, without confirming it. The message is still recorded.
+	Hangup atomic.Bool
+
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu       sync.Mutex
+	messages []SMTPMessage
+}
+
+// SMTPMessage is a message an SMTPServer received.
+type SMTPMessage struct {
+	From string
+	To   []string
+	// Data is the message as sent, headers included.
+	Data []byte
+}
+
+// NewSMTPServer starts an SMTPServer on a free local port. It is stopped
+// when the test ends.
+func NewSMTPServer(t *testing.T) *SMTPServer {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := &SMTPServer{URL: "smtp://" + l.Addr().String(), listener: l}
+
+	s.wg.Add(1)
+	go s.serve()
+
+	t.Cleanup(func() {
+		l.Close()
+		s.wg.Wait()
+	})
+
+	return s
+}
+
+// Messages returns the messages received so far.
+func (s *SMTPServer) Messages() []SMTPMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]SMTPMessage(nil), s.messages...)
+}
+
+func (s *SMTPServer) serve() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			s.handle(textproto.NewConn(conn))
+		}()
+	}
+}
+
+func (s *SMTPServer) handle(c *textproto.Conn) {
+	reply := func(line string) bool {
+		return c.PrintfLine("%s", line) == nil
+	}
+
+	if !reply("220 localhost SMTP stand-in") {
+		return
+	}
+
+	var msg SMTPMessage
+
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+
+		verb, arg, _ := strings.Cut(line, " ")
+
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			reply("250 localhost")
+		case "MAIL":
+			msg = SMTPMessage{From: address(arg)}
+			reply("250 OK")
+		case "RCPT":
+			if s.Reject.Load() {
+				reply("550 no such user")
+				continue
+			}
+			msg.To = append(msg.To, address(arg))
+			reply("250 OK")
+		case "DATA":
+			if !reply("354 end data with <CR><LF>.<CR><LF>") {
+				return
+			}
+
+			msg.Data, err = io.ReadAll(c.DotReader())
+			if err != nil {
+				return
+			}
+
+			s.mu.Lock()
+			s.messages = append(s.messages, msg)
+			s.mu.Unlock()
+
+			if s.Hangup.Load() {
+				return
+			}
+			reply("250 OK")
+		case "RSET", "NOOP":
+			reply("250 OK")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 command not implemented")
+		}
+	}
+}
+
+// address returns the address in a MAIL FROM:<...> or RCPT TO:<...>
+// argument.
+func address(arg string) string {
+	_, addr, _ := strings.Cut(arg, "<")
+	addr, _, _ = strings.Cut(addr, ">")
+	return addr
+}
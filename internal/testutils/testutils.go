@@ -3,17 +3,22 @@ package testutils
 import (
 	"context"
 	"database/sql"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/ab0utbla-k/rvt-hello-app/internal/data"
+	"github.com/ab0utbla-k/rvt-hello-app/migrations"
 	"github.com/stretchr/testify/require"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/modules/postgres"
 	"github.com/testcontainers/testcontainers-go/wait"
+	_ "modernc.org/sqlite"
 )
 
-const userTableMigrationPath = "../../migrations/000001_create_users_table.up.sql"
-
 func SetupTestDB(t *testing.T) *sql.DB {
 	t.Helper()
 
@@ -27,7 +32,6 @@ func SetupTestDB(t *testing.T) *sql.DB {
 		postgres.WithDatabase("hello_test"),
 		postgres.WithUsername("postgres"),
 		postgres.WithPassword("password"),
-		postgres.WithInitScripts(userTableMigrationPath),
 		testcontainers.WithWaitStrategy(
 			// PostgreSQL logs this twice: during startup and when fully ready
 			wait.ForLog("database system is ready to accept connections").
@@ -55,11 +59,82 @@ func SetupTestDB(t *testing.T) *sql.DB {
 		require.NoError(t, db.Close())
 	})
 
+	applyMigrations(t, db, "postgres")
+
 	return db
 }
 
+// CleanupDB empties every table the migrations created, so tables added
+// later are covered without touching this list.
 func CleanupDB(t *testing.T, db *sql.DB) {
 	t.Helper()
-	_, err := db.Exec(`TRUNCATE TABLE users RESTART IDENTITY CASCADE`)
+
+	tables := listTables(t, db, `SELECT tablename FROM pg_tables WHERE schemaname = current_schema() AND tablename <> 'schema_migrations'`)
+
+	_, err := db.Exec("TRUNCATE TABLE " + strings.Join(tables, ", ") + " RESTART IDENTITY CASCADE")
+	require.NoError(t, err)
+}
+
+// SetupSQLiteTestDB opens a SQLite database in a temporary directory and
+// applies the SQLite migrations. Unlike SetupTestDB it needs no Docker,
+// so it also runs in short test mode.
+func SetupSQLiteTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", data.SQLiteDSN(filepath.Join(t.TempDir(), "hello_test.db")))
 	require.NoError(t, err)
+
+	db.SetMaxOpenConns(1)
+
+	t.Cleanup(func() {
+		require.NoError(t, db.Close())
+	})
+
+	applyMigrations(t, db, "sqlite")
+
+	return db
+}
+
+// CleanupSQLiteDB is CleanupDB for SQLite. Every foreign key cascades, so
+// the order tables are emptied in does not matter.
+func CleanupSQLiteDB(t *testing.T, db *sql.DB) {
+	t.Helper()
+
+	tables := listTables(t, db, `SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' AND name <> 'schema_migrations'`)
+
+	// sqlite_sequence holds the AUTOINCREMENT counters, which RESTART
+	// IDENTITY resets on PostgreSQL.
+	for _, table := range append(tables, "sqlite_sequence") {
+		_, err := db.Exec("DELETE FROM " + table)
+		require.NoError(t, err)
+	}
+}
+
+// listTables returns the table names that query selects.
+func listTables(t *testing.T, db *sql.DB, query string) []string {
+	t.Helper()
+
+	rows, err := db.Query(query)
+	require.NoError(t, err)
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var table string
+		require.NoError(t, rows.Scan(&table))
+		tables = append(tables, table)
+	}
+	require.NoError(t, rows.Err())
+	require.NotEmpty(t, tables, "no tables to clean up; were the migrations applied?")
+
+	return tables
+}
+
+// applyMigrations runs the full embedded migration set through the same
+// code path the server uses on startup.
+func applyMigrations(t *testing.T, db *sql.DB, driverName string) {
+	t.Helper()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	require.NoError(t, migrations.Run(db, driverName, time.Minute, logger))
 }
@@ -0,0 +1,329 @@
+// Package tokens verifies JWT bearer tokens signed with HS256 or RS256
+// against the keys of a JSON Web Key Set (RFC 7517).
+package tokens
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/sync/singleflight"
+)
+
+// ErrInvalidToken is wrapped by every error Verify returns for a token that
+// must not be accepted.
+var ErrInvalidToken = errors.New("invalid token")
+
+// Claims are the claims Verify reads. Subject is the username the token acts
+// for, and Admin lets it act for any user.
+type Claims struct {
+	jwt.RegisteredClaims
+	Admin bool `json:"admin,omitempty"`
+}
+
+// Verifier checks tokens against a KeySet and, when set, the expected issuer
+// and audience.
+type Verifier struct {
+	keys   *KeySet
+	parser *jwt.Parser
+}
+
+func NewVerifier(keys *KeySet, issuer, audience string) *Verifier {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg(), jwt.SigningMethodRS256.Alg()}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(30 * time.Second),
+	}
+	if issuer != "" {
+		opts = append(opts, jwt.WithIssuer(issuer))
+	}
+	if audience != "" {
+		opts = append(opts, jwt.WithAudience(audience))
+	}
+
+	return &Verifier{keys: keys, parser: jwt.NewParser(opts...)}
+}
+
+// Verify checks the token's signature, expiry, issuer and audience, and that
+// it names a subject.
+func (v *Verifier) Verify(token string) (*Claims, error) {
+	var claims Claims
+
+	_, err := v.parser.ParseWithClaims(token, &claims, v.keys.keyFunc)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: missing sub claim", ErrInvalidToken)
+	}
+
+	return &claims, nil
+}
+
+// minRefreshInterval limits how often a token with an unknown key ID can
+// make a KeySet fetch its URL again.
+const minRefreshInterval = 30 * time.Second
+
+type key struct {
+	kid string
+	alg string
+	// value is an *rsa.PublicKey for RS256 and a []byte for HS256.
+	value any
+}
+
+// KeySet holds the keys of a JWKS read from a file or fetched from a URL. A
+// URL is fetched again when a token names a key ID the set does not have,
+// so keys can be rotated without a restart.
+type KeySet struct {
+	source string
+	client *http.Client
+
+	// mu guards keys and fetched only. It is never held while the JWKS is
+	// fetched, so a slow fetch does not hold up tokens with known keys, and
+	// concurrent fetches are collapsed into one by group.
+	mu      sync.RWMutex
+	keys    []key
+	fetched time.Time
+	group   singleflight.Group
+}
+
+// LoadKeySet reads the JWKS at source, an http(s) URL or a file path.
+func LoadKeySet(source string) (*KeySet, error) {
+	s := &KeySet{
+		source: source,
+		client: &http.Client{Timeout: 5 * time.Second},
+	}
+
+	err := s.refresh()
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *KeySet) isURL() bool {
+	return strings.HasPrefix(s.source, "http://") || strings.HasPrefix(s.source, "https://")
+}
+
+func (s *KeySet) refresh() error {
+	var (
+		b   []byte
+		err error
+	)
+
+	if s.isURL() {
+		b, err = s.fetch()
+	} else {
+		b, err = os.ReadFile(s.source)
+	}
+	if err != nil {
+		return fmt.Errorf("jwks %s: %w", s.source, err)
+	}
+
+	keys, err := parseJWKS(b)
+	if err != nil {
+		return fmt.Errorf("jwks %s: %w", s.source, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys = keys
+	s.fetched = time.Now()
+
+	return nil
+}
+
+func (s *KeySet) fetch() ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.source, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// keyFunc picks the key for token by its kid header, or the only key for
+// its algorithm when it has none. The key's type has to match the
+// algorithm, so an RSA public key can never be used as an HMAC secret.
+func (s *KeySet) keyFunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	alg := token.Method.Alg()
+
+	k, stale, err := s.find(kid, alg)
+	if err != nil && kid != "" && s.isURL() && stale {
+		_, refreshErr, _ := s.group.Do("refresh", func() (any, error) {
+			return nil, s.refresh()
+		})
+		if refreshErr != nil {
+			return nil, refreshErr
+		}
+
+		k, _, err = s.find(kid, alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return k.value, nil
+}
+
+// find looks the key up, and reports whether the set is old enough to be
+// fetched again.
+func (s *KeySet) find(kid, alg string) (key, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stale := time.Since(s.fetched) >= minRefreshInterval
+
+	k, err := s.lookup(kid, alg)
+	return k, stale, err
+}
+
+func (s *KeySet) lookup(kid, alg string) (key, error) {
+	var candidates []key
+	for _, k := range s.keys {
+		if k.alg == alg && (kid == "" || k.kid == kid) {
+			candidates = append(candidates, k)
+		}
+	}
+
+	switch {
+	case len(candidates) == 1:
+		return candidates[0], nil
+	case len(candidates) > 1:
+		return key{}, fmt.Errorf("several %s keys match, the token must name one with kid", alg)
+	case kid != "":
+		return key{}, fmt.Errorf("no %s key with kid %q", alg, kid)
+	default:
+		return key{}, fmt.Errorf("no %s key", alg)
+	}
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+
+	// RSA public keys.
+	N string `json:"n"`
+	E string `json:"e"`
+
+	// Symmetric keys.
+	K string `json:"k"`
+}
+
+// parseJWKS returns the RSA and symmetric signing keys in b. Keys of other
+// types, or meant for encryption, are skipped.
+func parseJWKS(b []byte) ([]key, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+
+	err := json.Unmarshal(b, &set)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []key
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		var k key
+		switch jwk.Kty {
+		case "RSA":
+			k, err = rsaKey(jwk)
+		case "oct":
+			k, err = hmacKey(jwk)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", jwk.Kid, err)
+		}
+
+		keys = append(keys, k)
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("no RS256 or HS256 signing keys")
+	}
+
+	return keys, nil
+}
+
+func rsaKey(jwk jsonWebKey) (key, error) {
+	if jwk.Alg != "" && jwk.Alg != "RS256" {
+		return key{}, fmt.Errorf("unsupported alg %q for an RSA key", jwk.Alg)
+	}
+
+	n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+	if err != nil {
+		return key{}, fmt.Errorf("n: %w", err)
+	}
+
+	e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+	if err != nil {
+		return key{}, fmt.Errorf("e: %w", err)
+	}
+
+	if len(n) == 0 || len(e) == 0 || len(e) > 4 {
+		return key{}, errors.New("malformed RSA public key")
+	}
+
+	return key{
+		kid: jwk.Kid,
+		alg: "RS256",
+		value: &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		},
+	}, nil
+}
+
+func hmacKey(jwk jsonWebKey) (key, error) {
+	if jwk.Alg != "" && jwk.Alg != "HS256" {
+		return key{}, fmt.Errorf("unsupported alg %q for a symmetric key", jwk.Alg)
+	}
+
+	secret, err := base64.RawURLEncoding.DecodeString(jwk.K)
+	if err != nil {
+		return key{}, fmt.Errorf("k: %w", err)
+	}
+
+	// RFC 7518 requires HS256 keys of at least the hash size.
+	if len(secret) < 32 {
+		return key{}, errors.New("HS256 key must be at least 32 bytes")
+	}
+
+	return key{kid: jwk.Kid, alg: "HS256", value: secret}, nil
+}
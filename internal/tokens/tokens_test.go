@@ -0,0 +1,256 @@
+package tokens
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var hmacSecret = []byte("0123456789abcdef0123456789abcdef")
+
+func octJWK(kid string, secret []byte) map[string]any {
+	return map[string]any{"kty": "oct", "kid": kid, "alg": "HS256", "k": base64.RawURLEncoding.EncodeToString(secret)}
+}
+
+func rsaJWK(kid string, key *rsa.PublicKey) map[string]any {
+	return map[string]any{
+		"kty": "RSA",
+		"kid": kid,
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func writeJWKS(t *testing.T, keys ...map[string]any) string {
+	t.Helper()
+
+	b, err := json.Marshal(map[string]any{"keys": keys})
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, b, 0o600))
+
+	return path
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key any, claims jwt.Claims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+
+	return signed
+}
+
+func claimsFor(sub string) Claims {
+	return Claims{RegisteredClaims: jwt.RegisteredClaims{
+		Subject:   sub,
+		Issuer:    "https://id.example.com",
+		Audience:  jwt.ClaimStrings{"hello-api"},
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}}
+}
+
+func TestVerifier_HS256(t *testing.T) {
+	t.Parallel()
+
+	keys, err := LoadKeySet(writeJWKS(t, octJWK("hs", hmacSecret)))
+	require.NoError(t, err)
+
+	v := NewVerifier(keys, "https://id.example.com", "hello-api")
+
+	admin := claimsFor("root")
+	admin.Admin = true
+
+	expired := claimsFor("john")
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+
+	wrongAudience := claimsFor("john")
+	wrongAudience.Audience = jwt.ClaimStrings{"other-api"}
+
+	noExpiry := claimsFor("john")
+	noExpiry.ExpiresAt = nil
+
+	tests := []struct {
+		name        string
+		token       string
+		expectSub   string
+		expectAdmin bool
+	}{
+		{name: "valid", token: sign(t, jwt.SigningMethodHS256, "hs", hmacSecret, claimsFor("john")), expectSub: "john"},
+		{name: "valid without kid", token: sign(t, jwt.SigningMethodHS256, "", hmacSecret, claimsFor("john")), expectSub: "john"},
+		{name: "admin claim", token: sign(t, jwt.SigningMethodHS256, "hs", hmacSecret, admin), expectSub: "root", expectAdmin: true},
+		{name: "wrong secret", token: sign(t, jwt.SigningMethodHS256, "hs", []byte("fedcba9876543210fedcba9876543210"), claimsFor("john"))},
+		{name: "unknown kid", token: sign(t, jwt.SigningMethodHS256, "other", hmacSecret, claimsFor("john"))},
+		{name: "expired", token: sign(t, jwt.SigningMethodHS256, "hs", hmacSecret, expired)},
+		{name: "no expiry", token: sign(t, jwt.SigningMethodHS256, "hs", hmacSecret, noExpiry)},
+		{name: "wrong audience", token: sign(t, jwt.SigningMethodHS256, "hs", hmacSecret, wrongAudience)},
+		{name: "missing subject", token: sign(t, jwt.SigningMethodHS256, "hs", hmacSecret, claimsFor(""))},
+		{name: "HS512", token: sign(t, jwt.SigningMethodHS512, "hs", hmacSecret, claimsFor("john"))},
+		{name: "unsigned", token: sign(t, jwt.SigningMethodNone, "hs", jwt.UnsafeAllowNoneSignatureType, claimsFor("john"))},
+		{name: "garbage", token: "not.a.jwt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			claims, err := v.Verify(tt.token)
+			if tt.expectSub == "" {
+				assert.ErrorIs(t, err, ErrInvalidToken)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectSub, claims.Subject)
+			assert.Equal(t, tt.expectAdmin, claims.Admin)
+		})
+	}
+}
+
+func TestVerifier_RS256FromURL(t *testing.T) {
+	t.Parallel()
+
+	first, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	second, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	// The server starts with one key and adds a second, as a rotation would.
+	var (
+		rotated  atomic.Bool
+		requests atomic.Int32
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+
+		keys := []map[string]any{rsaJWK("first", &first.PublicKey)}
+		if rotated.Load() {
+			keys = append(keys, rsaJWK("second", &second.PublicKey))
+		}
+		json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+	}))
+	t.Cleanup(srv.Close)
+
+	keys, err := LoadKeySet(srv.URL)
+	require.NoError(t, err)
+
+	v := NewVerifier(keys, "", "")
+
+	claims, err := v.Verify(sign(t, jwt.SigningMethodRS256, "first", first, claimsFor("john")))
+	require.NoError(t, err)
+	assert.Equal(t, "john", claims.Subject)
+
+	// An RSA public key must not be accepted as an HMAC secret.
+	publicKeyJSON, err := json.Marshal(rsaJWK("first", &first.PublicKey))
+	require.NoError(t, err)
+	_, err = v.Verify(sign(t, jwt.SigningMethodHS256, "first", publicKeyJSON, claimsFor("john")))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	rotated.Store(true)
+	keys.fetched = time.Now().Add(-minRefreshInterval)
+
+	claims, err = v.Verify(sign(t, jwt.SigningMethodRS256, "second", second, claimsFor("alice")))
+	require.NoError(t, err, "an unknown kid should fetch the JWKS again")
+	assert.Equal(t, "alice", claims.Subject)
+
+	// Unknown key IDs do not refetch more often than minRefreshInterval.
+	before := requests.Load()
+	_, err = v.Verify(sign(t, jwt.SigningMethodRS256, "third", second, claimsFor("alice")))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	assert.Equal(t, before, requests.Load())
+}
+
+func TestKeySet_SlowRefreshDoesNotBlockKnownKeys(t *testing.T) {
+	t.Parallel()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	var requests atomic.Int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Only the first fetch, from LoadKeySet, answers straight away.
+		if requests.Add(1) > 1 {
+			<-release
+		}
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]any{rsaJWK("known", &key.PublicKey)}})
+	}))
+	t.Cleanup(srv.Close)
+
+	keys, err := LoadKeySet(srv.URL)
+	require.NoError(t, err)
+	keys.fetched = time.Now().Add(-minRefreshInterval)
+
+	v := NewVerifier(keys, "", "")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := v.Verify(sign(t, jwt.SigningMethodRS256, "unknown", key, claimsFor("john")))
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	}()
+
+	require.Eventually(t, func() bool { return requests.Load() == 2 }, time.Second, time.Millisecond)
+
+	verified := make(chan error, 1)
+	go func() {
+		_, err := v.Verify(sign(t, jwt.SigningMethodRS256, "known", key, claimsFor("alice")))
+		verified <- err
+	}()
+
+	select {
+	case err := <-verified:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Error("a token with a known key waited for the JWKS fetch")
+	}
+
+	close(release)
+	<-done
+}
+
+func TestLoadKeySet_Invalid(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		keys []map[string]any
+	}{
+		{name: "no keys"},
+		{name: "only encryption keys", keys: []map[string]any{{"kty": "RSA", "use": "enc", "n": "AQAB", "e": "AQAB"}}},
+		{name: "short HMAC secret", keys: []map[string]any{octJWK("hs", []byte("short"))}},
+		{name: "RSA key with HMAC alg", keys: []map[string]any{{"kty": "RSA", "alg": "HS256", "n": "AQAB", "e": "AQAB"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := LoadKeySet(writeJWKS(t, tt.keys...))
+			assert.Error(t, err)
+		})
+	}
+
+	_, err := LoadKeySet(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
@@ -4,29 +4,41 @@ import (
 	"regexp"
 )
 
-var UserRX = regexp.MustCompile("^[a-zA-Z]+$")
+var (
+	UserRX  = regexp.MustCompile("^[a-zA-Z]+$")
+	EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+)
+
+// FieldError describes why a field failed validation. Code is stable, such
+// as "username.invalid_chars", so clients can match on it; Message is for
+// humans and may change. Params holds the values the check compared against.
+type FieldError struct {
+	Code    string
+	Message string
+	Params  map[string]any
+}
 
 type Validator struct {
-	Errors map[string]string
+	Errors map[string]FieldError
 }
 
 func New() *Validator {
-	return &Validator{Errors: make(map[string]string)}
+	return &Validator{Errors: make(map[string]FieldError)}
 }
 
 func (v *Validator) Valid() bool {
 	return len(v.Errors) == 0
 }
 
-func (v *Validator) AddError(key, message string) {
+func (v *Validator) AddError(key string, err FieldError) {
 	if _, exists := v.Errors[key]; !exists {
-		v.Errors[key] = message
+		v.Errors[key] = err
 	}
 }
 
-func (v *Validator) Check(ok bool, key, message string) {
+func (v *Validator) Check(ok bool, key string, err FieldError) {
 	if !ok {
-		v.AddError(key, message)
+		v.AddError(key, err)
 	}
 }
 
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS rate_limits (
+    key text NOT NULL,
+    window_start timestamp(3) with time zone NOT NULL,
+    count integer NOT NULL,
+    expires_at timestamp(3) with time zone NOT NULL,
+    PRIMARY KEY (key, window_start)
+);
+
+CREATE INDEX IF NOT EXISTS rate_limits_expires_at_idx ON rate_limits (expires_at);
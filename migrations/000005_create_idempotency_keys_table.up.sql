@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    caller text NOT NULL,
+    key text NOT NULL,
+    fingerprint bytea NOT NULL,
+    status integer NOT NULL DEFAULT 0,
+    headers jsonb NOT NULL DEFAULT '{}',
+    body bytea,
+    expires_at timestamp(0) with time zone NOT NULL,
+    PRIMARY KEY (caller, key)
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
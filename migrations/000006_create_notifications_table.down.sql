@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS notifications;
+
+ALTER TABLE users DROP COLUMN IF EXISTS time_zone;
+ALTER TABLE users DROP COLUMN IF EXISTS email;
@@ -0,0 +1,11 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email text;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS time_zone text;
+
+CREATE TABLE IF NOT EXISTS notifications (
+    username text NOT NULL REFERENCES users ON DELETE CASCADE,
+    kind text NOT NULL,
+    birthday date NOT NULL,
+    claimed_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    sent_at timestamp(0) with time zone,
+    PRIMARY KEY (username, kind, birthday)
+);
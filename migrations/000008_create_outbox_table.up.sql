@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS outbox (
+    id bigserial PRIMARY KEY,
+    event_id text NOT NULL UNIQUE,
+    event text NOT NULL,
+    payload bytea NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    dispatched_at timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS outbox_undispatched_idx ON outbox (id) WHERE dispatched_at IS NULL;
@@ -0,0 +1,269 @@
+// Package migrations embeds the SQL migrations for every supported database
+// and applies them. The server and the test helpers share this code path.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/database/sqlite"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+)
+
+// FS holds the PostgreSQL migrations.
+//
+//go:embed *.sql
+var FS embed.FS
+
+// SQLiteFS holds the SQLite migrations under sqlite/.
+//
+//go:embed sqlite/*.sql
+var SQLiteFS embed.FS
+
+// Source returns the embedded migrations for driverName.
+func Source(driverName string) (source.Driver, error) {
+	if driverName == "sqlite" {
+		return iofs.New(SQLiteFS, "sqlite")
+	}
+
+	return iofs.New(FS, ".")
+}
+
+// New returns a migrate instance for db. Closing it releases what it holds
+// but leaves db open, as db belongs to the caller.
+func New(db *sql.DB, driverName string) (*migrate.Migrate, error) {
+	sourceDriver, err := Source(driverName)
+	if err != nil {
+		return nil, err
+	}
+
+	var driver database.Driver
+
+	switch driverName {
+	case "sqlite":
+		driver, err = sqliteDriver(db)
+	default:
+		driver, err = postgresDriver(db)
+	}
+	if err != nil {
+		sourceDriver.Close()
+		return nil, err
+	}
+
+	return migrate.NewWithInstance("iofs", sourceDriver, driverName, driver)
+}
+
+// postgresDriver runs migrations on a connection of its own, which closing
+// the driver returns to the pool. postgres.WithInstance would close db.
+func postgresDriver(db *sql.DB) (database.Driver, error) {
+	ctx := context.Background()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	driver, err := postgres.WithConnection(ctx, conn, &postgres.Config{})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return driver, nil
+}
+
+func sqliteDriver(db *sql.DB) (database.Driver, error) {
+	driver, err := sqlite.WithInstance(db, &sqlite.Config{})
+	if err != nil {
+		return nil, err
+	}
+
+	return borrowedDB{driver}, nil
+}
+
+// borrowedDB keeps the SQLite driver from closing the database it was
+// given; it holds nothing else.
+type borrowedDB struct {
+	database.Driver
+}
+
+func (borrowedDB) Close() error {
+	return nil
+}
+
+// closeMigrate closes m and joins the errors into err.
+func closeMigrate(m *migrate.Migrate, err *error) {
+	sourceErr, databaseErr := m.Close()
+	*err = errors.Join(*err, sourceErr, databaseErr)
+}
+
+// lockID is the PostgreSQL advisory lock key that serializes
+// startup migrations across API instances.
+const lockID = 4_817_220_913
+
+// Run brings the schema up to the latest embedded migration. With
+// PostgreSQL, instances starting together race for an advisory lock: the
+// winner applies pending migrations while the others wait for the schema to
+// reach the head version, for at most timeout. It refuses to continue if
+// the database is at a newer version than this binary knows about.
+func Run(db *sql.DB, driverName string, timeout time.Duration, logger *slog.Logger) (err error) {
+	head, err := Head(driverName)
+	if err != nil {
+		return err
+	}
+
+	m, err := New(db, driverName)
+	if err != nil {
+		return err
+	}
+	defer closeMigrate(m, &err)
+
+	// SQLite databases are local to a single instance, so there is no one
+	// to coordinate with.
+	if driverName == "sqlite" {
+		return migrateToHead(m, head)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for {
+		version, dirty, err := Version(m)
+		if err != nil {
+			return err
+		}
+
+		if version > head {
+			return schemaTooNewError(version, head)
+		}
+
+		// A dirty version is expected while another instance is migrating,
+		// so it is only treated as an error once this instance holds the lock.
+		if version == head && !dirty {
+			return nil
+		}
+
+		var locked bool
+		err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", lockID).Scan(&locked)
+		if err != nil {
+			return err
+		}
+
+		if locked {
+			defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", lockID)
+
+			logger.Info("acquired migration lock", "version", version, "head", head)
+			return migrateToHead(m, head)
+		}
+
+		logger.Info("waiting for another instance to apply migrations", "version", version, "head", head)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out after %s waiting for schema version %d (currently %d)", timeout, head, version)
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// Verify refuses a database that is ahead of this binary and
+// warns when it is behind, for use when migrations are not run on startup.
+func Verify(db *sql.DB, driverName string, logger *slog.Logger) (err error) {
+	head, err := Head(driverName)
+	if err != nil {
+		return err
+	}
+
+	m, err := New(db, driverName)
+	if err != nil {
+		return err
+	}
+	defer closeMigrate(m, &err)
+
+	version, dirty, err := Version(m)
+	switch {
+	case err != nil:
+		return err
+	case version > head:
+		return schemaTooNewError(version, head)
+	case version < head || dirty:
+		logger.Warn("database schema is not at the latest migration", "version", version, "head", head, "dirty", dirty)
+	}
+
+	return nil
+}
+
+func migrateToHead(m *migrate.Migrate, head uint) error {
+	version, _, err := Version(m)
+	if err != nil {
+		return err
+	}
+
+	if version > head {
+		return schemaTooNewError(version, head)
+	}
+
+	err = m.Up()
+	if err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return err
+	}
+
+	return nil
+}
+
+// Version returns the database's migration version, with 0 meaning
+// no migration has been applied yet.
+func Version(m *migrate.Migrate) (uint, bool, error) {
+	version, dirty, err := m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		return 0, false, nil
+	}
+
+	return version, dirty, err
+}
+
+func schemaTooNewError(version, head uint) error {
+	return fmt.Errorf("database schema version %d is newer than the latest migration %d known to this binary; refusing to start (was the deployment rolled back?)", version, head)
+}
+
+// Head returns the highest embedded migration version.
+func Head(driverName string) (uint, error) {
+	sourceDriver, err := Source(driverName)
+	if err != nil {
+		return 0, err
+	}
+	defer sourceDriver.Close()
+
+	version, err := sourceDriver.First()
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		next, err := sourceDriver.Next(version)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			return version, nil
+		case err != nil:
+			return 0, err
+		}
+
+		version = next
+	}
+}
@@ -0,0 +1,91 @@
+package migrations_test
+
+import (
+	"database/sql"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ab0utbla-k/rvt-hello-app/internal/testutils"
+	"github.com/ab0utbla-k/rvt-hello-app/migrations"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHead(t *testing.T) {
+	t.Parallel()
+
+	for _, driverName := range []string{"postgres", "sqlite"} {
+		head, err := migrations.Head(driverName)
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, head, uint(1), driverName)
+	}
+}
+
+func TestRun_RefusesNewerSchema(t *testing.T) {
+	t.Parallel()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "migrate.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	require.NoError(t, migrations.Run(db, "sqlite", time.Second, logger))
+	require.NoError(t, migrations.Run(db, "sqlite", time.Second, logger), "re-running at head should be a no-op")
+	require.NoError(t, migrations.Verify(db, "sqlite", logger))
+
+	// Simulate a rollback to a binary that predates the latest migration.
+	m, err := migrations.New(db, "sqlite")
+	require.NoError(t, err)
+	require.NoError(t, m.Force(99))
+
+	err = migrations.Run(db, "sqlite", time.Second, logger)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "newer than the latest migration")
+
+	err = migrations.Verify(db, "sqlite", logger)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "newer than the latest migration")
+}
+
+// TestRun_Concurrent starts several instances against the same
+// PostgreSQL database at once, as ECS does when scaling out.
+func TestRun_Concurrent(t *testing.T) {
+	db := testutils.SetupTestDB(t)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	const instances = 5
+
+	var wg sync.WaitGroup
+	errs := make(chan error, instances)
+
+	for range instances {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- migrations.Run(db, "postgres", 30*time.Second, logger)
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+
+	head, err := migrations.Head("postgres")
+	require.NoError(t, err)
+
+	m, err := migrations.New(db, "postgres")
+	require.NoError(t, err)
+
+	version, dirty, err := migrations.Version(m)
+	require.NoError(t, err)
+	assert.Equal(t, head, version)
+	assert.False(t, dirty)
+}
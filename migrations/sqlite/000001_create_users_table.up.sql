@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS users (
+    username TEXT PRIMARY KEY,
+    date_of_birth TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
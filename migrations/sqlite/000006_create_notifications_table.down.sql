@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS notifications;
+
+ALTER TABLE users DROP COLUMN time_zone;
+ALTER TABLE users DROP COLUMN email;
@@ -0,0 +1,11 @@
+ALTER TABLE users ADD COLUMN email TEXT;
+ALTER TABLE users ADD COLUMN time_zone TEXT;
+
+CREATE TABLE IF NOT EXISTS notifications (
+    username TEXT NOT NULL REFERENCES users ON DELETE CASCADE,
+    kind TEXT NOT NULL,
+    birthday TEXT NOT NULL,
+    claimed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    sent_at TIMESTAMP,
+    PRIMARY KEY (username, kind, birthday)
+);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS outbox (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    event_id TEXT NOT NULL UNIQUE,
+    event TEXT NOT NULL,
+    payload BLOB NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    dispatched_at INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS outbox_undispatched_idx ON outbox (id) WHERE dispatched_at IS NULL;